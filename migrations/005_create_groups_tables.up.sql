@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS groups (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    created_by VARCHAR(50) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS group_members (
+    group_id INTEGER NOT NULL REFERENCES groups (id) ON DELETE CASCADE,
+    username VARCHAR(50) NOT NULL,
+    role VARCHAR(20) NOT NULL DEFAULT 'member', /* 'creator', 'admin', 'member' */
+    joined_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (group_id, username)
+);
+
+CREATE INDEX IF NOT EXISTS idx_group_members_username ON group_members (username);
+
+-- Group messages are addressed to a group instead of a single receiver
+ALTER TABLE messages
+    ADD COLUMN IF NOT EXISTS group_id INTEGER REFERENCES groups (id) ON DELETE CASCADE,
+    ALTER COLUMN receiver DROP NOT NULL;
@@ -0,0 +1,75 @@
+CREATE TABLE IF NOT EXISTS conversations (
+    id SERIAL PRIMARY KEY,
+    kind VARCHAR(10) NOT NULL, /* 'direct', 'group' */
+    -- Participants of a direct conversation, in sorted order
+    user_low VARCHAR(50),
+    user_high VARCHAR(50),
+    group_id INTEGER UNIQUE REFERENCES groups (id) ON DELETE CASCADE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    last_message_at TIMESTAMP
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS unique_direct_conversation
+ON conversations (user_low, user_high) WHERE kind = 'direct';
+
+-- Per-user state of a conversation
+CREATE TABLE IF NOT EXISTS conversation_members (
+    conversation_id INTEGER NOT NULL REFERENCES conversations (id) ON DELETE CASCADE,
+    username VARCHAR(50) NOT NULL,
+    muted BOOLEAN NOT NULL DEFAULT FALSE,
+    pinned BOOLEAN NOT NULL DEFAULT FALSE,
+    archived BOOLEAN NOT NULL DEFAULT FALSE,
+    last_read_message_id INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (conversation_id, username)
+);
+
+CREATE INDEX IF NOT EXISTS idx_conversation_members_username ON conversation_members (username);
+
+ALTER TABLE messages
+    ADD COLUMN IF NOT EXISTS conversation_id INTEGER REFERENCES conversations (id) ON DELETE CASCADE;
+
+-- Backfill conversations for the existing direct messages and groups
+INSERT INTO conversations (kind, user_low, user_high)
+SELECT DISTINCT 'direct', LEAST(sender, receiver), GREATEST(sender, receiver)
+FROM messages
+WHERE group_id IS NULL AND receiver IS NOT NULL
+ON CONFLICT (user_low, user_high) WHERE kind = 'direct' DO NOTHING;
+
+INSERT INTO conversations (kind, group_id)
+SELECT 'group', id FROM groups
+ON CONFLICT (group_id) DO NOTHING;
+
+UPDATE messages m
+SET conversation_id = c.id
+FROM conversations c
+WHERE m.conversation_id IS NULL
+AND m.group_id IS NULL
+AND c.kind = 'direct'
+AND c.user_low = LEAST(m.sender, m.receiver)
+AND c.user_high = GREATEST(m.sender, m.receiver);
+
+UPDATE messages m
+SET conversation_id = c.id
+FROM conversations c
+WHERE m.conversation_id IS NULL
+AND m.group_id = c.group_id;
+
+UPDATE conversations c
+SET last_message_at = (SELECT MAX(sent_at) FROM messages m WHERE m.conversation_id = c.id);
+
+-- Existing history counts as read so nobody starts with a wall of unread messages
+INSERT INTO conversation_members (conversation_id, username, last_read_message_id)
+SELECT c.id, u.username, COALESCE((SELECT MAX(id) FROM messages m WHERE m.conversation_id = c.id), 0)
+FROM conversations c
+CROSS JOIN LATERAL (VALUES (c.user_low), (c.user_high)) AS u (username)
+WHERE c.kind = 'direct'
+ON CONFLICT (conversation_id, username) DO NOTHING;
+
+INSERT INTO conversation_members (conversation_id, username, last_read_message_id)
+SELECT c.id, gm.username, COALESCE((SELECT MAX(id) FROM messages m WHERE m.conversation_id = c.id), 0)
+FROM conversations c
+JOIN group_members gm ON gm.group_id = c.group_id
+ON CONFLICT (conversation_id, username) DO NOTHING;
+
+CREATE INDEX IF NOT EXISTS idx_messages_conversation ON messages (conversation_id, id);
+CREATE INDEX IF NOT EXISTS idx_messages_receiver ON messages (receiver, status);
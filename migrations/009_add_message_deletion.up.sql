@@ -0,0 +1,13 @@
+-- Messages deleted for everyone keep their row as a tombstone
+ALTER TABLE messages
+    ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+
+-- Messages a user deleted only from their own history
+CREATE TABLE IF NOT EXISTS message_hides (
+    message_id INTEGER NOT NULL REFERENCES messages (id) ON DELETE CASCADE,
+    username VARCHAR(50) NOT NULL,
+    hidden_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (message_id, username)
+);
+
+CREATE INDEX IF NOT EXISTS idx_message_hides_username ON message_hides (username, message_id);
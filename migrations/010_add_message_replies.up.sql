@@ -0,0 +1,5 @@
+-- A reply quotes an earlier message of the same conversation
+ALTER TABLE messages
+    ADD COLUMN IF NOT EXISTS reply_to INTEGER REFERENCES messages (id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_messages_reply_to ON messages (reply_to, id) WHERE reply_to IS NOT NULL;
@@ -0,0 +1,14 @@
+-- Refresh tokens rotate on every use; all the tokens descending from one login
+-- share a family, which is revoked as a whole if a rotated token is replayed
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    id SERIAL PRIMARY KEY,
+    username VARCHAR(50) NOT NULL,
+    token_hash CHAR(64) NOT NULL UNIQUE, /* SHA-256 of the token */
+    family_id VARCHAR(64) NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_username ON refresh_tokens (username) WHERE revoked_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family ON refresh_tokens (family_id);
@@ -0,0 +1,10 @@
+-- Failed logins are counted per username and per client IP; once a subject
+-- has failed often enough it is locked until locked_until
+CREATE TABLE IF NOT EXISTS login_failures (
+    scope VARCHAR(10) NOT NULL, /* 'user' or 'ip' */
+    subject VARCHAR(100) NOT NULL,
+    failures INTEGER NOT NULL DEFAULT 0,
+    last_failed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    locked_until TIMESTAMP,
+    PRIMARY KEY (scope, subject)
+);
@@ -0,0 +1,14 @@
+-- A block stops all messaging, presence and typing between two users
+CREATE TABLE IF NOT EXISTS blocks (
+    blocker VARCHAR(255) NOT NULL,
+    blocked VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (blocker, blocked)
+);
+
+CREATE INDEX IF NOT EXISTS idx_blocks_blocked ON blocks (blocked);
+
+-- Who may start a direct conversation with the user: 'everyone', 'contacts'
+-- (an accepted follow either way) or 'mutual' (accepted both ways)
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS message_privacy VARCHAR(20) NOT NULL DEFAULT 'everyone';
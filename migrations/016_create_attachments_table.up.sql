@@ -0,0 +1,17 @@
+-- Files uploaded for chats. An attachment belongs to its uploader until a
+-- message references it; the bytes live in the blob store under storage_key.
+CREATE TABLE IF NOT EXISTS attachments (
+    id VARCHAR(32) PRIMARY KEY,
+    uploader VARCHAR(255) NOT NULL,
+    filename VARCHAR(255) NOT NULL,
+    content_type VARCHAR(255) NOT NULL,
+    size BIGINT NOT NULL,
+    storage_key VARCHAR(255) NOT NULL,
+    thumbnail_key VARCHAR(255) NOT NULL DEFAULT '',
+    width INTEGER NOT NULL DEFAULT 0,
+    height INTEGER NOT NULL DEFAULT 0,
+    message_id INTEGER REFERENCES messages(id) ON DELETE CASCADE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_attachments_message ON attachments (message_id);
@@ -0,0 +1,2 @@
+-- Metadata of the first link in a message, fetched after it is sent
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS link_preview JSONB;
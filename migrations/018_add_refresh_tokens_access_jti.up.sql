@@ -0,0 +1,5 @@
+-- The jti of the access token handed out with each refresh token, so signing
+-- out a device can revoke the refresh token family it logged in with
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS access_jti VARCHAR(64);
+
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_access_jti ON refresh_tokens (access_jti);
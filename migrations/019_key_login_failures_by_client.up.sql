@@ -0,0 +1,10 @@
+-- Failed logins of a username are counted per client IP, so someone guessing
+-- a password from one address doesn't lock its owner out everywhere. The ip
+-- column is empty for the 'ip' scope, whose subject already is the address.
+ALTER TABLE login_failures ADD COLUMN IF NOT EXISTS ip VARCHAR(45) NOT NULL DEFAULT '';
+
+-- Counts kept per username alone no longer apply
+DELETE FROM login_failures WHERE scope = 'user' AND ip = '';
+
+ALTER TABLE login_failures DROP CONSTRAINT IF EXISTS login_failures_pkey;
+ALTER TABLE login_failures ADD PRIMARY KEY (scope, subject, ip);
@@ -0,0 +1,4 @@
+-- How long the chats of a conversation stay in the Redis hot tier. NULL uses
+-- the server default, REDIS_CHAT_RETENTION.
+ALTER TABLE conversations ADD COLUMN IF NOT EXISTS chat_retention_seconds INTEGER
+    CHECK (chat_retention_seconds > 0);
@@ -0,0 +1,19 @@
+package model
+
+// Attachment is a file uploaded to be sent with a chat. The URLs are signed
+// and expire, so they are filled in each time an attachment is handed out.
+type Attachment struct {
+	ID           string `json:"id"`
+	Filename     string `json:"filename"`
+	ContentType  string `json:"content_type"`
+	Size         int64  `json:"size"`
+	Width        int    `json:"width,omitempty"` // of images only
+	Height       int    `json:"height,omitempty"`
+	URL          string `json:"url,omitempty"`
+	ThumbnailURL string `json:"thumbnail_url,omitempty"`
+
+	Uploader     string `json:"-"`
+	StorageKey   string `json:"-"`
+	ThumbnailKey string `json:"-"`
+	MessageID    string `json:"-"` // empty until a chat references the attachment
+}
@@ -1,14 +1,66 @@
 package model
 
+// Chat delivery states, in the order a message moves through them
+const (
+	ChatStatusSent      = "sent"
+	ChatStatusDelivered = "delivered"
+	ChatStatusRead      = "read"
+)
+
+// Scopes of a message deletion
+const (
+	DeleteScopeMe       = "me"       // hidden from the caller's history only
+	DeleteScopeEveryone = "everyone" // replaced by a tombstone for every member
+)
+
 type Chat struct {
-	ID        string `json:"id"`
-	From      string `json:"from"`
-	To        string `json:"to"`
-	Msg       string `json:"message"`
-	Timestamp float64  `json:"timestamp"`
+	ID             string       `json:"id"`
+	From           string       `json:"from"`
+	To             string       `json:"to"`
+	Msg            string       `json:"message"`
+	Timestamp      float64      `json:"timestamp"`
+	Status         string       `json:"status,omitempty"`
+	GroupID        string       `json:"group_id,omitempty"` // set instead of To for group messages
+	ConversationID string       `json:"conversation_id,omitempty"`
+	Edited         bool         `json:"edited,omitempty"`
+	EditedAt       float64      `json:"edited_at,omitempty"`
+	Deleted        bool         `json:"deleted,omitempty"` // a tombstone, the text is gone
+	ReplyTo        string       `json:"reply_to,omitempty"`
+	Quote          *Quote       `json:"quote,omitempty"` // preview of the ReplyTo message
+	Reactions      []Reaction   `json:"reactions,omitempty"`
+	AttachmentIDs  []string     `json:"attachment_ids,omitempty"` // uploads sent with the chat
+	Attachments    []Attachment `json:"attachments,omitempty"`
+	Preview        *LinkPreview `json:"preview,omitempty"` // of the first link in the message
+}
+
+// Reaction aggregates the users who reacted to a message with one emoji
+type Reaction struct {
+	Emoji   string `json:"emoji"`
+	Count   int    `json:"count"`
+	Reacted bool   `json:"reacted"` // whether the user reading the message is one of them
+}
+
+// QuoteLength is how many characters of a quoted message a preview keeps
+const QuoteLength = 100
+
+// Quote is a compact preview of the message a reply points at
+type Quote struct {
+	ID      string `json:"id"`
+	From    string `json:"from"`
+	Msg     string `json:"message"`
+	Deleted bool   `json:"deleted,omitempty"`
+}
+
+// ChatEdit is a prior revision of an edited chat
+type ChatEdit struct {
+	MessageID string  `json:"message_id"`
+	Msg       string  `json:"message"`
+	EditedAt  float64 `json:"edited_at"` // when this revision was replaced
 }
 
 type ContactList struct {
-	Username     string `json:"username"`
-	LastActivity float64  `json:"last_activity"`
+	Username       string  `json:"username"`
+	LastActivity   float64 `json:"last_activity"`
+	ConversationID string  `json:"conversation_id,omitempty"`
+	Unread         int     `json:"unread"`
 }
@@ -0,0 +1,30 @@
+package model
+
+// Conversation kinds
+const (
+	ConversationDirect = "direct"
+	ConversationGroup  = "group"
+)
+
+// Conversation is a thread as seen by one of its members
+type Conversation struct {
+	ID           string  `json:"id"`
+	Kind         string  `json:"kind"`
+	Peer         string  `json:"peer,omitempty"`     // the other user of a direct conversation
+	GroupID      string  `json:"group_id,omitempty"` // set for group conversations
+	Name         string  `json:"name,omitempty"`     // group name
+	LastActivity float64 `json:"last_activity"`
+	Muted        bool    `json:"muted"`
+	Pinned       bool    `json:"pinned"`
+	Archived     bool    `json:"archived"`
+	LastReadID   string  `json:"last_read_id"`
+	Unread       int     `json:"unread"`
+}
+
+// UnreadCount is pushed to a user whenever the unread count of one of their
+// conversations changes, along with the total over all of them
+type UnreadCount struct {
+	ConversationID string `json:"conversation_id"`
+	Unread         int    `json:"unread"`
+	Total          int    `json:"total"`
+}
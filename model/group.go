@@ -0,0 +1,24 @@
+package model
+
+// Group member roles. The creator can do everything an admin can, and is the
+// only one who can promote or demote admins.
+const (
+	GroupRoleCreator = "creator"
+	GroupRoleAdmin   = "admin"
+	GroupRoleMember  = "member"
+)
+
+type Group struct {
+	ID             string        `json:"id"`
+	Name           string        `json:"name"`
+	CreatedBy      string        `json:"created_by"`
+	CreatedAt      float64       `json:"created_at"`
+	ConversationID string        `json:"conversation_id"`
+	Members        []GroupMember `json:"members,omitempty"`
+}
+
+type GroupMember struct {
+	Username string  `json:"username"`
+	Role     string  `json:"role"`
+	JoinedAt float64 `json:"joined_at"`
+}
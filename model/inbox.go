@@ -0,0 +1,32 @@
+package model
+
+// Kinds of inbox entries
+const (
+	InboxContact  = "contact"  // an accepted follow, either way
+	InboxRequest  = "request"  // someone asking to follow the user
+	InboxStranger = "stranger" // a direct conversation with someone who isn't a contact
+	InboxGroup    = "group"
+)
+
+// InboxEntry is a contact, follow request, direct conversation or group as
+// listed in a user's inbox
+type InboxEntry struct {
+	Kind           string  `json:"kind"`
+	Username       string  `json:"username,omitempty"` // the other user, unless a group
+	GroupID        string  `json:"group_id,omitempty"`
+	Name           string  `json:"name,omitempty"` // group name
+	ConversationID string  `json:"conversation_id,omitempty"`
+	LastMessage    *Quote  `json:"last_message,omitempty"` // preview of the latest message
+	LastActivity   float64 `json:"last_activity"`
+	Unread         int     `json:"unread"`
+	Muted          bool    `json:"muted,omitempty"`
+	Pinned         bool    `json:"pinned,omitempty"`
+	Archived       bool    `json:"archived,omitempty"`
+	// Key identifies the entry within the inbox, for paging
+	Key string `json:"-"`
+}
+
+// Position returns where the entry sits in the inbox
+func (e InboxEntry) Position() InboxPosition {
+	return InboxPosition{Activity: e.LastActivity, Key: e.Key}
+}
@@ -0,0 +1,27 @@
+package model
+
+// ChatRange selects a page of a conversation's history by message ID. Message
+// IDs only grow, so they give a total order across both storage tiers. The
+// bounds are exclusive and zero means unbounded.
+type ChatRange struct {
+	AfterID   int64
+	BeforeID  int64
+	Limit     int
+	Ascending bool   // oldest first; otherwise newest first
+	Viewer    string // leaves out the messages this user deleted for themselves
+}
+
+// InboxPosition is where an entry sits in a user's inbox, which lists the
+// latest activity first and breaks ties by entry key
+type InboxPosition struct {
+	Activity float64
+	Key      string
+}
+
+// InboxRange selects a page of a user's inbox. The bounds are exclusive and
+// nil means unbounded; entries come in inbox order either way.
+type InboxRange struct {
+	After  *InboxPosition
+	Before *InboxPosition
+	Limit  int
+}
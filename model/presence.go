@@ -0,0 +1,14 @@
+package model
+
+// Presence states of a user
+const (
+	PresenceOnline  = "online"
+	PresenceAway    = "away"
+	PresenceOffline = "offline"
+)
+
+type Presence struct {
+	Username string  `json:"username"`
+	Status   string  `json:"status"`
+	LastSeen float64 `json:"last_seen,omitempty"` // left out when the user hides it
+}
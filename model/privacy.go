@@ -0,0 +1,13 @@
+package model
+
+// Who a user accepts direct messages from
+const (
+	MessagePrivacyEveryone = "everyone"
+	MessagePrivacyContacts = "contacts" // an accepted follow request either way
+	MessagePrivacyMutual   = "mutual"   // accepted follow requests both ways
+)
+
+// ValidMessagePrivacy reports whether s is one of the privacy settings
+func ValidMessagePrivacy(s string) bool {
+	return s == MessagePrivacyEveryone || s == MessagePrivacyContacts || s == MessagePrivacyMutual
+}
@@ -0,0 +1,20 @@
+package model
+
+// SearchFilter narrows a message search to the caller's own conversations
+type SearchFilter struct {
+	Query   string
+	Contact string // only the direct conversation with this user
+	Sender  string
+	FromTS  string
+	ToTS    string
+	Limit   int
+	Offset  int
+}
+
+type SearchHit struct {
+	Chat    Chat    `json:"chat"`
+	Rank    float64 `json:"rank"`
+	Snippet string  `json:"snippet"` // HTML-escaped, with matches wrapped in <mark></mark>
+	// HistoryURL opens the conversation history at the matched message
+	HistoryURL string `json:"history_url"`
+}
@@ -0,0 +1,15 @@
+package model
+
+// Session is one connected device of a user
+type Session struct {
+	ID          string  `json:"id"`
+	Device      string  `json:"device"`
+	RemoteAddr  string  `json:"remote_addr"`
+	ConnectedAt float64 `json:"connected_at"`
+	LastActive  float64 `json:"last_active"` // last heartbeat of the socket
+
+	// The access token the socket was opened with, revoked along with the
+	// session. Kept in Redis only, the API leaves them out.
+	TokenID        string  `json:"token_id,omitempty"`
+	TokenExpiresAt float64 `json:"token_expires_at,omitempty"`
+}
@@ -0,0 +1,154 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+
+	"gochatapp/model"
+
+	"github.com/lib/pq"
+)
+
+// ErrAttachmentNotFound is returned for attachments that don't exist, or
+// that can't be sent because another user uploaded them or they were
+// already sent with a chat
+var ErrAttachmentNotFound = errors.New("attachment not found")
+
+const attachmentColumns = `id, uploader, filename, content_type, size, storage_key, thumbnail_key,
+				width, height, COALESCE(message_id::text, '')`
+
+func scanAttachment(row rowScanner) (*model.Attachment, error) {
+	var a model.Attachment
+	err := row.Scan(&a.ID, &a.Uploader, &a.Filename, &a.ContentType, &a.Size, &a.StorageKey,
+		&a.ThumbnailKey, &a.Width, &a.Height, &a.MessageID)
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// StoreAttachment records an uploaded file, not yet sent with any chat
+func StoreAttachment(db *sql.DB, a *model.Attachment) error {
+	query := `INSERT INTO attachments (id, uploader, filename, content_type, size, storage_key, thumbnail_key, width, height)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+	_, err := db.Exec(query, a.ID, a.Uploader, a.Filename, a.ContentType, a.Size, a.StorageKey,
+		a.ThumbnailKey, a.Width, a.Height)
+	if err != nil {
+		log.Println("Error storing attachment:", err)
+	}
+	return err
+}
+
+// FetchAttachment returns an attachment by ID
+func FetchAttachment(db *sql.DB, id string) (*model.Attachment, error) {
+	query := `SELECT ` + attachmentColumns + ` FROM attachments WHERE id = $1`
+
+	a, err := scanAttachment(db.QueryRow(query, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrAttachmentNotFound
+	}
+	if err != nil {
+		log.Println("Error fetching attachment:", err)
+		return nil, err
+	}
+	return a, nil
+}
+
+// FetchUnsentAttachments returns the attachments uploader is about to send,
+// in the order asked for. It fails with ErrAttachmentNotFound unless every
+// one of them was uploaded by uploader and hasn't been sent yet.
+func FetchUnsentAttachments(db *sql.DB, uploader string, ids []string) ([]model.Attachment, error) {
+	query := `SELECT ` + attachmentColumns + ` FROM attachments
+				WHERE id = ANY($1) AND uploader = $2 AND message_id IS NULL`
+
+	rows, err := db.Query(query, pq.Array(ids), uploader)
+	if err != nil {
+		log.Println("Error fetching attachments:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	byID := make(map[string]model.Attachment, len(ids))
+	for rows.Next() {
+		a, err := scanAttachment(rows)
+		if err != nil {
+			log.Println("Error scanning attachment:", err)
+			return nil, err
+		}
+		byID[a.ID] = *a
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	attachments := make([]model.Attachment, 0, len(ids))
+	for _, id := range ids {
+		a, ok := byID[id]
+		if !ok {
+			return nil, ErrAttachmentNotFound
+		}
+		attachments = append(attachments, a)
+	}
+	return attachments, nil
+}
+
+// linkAttachments marks the attachments of a chat being stored as sent with
+// it, so they can't be sent again
+func linkAttachments(tx *sql.Tx, c *model.Chat) error {
+	if len(c.Attachments) == 0 {
+		return nil
+	}
+
+	ids := make([]string, len(c.Attachments))
+	for i, a := range c.Attachments {
+		ids[i] = a.ID
+	}
+
+	query := `UPDATE attachments SET message_id = $1
+				WHERE id = ANY($2) AND uploader = $3 AND message_id IS NULL`
+	res, err := tx.Exec(query, c.ID, pq.Array(ids), c.From)
+	if err != nil {
+		log.Println("Error linking attachments:", err)
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if int(n) != len(ids) {
+		// Sent with another chat since they were checked
+		return ErrAttachmentNotFound
+	}
+	return nil
+}
+
+// FetchMessageAttachments returns the attachments of the given messages
+// keyed by message ID, in upload order
+func FetchMessageAttachments(ids []string) (map[string][]model.Attachment, error) {
+	attachments := make(map[string][]model.Attachment)
+	if len(ids) == 0 {
+		return attachments, nil
+	}
+
+	query := `SELECT ` + attachmentColumns + ` FROM attachments
+				WHERE message_id = ANY($1::integer[])
+				ORDER BY created_at, id`
+
+	rows, err := DB.Query(query, pq.Array(ids))
+	if err != nil {
+		log.Println("Error fetching message attachments:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		a, err := scanAttachment(rows)
+		if err != nil {
+			log.Println("Error scanning attachment:", err)
+			return nil, err
+		}
+		attachments[a.MessageID] = append(attachments[a.MessageID], *a)
+	}
+	return attachments, rows.Err()
+}
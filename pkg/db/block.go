@@ -0,0 +1,143 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+
+	"gochatapp/model"
+
+	"github.com/lib/pq"
+)
+
+var (
+	// ErrBlocked is returned when either user has blocked the other
+	ErrBlocked = errors.New("user is blocked")
+	// ErrMessagingRestricted is returned when the receiver only accepts
+	// messages from contacts the sender isn't one of
+	ErrMessagingRestricted = errors.New("receiver only accepts messages from contacts")
+)
+
+// BlockUser blocks a user and drops any follow requests between the two
+func BlockUser(db *sql.DB, username, blocked string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println("Error starting block transaction:", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`INSERT INTO blocks (blocker, blocked) VALUES ($1, $2)
+				ON CONFLICT (blocker, blocked) DO NOTHING`, username, blocked)
+	if err != nil {
+		log.Println("Error blocking user:", err)
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM contacts
+				WHERE (username = $1 AND contact_username = $2) OR (username = $2 AND contact_username = $1)`,
+		username, blocked)
+	if err != nil {
+		log.Println("Error removing contacts of blocked user:", err)
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UnblockUser lifts a block
+func UnblockUser(db *sql.DB, username, blocked string) error {
+	_, err := db.Exec("DELETE FROM blocks WHERE blocker = $1 AND blocked = $2", username, blocked)
+	if err != nil {
+		log.Println("Error unblocking user:", err)
+	}
+	return err
+}
+
+// FetchBlockedUsers returns the users username has blocked, most recent first
+func FetchBlockedUsers(db *sql.DB, username string) ([]string, error) {
+	rows, err := db.Query("SELECT blocked FROM blocks WHERE blocker = $1 ORDER BY created_at DESC", username)
+	if err != nil {
+		log.Println("Error fetching blocked users:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	blocked := []string{}
+	for rows.Next() {
+		var b string
+		if err := rows.Scan(&b); err != nil {
+			return nil, err
+		}
+		blocked = append(blocked, b)
+	}
+	return blocked, rows.Err()
+}
+
+// FetchBlockedBetween returns which of the others have blocked username or
+// been blocked by them
+func FetchBlockedBetween(db *sql.DB, username string, others []string) (map[string]bool, error) {
+	blocked := make(map[string]bool)
+	if len(others) == 0 {
+		return blocked, nil
+	}
+
+	query := `
+		SELECT blocked FROM blocks WHERE blocker = $1 AND blocked = ANY($2)
+		UNION
+		SELECT blocker FROM blocks WHERE blocked = $1 AND blocker = ANY($2);
+	`
+	rows, err := db.Query(query, username, pq.Array(others))
+	if err != nil {
+		log.Println("Error fetching blocks:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var other string
+		if err := rows.Scan(&other); err != nil {
+			return nil, err
+		}
+		blocked[other] = true
+	}
+	return blocked, rows.Err()
+}
+
+// SetMessagePrivacy sets who may send direct messages to a user
+func SetMessagePrivacy(db *sql.DB, username, privacy string) error {
+	_, err := db.Exec("UPDATE users SET message_privacy = $2 WHERE username = $1", username, privacy)
+	if err != nil {
+		log.Println("Error updating message privacy:", err)
+	}
+	return err
+}
+
+// CanMessage checks that from may send a direct message to to, returning
+// ErrBlocked or ErrMessagingRestricted if not
+func CanMessage(db *sql.DB, from, to string) error {
+	var blocked, forward, backward bool
+	var privacy string
+	query := `
+		SELECT
+			EXISTS (SELECT 1 FROM blocks WHERE (blocker = $1 AND blocked = $2) OR (blocker = $2 AND blocked = $1)),
+			EXISTS (SELECT 1 FROM contacts WHERE username = $1 AND contact_username = $2 AND status = 'accepted'),
+			EXISTS (SELECT 1 FROM contacts WHERE username = $2 AND contact_username = $1 AND status = 'accepted'),
+			COALESCE((SELECT message_privacy FROM users WHERE username = $2), $3);
+	`
+	err := db.QueryRow(query, from, to, model.MessagePrivacyEveryone).Scan(&blocked, &forward, &backward, &privacy)
+	if err != nil {
+		log.Println("Error checking messaging permission:", err)
+		return err
+	}
+
+	switch {
+	case blocked:
+		return ErrBlocked
+	case privacy == model.MessagePrivacyContacts && !forward && !backward:
+		return ErrMessagingRestricted
+	case privacy == model.MessagePrivacyMutual && !(forward && backward):
+		return ErrMessagingRestricted
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package db
+
+import (
+	"testing"
+
+	"gochatapp/model"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestCanMessage(t *testing.T) {
+	cases := []struct {
+		name                       string
+		blocked, forward, backward bool
+		privacy                    string
+		want                       error
+	}{
+		{"open", false, false, false, model.MessagePrivacyEveryone, nil},
+		{"blocked", true, true, true, model.MessagePrivacyEveryone, ErrBlocked},
+		{"contacts only, strangers", false, false, false, model.MessagePrivacyContacts, ErrMessagingRestricted},
+		{"contacts only, following", false, true, false, model.MessagePrivacyContacts, nil},
+		{"contacts only, followed", false, false, true, model.MessagePrivacyContacts, nil},
+		{"mutual, one way", false, true, false, model.MessagePrivacyMutual, ErrMessagingRestricted},
+		{"mutual, both ways", false, true, true, model.MessagePrivacyMutual, nil},
+		{"mutual but blocked", true, true, true, model.MessagePrivacyMutual, ErrBlocked},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			mock := mockDB(t)
+			mock.ExpectQuery("FROM blocks").
+				WithArgs("user1", "user2", model.MessagePrivacyEveryone).
+				WillReturnRows(sqlmock.NewRows([]string{"blocked", "forward", "backward", "privacy"}).
+					AddRow(c.blocked, c.forward, c.backward, c.privacy))
+
+			if err := CanMessage(DB, "user1", "user2"); err != c.want {
+				t.Errorf("expected %v, got %v", c.want, err)
+			}
+		})
+	}
+}
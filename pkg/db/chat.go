@@ -1,85 +1,427 @@
 package db
 
 import (
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"gochatapp/model"
 	"log"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var (
+	// ErrChatNotFound is returned when a message doesn't exist
+	ErrChatNotFound = errors.New("message not found")
+	// ErrNotChatSender is returned when a user changes a message they didn't send
+	ErrNotChatSender = errors.New("not the sender of this message")
+	// ErrDeleteWindowPassed is returned when a message is too old to delete for everyone
+	ErrDeleteWindowPassed = errors.New("message can no longer be deleted for everyone")
+	// ErrGroupReceipt is returned for receipts on group messages, which keep
+	// no delivery or read state per member
+	ErrGroupReceipt = errors.New("receipts are only kept for direct messages")
 )
 
-// StoreChatInPostgres stores the chat data in PostgreSQL
-func StoreChatInPostgres(c *model.Chat) error {
+// StoreChatInPostgres stores the chat data in PostgreSQL. It returns how long
+// the chats of its conversation stay cached, or zero for the default.
+func StoreChatInPostgres(c *model.Chat) (time.Duration, error) {
 	fmt.Println(c)
-	query := `INSERT INTO messages (sender, receiver, content, sent_at)
-          VALUES ($1, $2, $3, to_timestamp($4)) RETURNING id`
+	query := `WITH m AS (
+				INSERT INTO messages (sender, receiver, content, sent_at, group_id, conversation_id, reply_to)
+				VALUES ($1, NULLIF($2, ''), $3, to_timestamp($4), NULLIF($5, '')::integer, $6, NULLIF($7, '')::integer)
+				RETURNING id, conversation_id, sent_at
+			)
+			UPDATE conversations c SET last_message_at = m.sent_at
+			FROM m WHERE c.id = m.conversation_id
+			RETURNING m.id, COALESCE(c.chat_retention_seconds, 0)`
 
 
+	tx, err := DB.Begin()
+	if err != nil {
+		log.Println("Error starting store chat transaction:", err)
+		return 0, err
+	}
+	defer tx.Rollback()
+
 	// Execute the query and retrieve the generated ID
-	err := DB.QueryRow(query, c.From, c.To, c.Msg, c.Timestamp).Scan(&c.ID)
+	var retention int64
+	err = tx.QueryRow(query, c.From, c.To, c.Msg, c.Timestamp, c.GroupID, c.ConversationID, c.ReplyTo).Scan(&c.ID, &retention)
 	if err != nil {
 		log.Println("Error storing chat in PostgreSQL:", err)
-		return err
+		return 0, err
+	}
+
+	// The chat and its attachments are stored together or not at all
+	if err := linkAttachments(tx, c); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return time.Duration(retention) * time.Second, nil
+}
+
+// chatColumns are the messages columns read into a model.Chat by scanChat
+const chatColumns = `id, sender, COALESCE(receiver, ''), content, extract(epoch from sent_at) as timestamp, status,
+				COALESCE(group_id::text, ''), conversation_id, COALESCE(extract(epoch from edited_at), 0),
+				deleted_at IS NOT NULL, COALESCE(reply_to::text, ''), link_preview`
+
+func scanChat(row rowScanner) (*model.Chat, error) {
+	var chat model.Chat
+	var preview []byte
+	err := row.Scan(&chat.ID, &chat.From, &chat.To, &chat.Msg, &chat.Timestamp, &chat.Status,
+		&chat.GroupID, &chat.ConversationID, &chat.EditedAt, &chat.Deleted, &chat.ReplyTo, &preview)
+	if err != nil {
+		return nil, err
+	}
+	chat.Edited = chat.EditedAt > 0
+	if preview != nil {
+		if err := json.Unmarshal(preview, &chat.Preview); err != nil {
+			log.Println("Error decoding link preview of chat", chat.ID, err)
+		}
+	}
+	return &chat, nil
+}
+
+// SetChatPreview stores the link preview of a chat, or clears it if preview is
+// nil. It reports false, storing nothing, if the chat was edited away from
+// text or deleted since the preview was fetched for it.
+func SetChatPreview(id, text string, preview *model.LinkPreview) (bool, error) {
+	var value interface{}
+	if preview != nil {
+		by, err := json.Marshal(preview)
+		if err != nil {
+			return false, err
+		}
+		value = string(by)
+	}
+
+	query := `UPDATE messages SET link_preview = $3::jsonb
+				WHERE id = $1 AND content = $2 AND deleted_at IS NULL`
+	res, err := DB.Exec(query, id, text, value)
+	if err != nil {
+		log.Println("Error storing link preview:", err)
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// FetchChat retrieves a single message by ID
+func FetchChat(id string) (*model.Chat, error) {
+	query := `SELECT ` + chatColumns + ` FROM messages WHERE id = $1`
+
+	chat, err := scanChat(DB.QueryRow(query, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrChatNotFound
+	}
+	if err != nil {
+		log.Println("Error fetching chat:", err)
+		return nil, err
+	}
+	return chat, nil
+}
+
+// EditChat replaces the text of a message sent by editor, keeping the
+// previous text as a revision
+func EditChat(id, editor, content string) (*model.Chat, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		log.Println("Error starting edit chat transaction:", err)
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var sender, previous string
+	query := `SELECT sender, content FROM messages WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`
+	err = tx.QueryRow(query, id).Scan(&sender, &previous)
+	if err == sql.ErrNoRows {
+		return nil, ErrChatNotFound
+	}
+	if err != nil {
+		log.Println("Error fetching chat to edit:", err)
+		return nil, err
+	}
+	if sender != editor {
+		return nil, ErrNotChatSender
+	}
+
+	if _, err := tx.Exec(`INSERT INTO message_edits (message_id, content) VALUES ($1, $2)`, id, previous); err != nil {
+		log.Println("Error storing chat revision:", err)
+		return nil, err
+	}
+
+	query = `UPDATE messages SET content = $2, edited_at = NOW() WHERE id = $1
+				RETURNING ` + chatColumns
+	chat, err := scanChat(tx.QueryRow(query, id, content))
+	if err != nil {
+		log.Println("Error editing chat:", err)
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Println("Error committing edit chat transaction:", err)
+		return nil, err
+	}
+	return chat, nil
+}
+
+// DeleteChatForEveryone replaces a message sent by username with a tombstone,
+// dropping its text, revisions and reactions. It must have been sent within window.
+func DeleteChatForEveryone(id, username string, window time.Duration) (*model.Chat, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		log.Println("Error starting delete chat transaction:", err)
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var sender string
+	var inWindow bool
+	query := `SELECT sender, sent_at > NOW() - make_interval(secs => $2) FROM messages
+				WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`
+	err = tx.QueryRow(query, id, window.Seconds()).Scan(&sender, &inWindow)
+	if err == sql.ErrNoRows {
+		return nil, ErrChatNotFound
+	}
+	if err != nil {
+		log.Println("Error fetching chat to delete:", err)
+		return nil, err
+	}
+	if sender != username {
+		return nil, ErrNotChatSender
+	}
+	if !inWindow {
+		return nil, ErrDeleteWindowPassed
+	}
+
+	if _, err := tx.Exec(`DELETE FROM message_edits WHERE message_id = $1`, id); err != nil {
+		log.Println("Error deleting chat revisions:", err)
+		return nil, err
+	}
+	if _, err := tx.Exec(`DELETE FROM message_reactions WHERE message_id = $1`, id); err != nil {
+		log.Println("Error deleting chat reactions:", err)
+		return nil, err
+	}
+
+	query = `UPDATE messages SET content = '', edited_at = NULL, link_preview = NULL, deleted_at = NOW() WHERE id = $1
+				RETURNING ` + chatColumns
+	chat, err := scanChat(tx.QueryRow(query, id))
+	if err != nil {
+		log.Println("Error deleting chat:", err)
+		return nil, err
 	}
 
+	if err := tx.Commit(); err != nil {
+		log.Println("Error committing delete chat transaction:", err)
+		return nil, err
+	}
+	return chat, nil
+}
+
+// HideChat removes a message from the history of username only
+func HideChat(id, username string) error {
+	query := `INSERT INTO message_hides (message_id, username) VALUES ($1, $2)
+				ON CONFLICT (message_id, username) DO NOTHING`
+	if _, err := DB.Exec(query, id, username); err != nil {
+		log.Println("Error hiding chat:", err)
+		return err
+	}
 	return nil
 }
 
-// FetchChatBetween retrieves chat history between two users from PostgreSQL
-func FetchChatBetween(u1, u2, fromTS, toTS string) ([]model.Chat, error) {
+// FetchChatEdits returns the prior revisions of a message, oldest first
+func FetchChatEdits(id string) ([]model.ChatEdit, error) {
+	query := `SELECT message_id, content, extract(epoch from edited_at)
+				FROM message_edits
+				WHERE message_id = $1
+				ORDER BY id`
+
+	rows, err := DB.Query(query, id)
+	if err != nil {
+		log.Println("Error fetching chat revisions:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	edits := []model.ChatEdit{}
+	for rows.Next() {
+		var e model.ChatEdit
+		if err := rows.Scan(&e.MessageID, &e.Msg, &e.EditedAt); err != nil {
+			log.Println("Error scanning chat revision:", err)
+			return nil, err
+		}
+		edits = append(edits, e)
+	}
+	return edits, rows.Err()
+}
+
+// FetchConversationPage retrieves a page of a conversation's history from
+// PostgreSQL, limited to messages sent between fromTS and toTS
+func FetchConversationPage(conversationID string, r model.ChatRange, fromTS, toTS string) ([]model.Chat, error) {
 	var chats []model.Chat
 
-	query := `SELECT id, sender, receiver, content, extract(epoch from sent_at) as timestamp
+	order := "DESC"
+	if r.Ascending {
+		order = "ASC"
+	}
+
+	query := `SELECT ` + chatColumns + `
 				FROM messages
-				WHERE (sender = $1 AND receiver = $2 OR sender = $2 AND receiver = $1)
-				AND sent_at BETWEEN to_timestamp($3) AND to_timestamp($4)
-				ORDER BY sent_at DESC`
+				WHERE conversation_id = $1
+				AND ($2 = 0 OR id > $2)
+				AND ($3 = 0 OR id < $3)
+				AND sent_at BETWEEN to_timestamp($4) AND to_timestamp($5)
+				AND NOT EXISTS (SELECT 1 FROM message_hides h WHERE h.message_id = messages.id AND h.username = $7)
+				ORDER BY id ` + order + `
+				LIMIT $6`
 
-	rows, err := DB.Query(query, u1, u2, fromTS, toTS)
+	rows, err := DB.Query(query, conversationID, r.AfterID, r.BeforeID, fromTS, toTS, r.Limit, r.Viewer)
 	if err != nil {
-		log.Println("Error fetching chat history from PostgreSQL:", err)
+		log.Println("Error fetching chat page from PostgreSQL:", err)
 		return nil, err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var chat model.Chat
-		// Scan the result into the Chat struct
-		if err := rows.Scan(&chat.ID, &chat.From, &chat.To, &chat.Msg, &chat.Timestamp); err != nil {
+		chat, err := scanChat(rows)
+		if err != nil {
 			log.Println("Error scanning chat data:", err)
 			return nil, err
 		}
-		chats = append(chats, chat)
+		chats = append(chats, *chat)
 	}
 
-	return chats, nil
+	return chats, rows.Err()
 }
 
-func FetchOldChatBetween(u1, u2, fromTS, toTS, limit string) ([]model.Chat, error) {
-	var chats []model.Chat
+// FetchQuotes returns previews of the given messages keyed by ID
+func FetchQuotes(ids []string) (map[string]model.Quote, error) {
+	quotes := make(map[string]model.Quote, len(ids))
+	if len(ids) == 0 {
+		return quotes, nil
+	}
 
-	// PostgreSQL query to fetch older chat history based on timestamp
-	query := `SELECT id, sender, receiver, content, extract(epoch from sent_at) as timestamp
+	query := `SELECT id, sender, left(content, $2), deleted_at IS NOT NULL
 				FROM messages
-				WHERE (sender = $1 AND receiver = $2 OR sender = $2 AND receiver = $1)
-				AND sent_at < to_timestamp($3)
-				ORDER BY sent_at DESC
-				LIMIT $4`
+				WHERE id = ANY($1::integer[])`
 
-	rows, err := DB.Query(query, u1, u2, fromTS, limit)
+	rows, err := DB.Query(query, pq.Array(ids), model.QuoteLength)
 	if err != nil {
-		log.Println("Error fetching older chat history from PostgreSQL:", err)
+		log.Println("Error fetching quoted chats:", err)
 		return nil, err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var chat model.Chat
-		// Scan the result into the Chat struct
-		if err := rows.Scan(&chat.ID, &chat.From, &chat.To, &chat.Msg, &chat.Timestamp); err != nil {
-			log.Println("Error scanning chat data:", err)
+		var q model.Quote
+		if err := rows.Scan(&q.ID, &q.From, &q.Msg, &q.Deleted); err != nil {
+			log.Println("Error scanning quoted chat:", err)
+			return nil, err
+		}
+		quotes[q.ID] = q
+	}
+	return quotes, rows.Err()
+}
+
+// FetchReplies retrieves the replies to a message, oldest first, as seen by
+// the viewer of the range
+func FetchReplies(id string, r model.ChatRange) ([]model.Chat, error) {
+	query := `SELECT ` + chatColumns + `
+				FROM messages
+				WHERE reply_to = $1
+				AND id > $2
+				AND NOT EXISTS (SELECT 1 FROM message_hides h WHERE h.message_id = messages.id AND h.username = $4)
+				ORDER BY id
+				LIMIT $3`
+
+	rows, err := DB.Query(query, id, r.AfterID, r.Limit, r.Viewer)
+	if err != nil {
+		log.Println("Error fetching replies:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var chats []model.Chat
+	for rows.Next() {
+		chat, err := scanChat(rows)
+		if err != nil {
+			log.Println("Error scanning reply:", err)
+			return nil, err
+		}
+		chats = append(chats, *chat)
+	}
+	return chats, rows.Err()
+}
+
+// MarkChatDelivered moves a sent message to delivered. It returns false if the
+// message had already been delivered or read, or is a group message, which
+// has no delivery state.
+func MarkChatDelivered(id string) (*model.Chat, bool, error) {
+	query := `UPDATE messages
+				SET status = 'delivered', delivered_at = NOW()
+				WHERE id = $1 AND status = 'sent' AND group_id IS NULL
+				RETURNING id, sender, receiver`
+
+	var chat model.Chat
+	err := DB.QueryRow(query, id).Scan(&chat.ID, &chat.From, &chat.To)
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	}
+	if err != nil {
+		log.Println("Error marking chat as delivered:", err)
+		return nil, false, err
+	}
+
+	chat.Status = model.ChatStatusDelivered
+	return &chat, true, nil
+}
+
+// MarkChatsRead marks direct messages received by reader as read and returns
+// the ones that changed. With upTo set, every unread message from the same
+// sender up to and including id is marked; otherwise only id itself. It
+// returns ErrGroupReceipt if id is a group message; members mark groups read
+// with MarkConversationRead.
+func MarkChatsRead(reader, id string, upTo bool) ([]model.Chat, error) {
+	query := `UPDATE messages
+				SET status = 'read', read_at = NOW(), delivered_at = COALESCE(delivered_at, NOW())
+				WHERE receiver = $1 AND status <> 'read'
+				AND (id = $2 OR ($3 AND id < $2 AND sender = (SELECT sender FROM messages WHERE id = $2)))
+				RETURNING id, sender, receiver, conversation_id`
+
+	rows, err := DB.Query(query, reader, id, upTo)
+	if err != nil {
+		log.Println("Error marking chats as read:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var chats []model.Chat
+	for rows.Next() {
+		chat := model.Chat{Status: model.ChatStatusRead}
+		if err := rows.Scan(&chat.ID, &chat.From, &chat.To, &chat.ConversationID); err != nil {
+			log.Println("Error scanning read chat:", err)
 			return nil, err
 		}
 		chats = append(chats, chat)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 
+	if len(chats) == 0 {
+		var group bool
+		err := DB.QueryRow(`SELECT group_id IS NOT NULL FROM messages WHERE id = $1`, id).Scan(&group)
+		if err != nil && err != sql.ErrNoRows {
+			log.Println("Error checking read chat:", err)
+			return nil, err
+		}
+		if group {
+			return nil, ErrGroupReceipt
+		}
+	}
 	return chats, nil
 }
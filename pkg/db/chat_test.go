@@ -0,0 +1,38 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestMarkChatsReadRejectsGroupMessages(t *testing.T) {
+	mock := mockDB(t)
+
+	mock.ExpectQuery("UPDATE messages").
+		WithArgs("user2", "7", false).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "sender", "receiver", "conversation_id"}))
+	mock.ExpectQuery("SELECT group_id IS NOT NULL FROM messages").
+		WithArgs("7").
+		WillReturnRows(sqlmock.NewRows([]string{"group"}).AddRow(true))
+
+	if _, err := MarkChatsRead("user2", "7", false); err != ErrGroupReceipt {
+		t.Errorf("expected ErrGroupReceipt, got %v", err)
+	}
+}
+
+func TestMarkChatsReadIgnoresReadDirectMessages(t *testing.T) {
+	mock := mockDB(t)
+
+	mock.ExpectQuery("UPDATE messages").
+		WithArgs("user2", "7", true).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "sender", "receiver", "conversation_id"}))
+	mock.ExpectQuery("SELECT group_id IS NOT NULL FROM messages").
+		WithArgs("7").
+		WillReturnRows(sqlmock.NewRows([]string{"group"}).AddRow(false))
+
+	chats, err := MarkChatsRead("user2", "7", true)
+	if err != nil || len(chats) != 0 {
+		t.Errorf("expected nothing to change, got %v, %v", chats, err)
+	}
+}
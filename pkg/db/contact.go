@@ -48,13 +48,18 @@ func RejectFollowRequest(db *sql.DB, username, contactUsername string) error {
 	return err
 }
 
-// FetchContactList fetches accepted contacts for a user
+// FetchContactList fetches accepted contacts for a user, with the time of
+// their last message or else of the follow
 func FetchContactList(db *sql.DB, username string) ([]model.ContactList, error) {
 	query := `
-		SELECT contact_username 
-		FROM contacts 
-		WHERE username = $1 AND status = 'accepted'
-		ORDER BY updated_at DESC;
+		SELECT ct.contact_username, COALESCE(c.id::text, ''),
+			EXTRACT(EPOCH FROM COALESCE(c.last_message_at, ct.updated_at))
+		FROM contacts ct
+		LEFT JOIN conversations c ON c.kind = 'direct'
+			AND c.user_low = LEAST(ct.username, ct.contact_username)
+			AND c.user_high = GREATEST(ct.username, ct.contact_username)
+		WHERE ct.username = $1 AND ct.status = 'accepted'
+		ORDER BY COALESCE(c.last_message_at, ct.updated_at) DESC;
 	`
 
 	rows, err := db.Query(query, username)
@@ -67,7 +72,7 @@ func FetchContactList(db *sql.DB, username string) ([]model.ContactList, error)
 	var contacts []model.ContactList
 	for rows.Next() {
 		var contact model.ContactList
-		if err := rows.Scan(&contact.Username); err != nil {
+		if err := rows.Scan(&contact.Username, &contact.ConversationID, &contact.LastActivity); err != nil {
 			log.Println("Error scanning contact list row:", err)
 			continue
 		}
@@ -77,6 +82,33 @@ func FetchContactList(db *sql.DB, username string) ([]model.ContactList, error)
 	return contacts, nil
 }
 
+// FetchAcceptedContacts returns the users with an accepted follow request
+// to or from username
+func FetchAcceptedContacts(db *sql.DB, username string) ([]string, error) {
+	query := `
+		SELECT contact_username FROM contacts WHERE username = $1 AND status = 'accepted'
+		UNION
+		SELECT username FROM contacts WHERE contact_username = $1 AND status = 'accepted';
+	`
+	rows, err := db.Query(query, username)
+	if err != nil {
+		log.Println("Error fetching accepted contacts:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var contacts []string
+	for rows.Next() {
+		var contact string
+		if err := rows.Scan(&contact); err != nil {
+			log.Println("Error scanning accepted contact:", err)
+			return nil, err
+		}
+		contacts = append(contacts, contact)
+	}
+	return contacts, rows.Err()
+}
+
 // FetchPendingRequests fetches pending follow requests for a user
 func FetchPendingRequests(db *sql.DB, username string) ([]model.ContactList, error) {
 	query := `
@@ -0,0 +1,306 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"gochatapp/model"
+	"log"
+	"time"
+)
+
+// ErrNotConversationMember is returned when a user isn't part of the
+// conversation they act on
+var ErrNotConversationMember = errors.New("not a member of this conversation")
+
+// ErrConversationNotFound is returned when a conversation doesn't exist
+var ErrConversationNotFound = errors.New("conversation not found")
+
+// GetOrCreateDirectConversation returns the ID of the direct conversation
+// between two users, creating it on first contact
+func GetOrCreateDirectConversation(db *sql.DB, u1, u2 string) (string, error) {
+	low, high := u1, u2
+	if high < low {
+		low, high = high, low
+	}
+
+	var id string
+	query := `
+		INSERT INTO conversations (kind, user_low, user_high)
+		VALUES ('direct', $1, $2)
+		ON CONFLICT (user_low, user_high) WHERE kind = 'direct'
+		DO UPDATE SET user_low = EXCLUDED.user_low
+		RETURNING id;
+	`
+	if err := db.QueryRow(query, low, high).Scan(&id); err != nil {
+		log.Println("Error creating direct conversation:", err)
+		return "", err
+	}
+
+	memberQuery := `
+		INSERT INTO conversation_members (conversation_id, username)
+		VALUES ($1, $2), ($1, $3)
+		ON CONFLICT (conversation_id, username) DO NOTHING;
+	`
+	if _, err := db.Exec(memberQuery, id, low, high); err != nil {
+		log.Println("Error adding direct conversation members:", err)
+		return "", err
+	}
+
+	return id, nil
+}
+
+// FetchDirectConversationID returns the ID of the direct conversation between
+// two users, or "" if they have never talked
+func FetchDirectConversationID(db *sql.DB, u1, u2 string) (string, error) {
+	var id string
+	query := `SELECT id FROM conversations
+				WHERE kind = 'direct' AND user_low = LEAST($1, $2) AND user_high = GREATEST($1, $2)`
+	err := db.QueryRow(query, u1, u2).Scan(&id)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		log.Println("Error fetching direct conversation:", err)
+		return "", err
+	}
+	return id, nil
+}
+
+// FetchGroupConversationID returns the ID of a group's conversation
+func FetchGroupConversationID(db *sql.DB, groupID string) (string, error) {
+	var id string
+	err := db.QueryRow(`SELECT id FROM conversations WHERE group_id = $1`, groupID).Scan(&id)
+	if err != nil {
+		log.Println("Error fetching group conversation:", err)
+		return "", err
+	}
+	return id, nil
+}
+
+// FetchConversation returns a conversation as seen by username, or
+// ErrNotConversationMember if they don't belong to it
+func FetchConversation(db *sql.DB, conversationID, username string) (*model.Conversation, error) {
+	query := conversationSelect + ` WHERE cm.username = $1 AND c.id = $2`
+
+	conv, err := scanConversation(db.QueryRow(query, username, conversationID))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotConversationMember
+	}
+	if err != nil {
+		log.Println("Error fetching conversation:", err)
+		return nil, err
+	}
+	return conv, nil
+}
+
+// FetchConversations returns the conversations of a user, pinned ones first
+// and then by most recent activity. Archived conversations are only included
+// when asked for.
+func FetchConversations(db *sql.DB, username string, archived bool) ([]model.Conversation, error) {
+	query := conversationSelect + `
+		WHERE cm.username = $1 AND cm.archived = $2
+		ORDER BY cm.pinned DESC, c.last_message_at DESC NULLS LAST`
+
+	rows, err := db.Query(query, username, archived)
+	if err != nil {
+		log.Println("Error fetching conversations from PostgreSQL:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var conversations []model.Conversation
+	for rows.Next() {
+		conv, err := scanConversation(rows)
+		if err != nil {
+			log.Println("Error scanning conversation row:", err)
+			continue
+		}
+		conversations = append(conversations, *conv)
+	}
+
+	return conversations, rows.Err()
+}
+
+// conversationSelect reads a conversation from the point of view of the
+// member in cm, including how many messages arrived after their read marker
+const conversationSelect = `
+	SELECT c.id, c.kind,
+		CASE WHEN c.user_low = cm.username THEN c.user_high ELSE COALESCE(c.user_low, '') END,
+		COALESCE(c.group_id::text, ''), COALESCE(g.name, ''),
+		COALESCE(EXTRACT(EPOCH FROM c.last_message_at), 0),
+		cm.muted, cm.pinned, cm.archived, cm.last_read_message_id,
+		(SELECT COUNT(*) FROM messages m WHERE m.conversation_id = c.id AND ` + unreadCondition + `)
+	FROM conversations c
+	JOIN conversation_members cm ON cm.conversation_id = c.id
+	LEFT JOIN groups g ON g.id = c.group_id`
+
+// unreadCondition matches the messages m of a conversation that member cm
+// hasn't read: anyone else's, after their read marker, and neither deleted
+// nor hidden by them
+const unreadCondition = `m.id > cm.last_read_message_id AND m.sender <> cm.username
+			AND m.deleted_at IS NULL
+			AND NOT EXISTS (SELECT 1 FROM message_hides h WHERE h.message_id = m.id AND h.username = cm.username)`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanConversation(row rowScanner) (*model.Conversation, error) {
+	var conv model.Conversation
+	err := row.Scan(&conv.ID, &conv.Kind, &conv.Peer, &conv.GroupID, &conv.Name, &conv.LastActivity,
+		&conv.Muted, &conv.Pinned, &conv.Archived, &conv.LastReadID, &conv.Unread)
+	if err != nil {
+		return nil, err
+	}
+	if conv.Kind == model.ConversationGroup {
+		conv.Peer = ""
+	}
+	return &conv, nil
+}
+
+// UpdateConversationSettings changes the mute, pin and archive flags a user
+// keeps on a conversation; nil leaves a flag unchanged
+func UpdateConversationSettings(db *sql.DB, conversationID, username string, muted, pinned, archived *bool) error {
+	query := `
+		UPDATE conversation_members
+		SET muted = COALESCE($3, muted), pinned = COALESCE($4, pinned), archived = COALESCE($5, archived)
+		WHERE conversation_id = $1 AND username = $2;
+	`
+	res, err := db.Exec(query, conversationID, username, muted, pinned, archived)
+	if err != nil {
+		log.Println("Error updating conversation settings:", err)
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotConversationMember
+	}
+	return nil
+}
+
+// SetChatRetention sets how long the chats of a conversation stay cached.
+// Zero goes back to the default.
+func SetChatRetention(db *sql.DB, conversationID string, retention time.Duration) error {
+	var seconds interface{}
+	if retention > 0 {
+		seconds = int64(retention.Seconds())
+	}
+	res, err := db.Exec("UPDATE conversations SET chat_retention_seconds = $2 WHERE id = $1", conversationID, seconds)
+	if err != nil {
+		log.Println("Error setting chat retention:", err)
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrConversationNotFound
+	}
+	return nil
+}
+
+// FetchUnreadCounts returns the unread count of every conversation of a user
+// that has unread messages
+func FetchUnreadCounts(db *sql.DB, username string) (map[string]int, error) {
+	query := `
+		SELECT cm.conversation_id, COUNT(*)
+		FROM conversation_members cm
+		JOIN messages m ON m.conversation_id = cm.conversation_id
+		WHERE cm.username = $1 AND ` + unreadCondition + `
+		GROUP BY cm.conversation_id;
+	`
+	rows, err := db.Query(query, username)
+	if err != nil {
+		log.Println("Error fetching unread counts:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var conversationID string
+		var n int
+		if err := rows.Scan(&conversationID, &n); err != nil {
+			return nil, err
+		}
+		counts[conversationID] = n
+	}
+	return counts, rows.Err()
+}
+
+// MarkConversationRead moves a member's read marker up to a message of the
+// conversation, or its latest message if upTo is empty, and marks the direct
+// messages they received up to there as read. It returns those messages and
+// how many are left unread.
+func MarkConversationRead(db *sql.DB, conversationID, username, upTo string) ([]model.Chat, int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println("Error starting mark read transaction:", err)
+		return nil, 0, err
+	}
+	defer tx.Rollback()
+
+	var marker int64
+	err = tx.QueryRow(`SELECT last_read_message_id FROM conversation_members
+				WHERE conversation_id = $1 AND username = $2 FOR UPDATE`, conversationID, username).Scan(&marker)
+	if err == sql.ErrNoRows {
+		return nil, 0, ErrNotConversationMember
+	}
+	if err != nil {
+		log.Println("Error fetching read marker:", err)
+		return nil, 0, err
+	}
+
+	var target int64
+	if upTo == "" {
+		err = tx.QueryRow("SELECT COALESCE(MAX(id), 0) FROM messages WHERE conversation_id = $1", conversationID).Scan(&target)
+	} else {
+		err = tx.QueryRow("SELECT id FROM messages WHERE id = $1 AND conversation_id = $2", upTo, conversationID).Scan(&target)
+		if err == sql.ErrNoRows {
+			return nil, 0, ErrChatNotFound
+		}
+	}
+	if err != nil {
+		log.Println("Error resolving read marker target:", err)
+		return nil, 0, err
+	}
+
+	var chats []model.Chat
+	if target > marker {
+		_, err = tx.Exec(`UPDATE conversation_members SET last_read_message_id = $3
+				WHERE conversation_id = $1 AND username = $2`, conversationID, username, target)
+		if err != nil {
+			log.Println("Error moving read marker:", err)
+			return nil, 0, err
+		}
+
+		rows, err := tx.Query(`UPDATE messages
+				SET status = 'read', read_at = NOW(), delivered_at = COALESCE(delivered_at, NOW())
+				WHERE conversation_id = $1 AND receiver = $2 AND status <> 'read' AND id <= $3
+				RETURNING id, sender, receiver`, conversationID, username, target)
+		if err != nil {
+			log.Println("Error marking conversation read:", err)
+			return nil, 0, err
+		}
+		for rows.Next() {
+			chat := model.Chat{Status: model.ChatStatusRead, ConversationID: conversationID}
+			if err := rows.Scan(&chat.ID, &chat.From, &chat.To); err != nil {
+				rows.Close()
+				return nil, 0, err
+			}
+			chats = append(chats, chat)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	var unread int
+	err = tx.QueryRow(`SELECT COUNT(*) FROM conversation_members cm
+				JOIN messages m ON m.conversation_id = cm.conversation_id
+				WHERE cm.conversation_id = $1 AND cm.username = $2 AND `+unreadCondition,
+		conversationID, username).Scan(&unread)
+	if err != nil {
+		log.Println("Error counting unread messages:", err)
+		return nil, 0, err
+	}
+
+	return chats, unread, tx.Commit()
+}
@@ -0,0 +1,243 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"gochatapp/model"
+	"log"
+)
+
+// ErrNotGroupMember is returned when a user isn't part of the group they act on
+var ErrNotGroupMember = errors.New("not a member of this group")
+
+// CreateGroup creates a group owned by creator with the given initial members
+func CreateGroup(db *sql.DB, name, creator string, members []string) (*model.Group, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println("Error starting create group transaction:", err)
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	group := &model.Group{Name: name, CreatedBy: creator}
+	query := `INSERT INTO groups (name, created_by) VALUES ($1, $2)
+				RETURNING id, extract(epoch from created_at)`
+	if err := tx.QueryRow(query, name, creator).Scan(&group.ID, &group.CreatedAt); err != nil {
+		log.Println("Error creating group:", err)
+		return nil, err
+	}
+
+	memberQuery := `INSERT INTO group_members (group_id, username, role) VALUES ($1, $2, $3)
+				ON CONFLICT (group_id, username) DO NOTHING`
+	if _, err := tx.Exec(memberQuery, group.ID, creator, model.GroupRoleCreator); err != nil {
+		log.Println("Error adding group creator:", err)
+		return nil, err
+	}
+	for _, member := range members {
+		if _, err := tx.Exec(memberQuery, group.ID, member, model.GroupRoleMember); err != nil {
+			log.Println("Error adding group member:", err)
+			return nil, err
+		}
+	}
+
+	// Every group has a conversation its messages belong to
+	convQuery := `INSERT INTO conversations (kind, group_id) VALUES ('group', $1) RETURNING id`
+	if err := tx.QueryRow(convQuery, group.ID).Scan(&group.ConversationID); err != nil {
+		log.Println("Error creating group conversation:", err)
+		return nil, err
+	}
+	convMemberQuery := `
+		INSERT INTO conversation_members (conversation_id, username)
+		SELECT $1, username FROM group_members WHERE group_id = $2;
+	`
+	if _, err := tx.Exec(convMemberQuery, group.ConversationID, group.ID); err != nil {
+		log.Println("Error adding group conversation members:", err)
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Println("Error committing create group transaction:", err)
+		return nil, err
+	}
+
+	return group, nil
+}
+
+// RenameGroup changes the name of a group
+func RenameGroup(db *sql.DB, groupID, name string) error {
+	query := `UPDATE groups SET name = $2, updated_at = NOW() WHERE id = $1`
+	_, err := db.Exec(query, groupID, name)
+	if err != nil {
+		log.Println("Error renaming group:", err)
+	}
+	return err
+}
+
+// FetchGroupRole returns the role of username in the group, or
+// ErrNotGroupMember if they don't belong to it
+func FetchGroupRole(db *sql.DB, groupID, username string) (string, error) {
+	var role string
+	query := `SELECT role FROM group_members WHERE group_id = $1 AND username = $2`
+	err := db.QueryRow(query, groupID, username).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", ErrNotGroupMember
+	}
+	if err != nil {
+		log.Println("Error fetching group role:", err)
+		return "", err
+	}
+	return role, nil
+}
+
+// AddGroupMember adds a user to a group, or updates their role if they're
+// already a member. A new member has read everything sent before they joined.
+func AddGroupMember(db *sql.DB, groupID, username, role string) error {
+	query := `
+		WITH gm AS (
+			INSERT INTO group_members (group_id, username, role)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (group_id, username) DO UPDATE SET role = EXCLUDED.role
+		)
+		INSERT INTO conversation_members (conversation_id, username, last_read_message_id)
+		SELECT c.id, $2, (SELECT COALESCE(MAX(m.id), 0) FROM messages m WHERE m.conversation_id = c.id)
+		FROM conversations c WHERE c.group_id = $1
+		ON CONFLICT (conversation_id, username) DO NOTHING;
+	`
+	_, err := db.Exec(query, groupID, username, role)
+	if err != nil {
+		log.Println("Error adding group member:", err)
+	}
+	return err
+}
+
+// RemoveGroupMember removes a user from a group
+func RemoveGroupMember(db *sql.DB, groupID, username string) error {
+	query := `
+		WITH gm AS (
+			DELETE FROM group_members WHERE group_id = $1 AND username = $2
+		)
+		DELETE FROM conversation_members
+		WHERE username = $2 AND conversation_id = (SELECT id FROM conversations WHERE group_id = $1);
+	`
+	_, err := db.Exec(query, groupID, username)
+	if err != nil {
+		log.Println("Error removing group member:", err)
+	}
+	return err
+}
+
+// LeaveGroup removes a user from a group. When the creator leaves, the
+// longest-standing admin (or member, if there are no admins) takes over, and
+// the group is deleted once nobody is left.
+func LeaveGroup(db *sql.DB, groupID, username string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println("Error starting leave group transaction:", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	var role string
+	err = tx.QueryRow(`DELETE FROM group_members WHERE group_id = $1 AND username = $2 RETURNING role`,
+		groupID, username).Scan(&role)
+	if err == sql.ErrNoRows {
+		return ErrNotGroupMember
+	}
+	if err != nil {
+		log.Println("Error leaving group:", err)
+		return err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM conversation_members
+			WHERE username = $2 AND conversation_id = (SELECT id FROM conversations WHERE group_id = $1)`,
+		groupID, username); err != nil {
+		log.Println("Error leaving group conversation:", err)
+		return err
+	}
+
+	if role == model.GroupRoleCreator {
+		var successor string
+		err = tx.QueryRow(`
+			SELECT username FROM group_members
+			WHERE group_id = $1
+			ORDER BY role = 'admin' DESC, joined_at ASC
+			LIMIT 1`, groupID).Scan(&successor)
+
+		switch {
+		case err == sql.ErrNoRows:
+			if _, err := tx.Exec(`DELETE FROM groups WHERE id = $1`, groupID); err != nil {
+				log.Println("Error deleting empty group:", err)
+				return err
+			}
+		case err != nil:
+			log.Println("Error finding group successor:", err)
+			return err
+		default:
+			if _, err := tx.Exec(`UPDATE group_members SET role = $3 WHERE group_id = $1 AND username = $2`,
+				groupID, successor, model.GroupRoleCreator); err != nil {
+				log.Println("Error promoting group successor:", err)
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// FetchGroupMembers returns every member of a group with their role
+func FetchGroupMembers(db *sql.DB, groupID string) ([]model.GroupMember, error) {
+	query := `
+		SELECT username, role, EXTRACT(EPOCH FROM joined_at)
+		FROM group_members
+		WHERE group_id = $1
+		ORDER BY joined_at ASC;
+	`
+	rows, err := db.Query(query, groupID)
+	if err != nil {
+		log.Println("Error fetching group members:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []model.GroupMember
+	for rows.Next() {
+		var member model.GroupMember
+		if err := rows.Scan(&member.Username, &member.Role, &member.JoinedAt); err != nil {
+			log.Println("Error scanning group member row:", err)
+			continue
+		}
+		members = append(members, member)
+	}
+
+	return members, rows.Err()
+}
+
+// FetchUserGroups returns the groups a user belongs to
+func FetchUserGroups(db *sql.DB, username string) ([]model.Group, error) {
+	query := `
+		SELECT g.id, g.name, g.created_by, EXTRACT(EPOCH FROM g.created_at), c.id
+		FROM groups g
+		JOIN group_members gm ON gm.group_id = g.id
+		JOIN conversations c ON c.group_id = g.id
+		WHERE gm.username = $1
+		ORDER BY g.updated_at DESC;
+	`
+	rows, err := db.Query(query, username)
+	if err != nil {
+		log.Println("Error fetching groups from PostgreSQL:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var groups []model.Group
+	for rows.Next() {
+		var group model.Group
+		if err := rows.Scan(&group.ID, &group.Name, &group.CreatedBy, &group.CreatedAt, &group.ConversationID); err != nil {
+			log.Println("Error scanning group row:", err)
+			continue
+		}
+		groups = append(groups, group)
+	}
+
+	return groups, rows.Err()
+}
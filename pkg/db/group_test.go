@@ -0,0 +1,21 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestAddGroupMemberStartsReadUpToNow(t *testing.T) {
+	mock := mockDB(t)
+
+	// The history sent before joining doesn't count as unread
+	mock.ExpectExec(`INSERT INTO conversation_members \(conversation_id, username, last_read_message_id\)\s+` +
+		`SELECT c.id, \$2, \(SELECT COALESCE\(MAX\(m.id\), 0\) FROM messages m WHERE m.conversation_id = c.id\)`).
+		WithArgs("4", "user3", "member").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := AddGroupMember(DB, "4", "user3", "member"); err != nil {
+		t.Fatal("error adding group member", err)
+	}
+}
@@ -0,0 +1,173 @@
+package db
+
+import (
+	"database/sql"
+	"log"
+	"slices"
+
+	"gochatapp/model"
+
+	"github.com/lib/pq"
+)
+
+// inboxEntries lists the inbox of $1: every conversation they are a member
+// of, plus accepted contacts and incoming follow requests they haven't talked
+// to yet. Users blocked either way are left out. Keys are 'c' and the
+// conversation ID, or 'u' and the username.
+const inboxEntries = `
+	WITH follows AS (
+		SELECT contact_username AS peer, updated_at AS since, TRUE AS accepted
+			FROM contacts WHERE username = $1 AND status = 'accepted'
+		UNION ALL
+		SELECT username, updated_at, TRUE FROM contacts WHERE contact_username = $1 AND status = 'accepted'
+		UNION ALL
+		SELECT username, created_at, FALSE FROM contacts WHERE contact_username = $1 AND status = 'pending'
+	),
+	blocked AS (
+		SELECT blocked AS peer FROM blocks WHERE blocker = $1
+		UNION
+		SELECT blocker FROM blocks WHERE blocked = $1
+	),
+	conversations_of AS (
+		SELECT c.id, c.kind, COALESCE(c.group_id::text, '') AS group_id, COALESCE(g.name, '') AS name,
+			CASE WHEN c.kind = '` + model.ConversationDirect + `' THEN
+				CASE WHEN c.user_low = $1 THEN c.user_high ELSE c.user_low END
+			ELSE '' END AS peer,
+			COALESCE(EXTRACT(EPOCH FROM c.last_message_at), 0)::float8 AS activity
+		FROM conversations c
+		JOIN conversation_members cm ON cm.conversation_id = c.id AND cm.username = $1
+		LEFT JOIN groups g ON g.id = c.group_id
+	),
+	entries AS (
+		SELECT 'c' || c.id AS key, c.id AS conversation_id,
+			CASE
+				WHEN c.kind = '` + model.ConversationGroup + `' THEN '` + model.InboxGroup + `'
+				WHEN EXISTS (SELECT 1 FROM follows f WHERE f.peer = c.peer AND f.accepted) THEN '` + model.InboxContact + `'
+				WHEN EXISTS (SELECT 1 FROM follows f WHERE f.peer = c.peer) THEN '` + model.InboxRequest + `'
+				ELSE '` + model.InboxStranger + `'
+			END AS kind,
+			c.peer, c.group_id, c.name, c.activity
+		FROM conversations_of c
+		UNION ALL
+		SELECT 'u' || f.peer, NULL,
+			CASE WHEN bool_or(f.accepted) THEN '` + model.InboxContact + `' ELSE '` + model.InboxRequest + `' END,
+			f.peer, '', '',
+			EXTRACT(EPOCH FROM COALESCE(MAX(f.since) FILTER (WHERE f.accepted), MAX(f.since)))::float8
+		FROM follows f
+		WHERE f.peer NOT IN (SELECT peer FROM conversations_of)
+		GROUP BY f.peer
+	)
+	SELECT *, COUNT(*) OVER () AS total FROM entries
+	WHERE peer NOT IN (SELECT peer FROM blocked)`
+
+// FetchInbox returns a page of the inbox entries of a user, most recent
+// activity first, with a preview of the latest message of each conversation,
+// along with how many entries the whole inbox has
+func FetchInbox(db *sql.DB, username string, r model.InboxRange) ([]model.InboxEntry, int, error) {
+	// Entries before a position are read backwards from it and put back in order
+	order, keyOrder, activityCmp, keyCmp := "DESC", "ASC", "<", ">"
+	bound := r.After
+	if r.Before != nil {
+		order, keyOrder, activityCmp, keyCmp = "ASC", "DESC", ">", "<"
+		bound = r.Before
+	}
+	var activity interface{}
+	var key string
+	if bound != nil {
+		activity, key = bound.Activity, bound.Key
+	}
+
+	query := `SELECT e.key, e.kind, e.peer, e.group_id, e.name, COALESCE(e.conversation_id::text, ''), e.activity,
+			COALESCE(cm.muted, FALSE), COALESCE(cm.pinned, FALSE), COALESCE(cm.archived, FALSE),
+			(SELECT COUNT(*) FROM messages m WHERE m.conversation_id = cm.conversation_id AND ` + unreadCondition + `),
+			e.total
+		FROM (` + inboxEntries + `) e
+		LEFT JOIN conversation_members cm ON cm.conversation_id = e.conversation_id AND cm.username = $1
+		WHERE $2::float8 IS NULL OR e.activity ` + activityCmp + ` $2 OR (e.activity = $2 AND e.key ` + keyCmp + ` $3)
+		ORDER BY e.activity ` + order + `, e.key ` + keyOrder + `
+		LIMIT $4`
+
+	rows, err := db.Query(query, username, activity, key, r.Limit)
+	if err != nil {
+		log.Println("Error fetching inbox:", err)
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	entries := []model.InboxEntry{}
+	total := 0
+	for rows.Next() {
+		var e model.InboxEntry
+		err := rows.Scan(&e.Key, &e.Kind, &e.Username, &e.GroupID, &e.Name, &e.ConversationID, &e.LastActivity,
+			&e.Muted, &e.Pinned, &e.Archived, &e.Unread, &total)
+		if err != nil {
+			log.Println("Error scanning inbox entry:", err)
+			return nil, 0, err
+		}
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	if r.Before != nil {
+		slices.Reverse(entries)
+	}
+	if len(entries) == 0 && bound != nil {
+		// A page past the end carries no total
+		if err := db.QueryRow(`SELECT COUNT(*) FROM (`+inboxEntries+`) e`, username).Scan(&total); err != nil {
+			log.Println("Error counting inbox entries:", err)
+			return nil, 0, err
+		}
+	}
+
+	var ids []string
+	for _, e := range entries {
+		if e.ConversationID != "" {
+			ids = append(ids, e.ConversationID)
+		}
+	}
+	previews, err := FetchLastMessages(ids, username)
+	if err != nil {
+		return nil, 0, err
+	}
+	for i := range entries {
+		if p, ok := previews[entries[i].ConversationID]; ok {
+			entries[i].LastMessage = &p
+		}
+	}
+	return entries, total, nil
+}
+
+// FetchLastMessages returns a preview of the latest message of each
+// conversation that the viewer hasn't hidden, keyed by conversation ID
+func FetchLastMessages(conversationIDs []string, viewer string) (map[string]model.Quote, error) {
+	previews := make(map[string]model.Quote, len(conversationIDs))
+	if len(conversationIDs) == 0 {
+		return previews, nil
+	}
+
+	query := `SELECT DISTINCT ON (m.conversation_id)
+					m.conversation_id, m.id, m.sender, left(m.content, $3), m.deleted_at IS NOT NULL
+				FROM messages m
+				WHERE m.conversation_id = ANY($1::integer[])
+				AND NOT EXISTS (SELECT 1 FROM message_hides h WHERE h.message_id = m.id AND h.username = $2)
+				ORDER BY m.conversation_id, m.id DESC`
+
+	rows, err := DB.Query(query, pq.Array(conversationIDs), viewer, model.QuoteLength)
+	if err != nil {
+		log.Println("Error fetching last messages:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var conversationID string
+		var p model.Quote
+		if err := rows.Scan(&conversationID, &p.ID, &p.From, &p.Msg, &p.Deleted); err != nil {
+			log.Println("Error scanning last message:", err)
+			return nil, err
+		}
+		previews[conversationID] = p
+	}
+	return previews, rows.Err()
+}
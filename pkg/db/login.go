@@ -0,0 +1,87 @@
+package db
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+// Subjects failed logins are counted against
+const (
+	LoginScopeUser = "user"
+	LoginScopeIP   = "ip"
+)
+
+// LoginLockRemaining returns how much longer logins for the username from the
+// IP, for the username from anywhere, or any login from the IP, are locked, or
+// zero if none is
+func LoginLockRemaining(db *sql.DB, username, ip string) (time.Duration, error) {
+	var seconds float64
+	query := `SELECT COALESCE(EXTRACT(EPOCH FROM MAX(locked_until) - NOW()), 0)
+				FROM login_failures
+				WHERE (scope = $1 AND subject = $2 AND ip IN ($4, '')) OR (scope = $3 AND subject = $4 AND ip = '')`
+	err := db.QueryRow(query, LoginScopeUser, username, LoginScopeIP, ip).Scan(&seconds)
+	if err != nil {
+		log.Println("Error fetching login lockout:", err)
+		return 0, err
+	}
+	if seconds <= 0 {
+		return 0, nil
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// RecordLoginFailure counts a failed login against a subject and locks it for
+// as long as backoff says for the new number of failures. ip narrows a
+// username to the client it failed from, and is empty for the IP scope and
+// for a username's failures from every client together. The
+// count starts over once the last failure is older than window.
+func RecordLoginFailure(db *sql.DB, scope, subject, ip string, window time.Duration, backoff func(failures int) time.Duration) error {
+	var failures int
+	query := `INSERT INTO login_failures (scope, subject, ip, failures, last_failed_at)
+				VALUES ($1, $2, $3, 1, NOW())
+				ON CONFLICT (scope, subject, ip) DO UPDATE SET
+					failures = CASE
+						WHEN login_failures.last_failed_at < NOW() - make_interval(secs => $4) THEN 1
+						ELSE login_failures.failures + 1
+					END,
+					last_failed_at = NOW()
+				RETURNING failures`
+	err := db.QueryRow(query, scope, subject, ip, window.Seconds()).Scan(&failures)
+	if err != nil {
+		log.Println("Error recording login failure:", err)
+		return err
+	}
+
+	lock := backoff(failures)
+	if lock <= 0 {
+		return nil
+	}
+	_, err = db.Exec(`UPDATE login_failures SET locked_until = NOW() + make_interval(secs => $4)
+				WHERE scope = $1 AND subject = $2 AND ip = $3`, scope, subject, ip, lock.Seconds())
+	if err != nil {
+		log.Println("Error locking login:", err)
+	}
+	return err
+}
+
+// ClearLoginFailures forgets the failed logins of a subject from every client,
+// unlocking it. It reports whether there was anything to clear.
+func ClearLoginFailures(db *sql.DB, scope, subject string) (bool, error) {
+	res, err := db.Exec("DELETE FROM login_failures WHERE scope = $1 AND subject = $2", scope, subject)
+	if err != nil {
+		log.Println("Error clearing login failures:", err)
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// ClearLoginFailuresFrom forgets the failed logins of a subject from one client IP
+func ClearLoginFailuresFrom(db *sql.DB, scope, subject, ip string) error {
+	_, err := db.Exec("DELETE FROM login_failures WHERE scope = $1 AND subject = $2 AND ip = $3", scope, subject, ip)
+	if err != nil {
+		log.Println("Error clearing login failures:", err)
+	}
+	return err
+}
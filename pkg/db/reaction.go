@@ -0,0 +1,70 @@
+package db
+
+import (
+	"gochatapp/model"
+	"log"
+
+	"github.com/lib/pq"
+)
+
+// AddReaction records a reaction of username to a message. It returns false
+// if they had already reacted with that emoji.
+func AddReaction(id, username, emoji string) (bool, error) {
+	query := `INSERT INTO message_reactions (message_id, username, emoji) VALUES ($1, $2, $3)
+				ON CONFLICT ON CONSTRAINT message_reactions_unique DO NOTHING`
+
+	res, err := DB.Exec(query, id, username, emoji)
+	if err != nil {
+		log.Println("Error adding reaction:", err)
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// RemoveReaction drops a reaction of username from a message. It returns
+// false if there was none.
+func RemoveReaction(id, username, emoji string) (bool, error) {
+	query := `DELETE FROM message_reactions WHERE message_id = $1 AND username = $2 AND emoji = $3`
+
+	res, err := DB.Exec(query, id, username, emoji)
+	if err != nil {
+		log.Println("Error removing reaction:", err)
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// FetchReactions returns the reaction counts of the given messages keyed by
+// message ID, flagging the emojis viewer reacted with
+func FetchReactions(ids []string, viewer string) (map[string][]model.Reaction, error) {
+	reactions := make(map[string][]model.Reaction)
+	if len(ids) == 0 {
+		return reactions, nil
+	}
+
+	query := `SELECT message_id, emoji, COUNT(*), bool_or(username = $2)
+				FROM message_reactions
+				WHERE message_id = ANY($1::integer[])
+				GROUP BY message_id, emoji
+				ORDER BY message_id, MIN(created_at)`
+
+	rows, err := DB.Query(query, pq.Array(ids), viewer)
+	if err != nil {
+		log.Println("Error fetching reactions:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		var r model.Reaction
+		if err := rows.Scan(&id, &r.Emoji, &r.Count, &r.Reacted); err != nil {
+			log.Println("Error scanning reaction:", err)
+			return nil, err
+		}
+		reactions[id] = append(reactions[id], r)
+	}
+	return reactions, rows.Err()
+}
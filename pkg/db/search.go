@@ -0,0 +1,72 @@
+package db
+
+import (
+	"database/sql"
+	"gochatapp/model"
+	"html"
+	"log"
+	"strings"
+)
+
+// ts_headline wraps matches in these control characters. They are stripped
+// from the text first, so once the snippet is escaped they can only be the
+// markers, and only they become markup.
+const (
+	matchStart = "\x02"
+	matchStop  = "\x03"
+)
+
+var snippetMarks = strings.NewReplacer(matchStart, "<mark>", matchStop, "</mark>")
+
+// markSnippet escapes a headline as HTML and turns its match markers into
+// <mark> tags
+func markSnippet(headline string) string {
+	return snippetMarks.Replace(html.EscapeString(headline))
+}
+
+// SearchMessages runs a full-text search over the messages of the
+// conversations username belongs to, best matches first
+func SearchMessages(db *sql.DB, username string, f model.SearchFilter) ([]model.SearchHit, error) {
+	query := `
+		SELECT m.id, m.sender, COALESCE(m.receiver, ''), m.content, EXTRACT(EPOCH FROM m.sent_at), m.status,
+			COALESCE(m.group_id::text, ''), m.conversation_id,
+			ts_rank(m.search_vector, q) AS rank,
+			ts_headline('simple', translate(m.content, chr(2) || chr(3), ''), q,
+				'StartSel=' || chr(2) || ', StopSel=' || chr(3) || ', MaxFragments=2, MaxWords=20, MinWords=5')
+		FROM messages m
+		JOIN conversation_members cm ON cm.conversation_id = m.conversation_id AND cm.username = $1,
+			websearch_to_tsquery('simple', $2) q
+		WHERE m.search_vector @@ q
+		AND m.deleted_at IS NULL
+		AND NOT EXISTS (SELECT 1 FROM message_hides h WHERE h.message_id = m.id AND h.username = $1)
+		AND ($3 = '' OR m.conversation_id = (
+			SELECT id FROM conversations
+			WHERE kind = 'direct' AND user_low = LEAST($1, $3) AND user_high = GREATEST($1, $3)))
+		AND ($4 = '' OR m.sender = $4)
+		AND m.sent_at BETWEEN to_timestamp($5) AND to_timestamp($6)
+		ORDER BY rank DESC, m.id DESC
+		LIMIT $7 OFFSET $8;
+	`
+
+	rows, err := db.Query(query, username, f.Query, f.Contact, f.Sender, f.FromTS, f.ToTS, f.Limit, f.Offset)
+	if err != nil {
+		log.Println("Error searching messages:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hits []model.SearchHit
+	for rows.Next() {
+		var hit model.SearchHit
+		c := &hit.Chat
+		if err := rows.Scan(&c.ID, &c.From, &c.To, &c.Msg, &c.Timestamp, &c.Status, &c.GroupID, &c.ConversationID,
+			&hit.Rank, &hit.Snippet); err != nil {
+			log.Println("Error scanning search hit:", err)
+			return nil, err
+		}
+		hit.Snippet = markSnippet(hit.Snippet)
+		hits = append(hits, hit)
+	}
+
+	return hits, rows.Err()
+}
@@ -0,0 +1,29 @@
+package db
+
+import (
+	"testing"
+
+	"gochatapp/model"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestSearchSnippetsAreEscaped(t *testing.T) {
+	mock := mockDB(t)
+
+	// A match inside markup the sender wrote
+	mock.ExpectQuery("ts_headline").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "sender", "receiver", "content", "timestamp", "status",
+			"group_id", "conversation_id", "rank", "snippet"}).
+			AddRow("7", "user1", "user2", `<img src=x onerror="alert(1)"> hello`, 1700000000.0, "sent", "", "3", 0.5,
+				"<img src=x onerror=\"alert(1)\"> \x02hello\x03 & bye"))
+
+	hits, err := SearchMessages(DB, "user2", model.SearchFilter{Query: "hello", FromTS: "0", ToTS: "+inf", Limit: 20})
+	if err != nil {
+		t.Fatal("error searching", err)
+	}
+	want := `&lt;img src=x onerror=&#34;alert(1)&#34;&gt; <mark>hello</mark> &amp; bye`
+	if len(hits) != 1 || hits[0].Snippet != want {
+		t.Errorf("expected snippet %q, got %+v", want, hits)
+	}
+}
@@ -0,0 +1,141 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+)
+
+var (
+	// ErrInvalidRefreshToken is returned for unknown, expired or revoked refresh tokens
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	// ErrRefreshTokenReused is returned when a refresh token that was already
+	// rotated is presented again, which revokes its whole family
+	ErrRefreshTokenReused = errors.New("refresh token reuse detected")
+)
+
+// StoreRefreshToken records a refresh token issued on login, starting a new
+// family, along with the jti of the access token issued with it
+func StoreRefreshToken(db *sql.DB, username, hash, accessJTI string, expiresAt time.Time) error {
+	query := `INSERT INTO refresh_tokens (username, token_hash, family_id, expires_at, access_jti)
+				VALUES ($1, $2, $2, $3, $4)`
+	_, err := db.Exec(query, username, hash, expiresAt, accessJTI)
+	if err != nil {
+		log.Println("Error storing refresh token:", err)
+	}
+	return err
+}
+
+// RotateRefreshToken exchanges a refresh token for a new one of the same
+// family, issued with the access token accessJTI, and returns the user it
+// belongs to
+func RotateRefreshToken(db *sql.DB, hash, newHash, accessJTI string, expiresAt time.Time) (string, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println("Error starting rotate refresh token transaction:", err)
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var username, family string
+	var revoked, expired bool
+	query := `SELECT username, family_id, revoked_at IS NOT NULL, expires_at < NOW()
+				FROM refresh_tokens WHERE token_hash = $1 FOR UPDATE`
+	err = tx.QueryRow(query, hash).Scan(&username, &family, &revoked, &expired)
+	if err == sql.ErrNoRows {
+		return "", ErrInvalidRefreshToken
+	}
+	if err != nil {
+		log.Println("Error fetching refresh token:", err)
+		return "", err
+	}
+
+	if revoked {
+		// Someone holds a copy of a rotated token; cut off every descendant
+		_, err := tx.Exec(`UPDATE refresh_tokens SET revoked_at = NOW()
+				WHERE family_id = $1 AND revoked_at IS NULL`, family)
+		if err != nil {
+			log.Println("Error revoking refresh token family:", err)
+			return "", err
+		}
+		if err := tx.Commit(); err != nil {
+			return "", err
+		}
+		return "", ErrRefreshTokenReused
+	}
+	if expired {
+		return "", ErrInvalidRefreshToken
+	}
+
+	if _, err := tx.Exec(`UPDATE refresh_tokens SET revoked_at = NOW() WHERE token_hash = $1`, hash); err != nil {
+		log.Println("Error revoking rotated refresh token:", err)
+		return "", err
+	}
+	query = `INSERT INTO refresh_tokens (username, token_hash, family_id, expires_at, access_jti)
+				VALUES ($1, $2, $3, $4, $5)`
+	if _, err := tx.Exec(query, username, newHash, family, expiresAt, accessJTI); err != nil {
+		log.Println("Error storing rotated refresh token:", err)
+		return "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Println("Error committing rotate refresh token transaction:", err)
+		return "", err
+	}
+	return username, nil
+}
+
+// RevokeRefreshToken revokes the family of one of username's refresh tokens
+func RevokeRefreshToken(db *sql.DB, username, hash string) error {
+	query := `UPDATE refresh_tokens SET revoked_at = NOW()
+				WHERE revoked_at IS NULL AND family_id = (
+					SELECT family_id FROM refresh_tokens WHERE token_hash = $1 AND username = $2)`
+	_, err := db.Exec(query, hash, username)
+	if err != nil {
+		log.Println("Error revoking refresh token:", err)
+	}
+	return err
+}
+
+// RevokeUserRefreshTokens revokes every refresh token of a user
+func RevokeUserRefreshTokens(db *sql.DB, username string) error {
+	query := `UPDATE refresh_tokens SET revoked_at = NOW() WHERE username = $1 AND revoked_at IS NULL`
+	_, err := db.Exec(query, username)
+	if err != nil {
+		log.Println("Error revoking refresh tokens:", err)
+	}
+	return err
+}
+
+// RevokeAccessTokenFamily revokes the refresh token family the access token
+// accessJTI was issued with. It returns the jtis of the access tokens the
+// family handed out since the given time, which may still be in use.
+func RevokeAccessTokenFamily(db *sql.DB, username, accessJTI string, since time.Time) ([]string, error) {
+	query := `WITH family AS (
+				SELECT family_id FROM refresh_tokens WHERE access_jti = $1 AND username = $2
+			), revoked AS (
+				UPDATE refresh_tokens SET revoked_at = NOW()
+				WHERE family_id IN (SELECT family_id FROM family) AND revoked_at IS NULL
+			)
+			SELECT access_jti FROM refresh_tokens
+			WHERE family_id IN (SELECT family_id FROM family) AND access_jti IS NOT NULL AND created_at > $3`
+
+	rows, err := db.Query(query, accessJTI, username, since)
+	if err != nil {
+		log.Println("Error revoking refresh token family:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jtis []string
+	for rows.Next() {
+		var jti string
+		if err := rows.Scan(&jti); err != nil {
+			log.Println("Error scanning access token id:", err)
+			return nil, err
+		}
+		jtis = append(jtis, jti)
+	}
+	return jtis, rows.Err()
+}
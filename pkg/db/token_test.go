@@ -0,0 +1,51 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+var refreshTokenColumns = []string{"username", "family_id", "revoked", "expired"}
+
+func TestRotateRefreshToken(t *testing.T) {
+	mock := mockDB(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("FROM refresh_tokens WHERE token_hash = \\$1 FOR UPDATE").
+		WithArgs("old").
+		WillReturnRows(sqlmock.NewRows(refreshTokenColumns).AddRow("user1", "f1", false, false))
+	mock.ExpectExec("UPDATE refresh_tokens SET revoked_at = NOW\\(\\) WHERE token_hash = \\$1").
+		WithArgs("old").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO refresh_tokens").
+		WithArgs("user1", "new", "f1", sqlmock.AnyArg(), "jti-2").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	username, err := RotateRefreshToken(DB, "old", "new", "jti-2", time.Now().Add(time.Hour))
+	if err != nil || username != "user1" {
+		t.Errorf("expected the token of user1 to rotate, got %q %v", username, err)
+	}
+}
+
+func TestReusedRefreshTokenRevokesItsFamily(t *testing.T) {
+	mock := mockDB(t)
+
+	// The token was already rotated, so whoever presents it again is cut off
+	// along with every token handed out from it since
+	mock.ExpectBegin()
+	mock.ExpectQuery("FROM refresh_tokens WHERE token_hash = \\$1 FOR UPDATE").
+		WithArgs("old").
+		WillReturnRows(sqlmock.NewRows(refreshTokenColumns).AddRow("user1", "f1", true, false))
+	mock.ExpectExec("UPDATE refresh_tokens SET revoked_at = NOW\\(\\)\\s+WHERE family_id = \\$1 AND revoked_at IS NULL").
+		WithArgs("f1").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	_, err := RotateRefreshToken(DB, "old", "new", "jti-2", time.Now().Add(time.Hour))
+	if err != ErrRefreshTokenReused {
+		t.Errorf("expected ErrRefreshTokenReused, got %v", err)
+	}
+}
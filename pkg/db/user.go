@@ -5,8 +5,19 @@ import (
 	"errors"
 	"fmt"
 	"gochatapp/utils"
+	"log"
+
+	"github.com/lib/pq"
 )
 
+// ErrInvalidCredentials is returned for an unknown username or a wrong
+// password alike, so the two can't be told apart
+var ErrInvalidCredentials = errors.New("invalid username or password")
+
+// dummyHash is compared against when the user doesn't exist, so a login for
+// an unknown username takes as long as one with a wrong password
+var dummyHash, _ = utils.HashPassword("not a real password")
+
 // User model
 type User struct {
 	Username string
@@ -41,7 +52,8 @@ func IsUserAuthentic(db *sql.DB, username, password string) error {
 	err := db.QueryRow(query, username).Scan(&storedPassword)
 	if err == sql.ErrNoRows {
 		// If the user doesn't exist, return an error
-		return errors.New("invalid username or password")
+		utils.CheckPasswordHash(password, dummyHash)
+		return ErrInvalidCredentials
 	}
 	if err != nil {
 		// Handle other potential database errors
@@ -51,9 +63,39 @@ func IsUserAuthentic(db *sql.DB, username, password string) error {
 	// Compare the hashed password stored in the database with the provided password
 	if !utils.CheckPasswordHash(password, storedPassword) {
 		// If the password doesn't match, return an error
-		return errors.New("invalid username or password")
+		return ErrInvalidCredentials
 	}
 
 	// If everything is valid, return nil (successful authentication)
 	return nil
 }
+
+// SetHideLastSeen sets whether a user's last-seen time is shown to others
+func SetHideLastSeen(db *sql.DB, username string, hide bool) error {
+	_, err := db.Exec("UPDATE users SET hide_last_seen = $2 WHERE username = $1", username, hide)
+	if err != nil {
+		log.Println("Error updating last seen setting:", err)
+	}
+	return err
+}
+
+// FetchHiddenLastSeen returns which of the given users hide their last-seen time
+func FetchHiddenLastSeen(db *sql.DB, usernames []string) (map[string]bool, error) {
+	hidden := make(map[string]bool)
+	query := "SELECT username FROM users WHERE username = ANY($1) AND hide_last_seen"
+	rows, err := db.Query(query, pq.Array(usernames))
+	if err != nil {
+		log.Println("Error fetching last seen settings:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var username string
+		if err := rows.Scan(&username); err != nil {
+			return nil, err
+		}
+		hidden[username] = true
+	}
+	return hidden, rows.Err()
+}
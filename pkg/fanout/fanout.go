@@ -0,0 +1,31 @@
+package fanout
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// Event is a WebSocket frame addressed to one or more users. It is published
+// by the instance that accepted it and delivered by every instance that holds
+// a socket for one of the recipients.
+type Event struct {
+	To      []string        `json:"to"`
+	Payload json.RawMessage `json:"payload"`
+	// MessageID is set for chat frames so the instance that hands the frame
+	// to a recipient can clear it from their offline queue
+	MessageID string `json:"message_id,omitempty"`
+	// Session narrows delivery to one socket of the recipients, and
+	// ExceptSession leaves one out, such as the device a frame came from
+	Session       string `json:"session,omitempty"`
+	ExceptSession string `json:"except_session,omitempty"`
+	// Close disconnects the sockets the frame was written to
+	Close bool `json:"close,omitempty"`
+}
+
+// Bus carries events between server instances
+type Bus interface {
+	// Publish hands the event to every subscriber, including the local one
+	Publish(ctx context.Context, e *Event) error
+	// Subscribe calls handler for every published event until ctx is done
+	Subscribe(ctx context.Context, handler func(*Event)) error
+}
@@ -0,0 +1,90 @@
+package fanout
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestMemoryBusDeliversToAllSubscribers(t *testing.T) {
+	bus := NewMemoryBus()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	received := make(chan string, 2)
+	for i := 0; i < 2; i++ {
+		go bus.Subscribe(ctx, func(e *Event) {
+			received <- e.To[0]
+		})
+	}
+
+	// Give both subscribers a chance to register
+	time.Sleep(50 * time.Millisecond)
+
+	err := bus.Publish(ctx, &Event{To: []string{"user2"}, Payload: json.RawMessage(`{"type":"chat"}`)})
+	if err != nil {
+		t.Fatal("publish failed", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case to := <-received:
+			if to != "user2" {
+				t.Errorf("expected recipient user2, got %s", to)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("event not delivered to every subscriber")
+		}
+	}
+}
+
+func TestMemoryBusStopsOnCancel(t *testing.T) {
+	bus := NewMemoryBus()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan error, 1)
+	go func() {
+		done <- bus.Subscribe(ctx, func(*Event) {})
+	}()
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Errorf("expected context.Canceled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("subscriber did not stop")
+	}
+}
+
+func TestMemoryBusHandlerCanPublish(t *testing.T) {
+	bus := NewMemoryBus()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Publishing from the handler, as delivery receipts are, must not wait on
+	// the subscriber it's running in, however many events it queues
+	const replies = 1000
+	received := make(chan struct{}, replies)
+	go bus.Subscribe(ctx, func(e *Event) {
+		if e.To[0] == "user2" {
+			for i := 0; i < replies; i++ {
+				bus.Publish(ctx, &Event{To: []string{"user1"}})
+			}
+			return
+		}
+		received <- struct{}{}
+	})
+	time.Sleep(50 * time.Millisecond)
+
+	bus.Publish(ctx, &Event{To: []string{"user2"}})
+	for i := 0; i < replies; i++ {
+		select {
+		case <-received:
+		case <-time.After(time.Second):
+			t.Fatalf("handler stalled after %d of %d replies", i, replies)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package fanout
+
+import (
+	"context"
+	"sync"
+)
+
+// memoryBus delivers events between subscribers of the same process. It is
+// used when running a single instance and as a stand-in for Redis in tests.
+type memoryBus struct {
+	mu     sync.RWMutex
+	subs   map[int]*subscriber
+	nextID int
+}
+
+// subscriber queues the events published to one subscription until its own
+// goroutine hands them to the handler. The queue has no bound, so publishing
+// never waits on a handler, including a handler that publishes.
+type subscriber struct {
+	mu    sync.Mutex
+	queue []*Event
+	ready chan struct{}
+}
+
+func (s *subscriber) push(e *Event) {
+	s.mu.Lock()
+	s.queue = append(s.queue, e)
+	s.mu.Unlock()
+
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (s *subscriber) take() []*Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	queue := s.queue
+	s.queue = nil
+	return queue
+}
+
+// NewMemoryBus creates an in-process Bus
+func NewMemoryBus() Bus {
+	return &memoryBus{subs: make(map[int]*subscriber)}
+}
+
+func (b *memoryBus) Publish(ctx context.Context, e *Event) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for _, sub := range b.subs {
+		sub.push(e)
+	}
+	return nil
+}
+
+func (b *memoryBus) Subscribe(ctx context.Context, handler func(*Event)) error {
+	sub := &subscriber{ready: make(chan struct{}, 1)}
+
+	b.mu.Lock()
+	id := b.nextID
+	b.nextID++
+	b.subs[id] = sub
+	b.mu.Unlock()
+
+	defer func() {
+		b.mu.Lock()
+		delete(b.subs, id)
+		b.mu.Unlock()
+	}()
+
+	for {
+		select {
+		case <-sub.ready:
+			for _, e := range sub.take() {
+				handler(e)
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
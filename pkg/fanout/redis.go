@@ -0,0 +1,56 @@
+package fanout
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// redisBus shares events between instances over a Redis Pub/Sub channel
+type redisBus struct {
+	client  *redis.Client
+	channel string
+}
+
+// NewRedisBus creates a Bus that publishes on the given Redis channel
+func NewRedisBus(client *redis.Client, channel string) Bus {
+	return &redisBus{client: client, channel: channel}
+}
+
+func (b *redisBus) Publish(ctx context.Context, e *Event) error {
+	by, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return b.client.Publish(ctx, b.channel, by).Err()
+}
+
+func (b *redisBus) Subscribe(ctx context.Context, handler func(*Event)) error {
+	pubsub := b.client.Subscribe(ctx, b.channel)
+	defer pubsub.Close()
+
+	// Wait for the subscription to be confirmed before consuming
+	if _, err := pubsub.Receive(ctx); err != nil {
+		return err
+	}
+
+	ch := pubsub.Channel()
+	for {
+		select {
+		case msg, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			var e Event
+			if err := json.Unmarshal([]byte(msg.Payload), &e); err != nil {
+				log.Println("Error decoding fan-out event:", err)
+				continue
+			}
+			handler(&e)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
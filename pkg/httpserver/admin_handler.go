@@ -0,0 +1,121 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"gochatapp/pkg/db"
+
+	"github.com/gorilla/mux"
+)
+
+type unlockReq struct {
+	Username string `json:"username"`
+	IP       string `json:"ip"`
+}
+
+type chatRetentionReq struct {
+	Retention string `json:"retention"` // a Go duration such as "720h", empty for the default
+}
+
+// isAdmin reports whether the user is listed in the comma separated ADMIN_USERS
+func isAdmin(username string) bool {
+	for _, admin := range strings.Split(os.Getenv("ADMIN_USERS"), ",") {
+		if admin = strings.TrimSpace(admin); admin != "" && admin == username {
+			return true
+		}
+	}
+	return false
+}
+
+// adminUser returns the authenticated user if they are an admin, writing a
+// 403 otherwise
+func adminUser(w http.ResponseWriter, r *http.Request) (string, bool) {
+	username, ok := currentUser(w, r, "")
+	if !ok {
+		return "", false
+	}
+	if !isAdmin(username) {
+		jsonError(w, http.StatusForbidden, "Admin access required")
+		return "", false
+	}
+	return username, true
+}
+
+// unlockLoginHandler lifts the login lockout of a username, a client IP or both
+func unlockLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := adminUser(w, r); !ok {
+		return
+	}
+
+	req := &unlockReq{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		jsonResponse(w, false, "Invalid request payload", nil, 0)
+		return
+	}
+	if req.Username == "" && req.IP == "" {
+		jsonResponse(w, false, "A username or an IP is required", nil, 0)
+		return
+	}
+
+	unlocked := false
+	if req.Username != "" {
+		cleared, err := db.ClearLoginFailures(db.DB, db.LoginScopeUser, req.Username)
+		if err != nil {
+			jsonResponse(w, false, "Failed to unlock login", nil, 0)
+			return
+		}
+		unlocked = unlocked || cleared
+	}
+	if req.IP != "" {
+		cleared, err := db.ClearLoginFailures(db.DB, db.LoginScopeIP, req.IP)
+		if err != nil {
+			jsonResponse(w, false, "Failed to unlock login", nil, 0)
+			return
+		}
+		unlocked = unlocked || cleared
+	}
+
+	if !unlocked {
+		jsonResponse(w, false, "No failed logins recorded", nil, 0)
+		return
+	}
+	jsonResponse(w, true, "Login unlocked", nil, 0)
+}
+
+// chatRetentionHandler sets how long the chats of a conversation stay in the
+// Redis hot tier. It applies to the chats sent from then on.
+func chatRetentionHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := adminUser(w, r); !ok {
+		return
+	}
+
+	req := &chatRetentionReq{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		jsonResponse(w, false, "Invalid request payload", nil, 0)
+		return
+	}
+	var retention time.Duration
+	if req.Retention != "" {
+		d, err := time.ParseDuration(req.Retention)
+		if err != nil || d < time.Second {
+			jsonResponse(w, false, "Invalid retention", nil, 0)
+			return
+		}
+		retention = d
+	}
+
+	err := db.SetChatRetention(db.DB, mux.Vars(r)["id"], retention)
+	if err == db.ErrConversationNotFound {
+		jsonResponse(w, false, "Invalid conversation ID", nil, 0)
+		return
+	}
+	if err != nil {
+		jsonResponse(w, false, "Failed to set chat retention", nil, 0)
+		return
+	}
+	jsonResponse(w, true, "Chat retention updated", nil, 0)
+}
@@ -0,0 +1,36 @@
+package httpserver
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestChatRetentionHandler(t *testing.T) {
+	mock := mockDB(t)
+	t.Setenv("ADMIN_USERS", "admin")
+	vars := map[string]string{"id": "3"}
+
+	mock.ExpectExec("UPDATE conversations SET chat_retention_seconds").
+		WithArgs("3", int64(30*24*3600)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	if res := serve(t, chatRetentionHandler, http.MethodPut, "admin", `{"retention":"720h"}`, vars); !res.Status {
+		t.Errorf("expected the retention to be set, got %q", res.Message)
+	}
+
+	// An empty retention goes back to the default
+	mock.ExpectExec("UPDATE conversations SET chat_retention_seconds").
+		WithArgs("3", nil).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	if res := serve(t, chatRetentionHandler, http.MethodPut, "admin", `{"retention":""}`, vars); !res.Status {
+		t.Errorf("expected the retention to be reset, got %q", res.Message)
+	}
+
+	if res := serve(t, chatRetentionHandler, http.MethodPut, "admin", `{"retention":"soon"}`, vars); res.Status {
+		t.Error("expected an invalid retention to be rejected")
+	}
+	if res := serve(t, chatRetentionHandler, http.MethodPut, "user1", `{"retention":"720h"}`, vars); res.Status {
+		t.Error("expected a non-admin to be turned away")
+	}
+}
@@ -0,0 +1,375 @@
+package httpserver
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"gochatapp/model"
+	"gochatapp/pkg/blob"
+	"gochatapp/pkg/db"
+	"gochatapp/pkg/thumbnail"
+	"gochatapp/pkg/ws"
+	"gochatapp/utils"
+
+	"github.com/gorilla/mux"
+)
+
+// attachmentStore holds the bytes of uploaded files, see openBlobStore
+var attachmentStore blob.BlobStore
+
+// attachmentMaxBytes is the largest file that can be uploaded, overridable
+// with ATTACHMENT_MAX_BYTES
+var attachmentMaxBytes = int64(utils.IntFromEnv("ATTACHMENT_MAX_BYTES", 10<<20))
+
+// attachmentTypes are the content types that can be uploaded, overridable
+// with a comma separated ATTACHMENT_TYPES. The type is sniffed from the
+// file itself, never taken from the client.
+var attachmentTypes = contentTypesFromEnv("ATTACHMENT_TYPES", []string{
+	"image/jpeg", "image/png", "image/gif", "image/webp",
+	"application/pdf", "text/plain",
+	"audio/mpeg", "audio/ogg", "audio/wave",
+	"video/mp4", "video/webm",
+})
+
+// thumbnailTypes are the image types a thumbnail is generated for
+var thumbnailTypes = map[string]bool{"image/jpeg": true, "image/png": true, "image/gif": true}
+
+// thumbnailSize bounds the longer side of a thumbnail
+const thumbnailSize = 320
+
+func contentTypesFromEnv(key string, fallback []string) map[string]bool {
+	types := fallback
+	if v := os.Getenv(key); v != "" {
+		types = strings.Split(v, ",")
+	}
+	allowed := make(map[string]bool, len(types))
+	for _, t := range types {
+		if t = strings.TrimSpace(t); t != "" {
+			allowed[t] = true
+		}
+	}
+	return allowed
+}
+
+// openBlobStore opens the store configured with BLOB_STORE: "local" (the
+// default) keeps files under BLOB_DIR, "s3" in the bucket described by the
+// S3_* variables
+func openBlobStore() (blob.BlobStore, error) {
+	switch kind := os.Getenv("BLOB_STORE"); kind {
+	case "", "local":
+		dir := os.Getenv("BLOB_DIR")
+		if dir == "" {
+			dir = "data/blobs"
+		}
+		return blob.NewLocalStore(dir)
+
+	case "s3":
+		cfg := blob.S3Config{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			Region:    os.Getenv("S3_REGION"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+		}
+		cfg.PathStyle, _ = strconv.ParseBool(os.Getenv("S3_PATH_STYLE"))
+		if cfg.Region == "" {
+			cfg.Region = "us-east-1"
+		}
+		if cfg.Endpoint == "" || cfg.Bucket == "" {
+			return nil, errors.New("S3_ENDPOINT and S3_BUCKET must be set")
+		}
+		return blob.NewS3Store(cfg), nil
+
+	default:
+		return nil, fmt.Errorf("unknown BLOB_STORE %q", kind)
+	}
+}
+
+// newAttachmentID returns a random ID, which also keeps attachment URLs
+// unguessable
+func newAttachmentID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// cleanFilename keeps the base name of an uploaded file, without control
+// characters and at most 255 characters long
+func cleanFilename(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, filepath.Base(strings.ReplaceAll(name, `\`, "/")))
+
+	if runes := []rune(name); len(runes) > 255 {
+		name = string(runes[:255])
+	}
+	if name == "" || name == "." || name == "/" {
+		return "file"
+	}
+	return name
+}
+
+// sniffContentType detects the type of an uploaded file from its first bytes
+// and rewinds it
+func sniffContentType(f multipart.File) (string, error) {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return http.DetectContentType(head[:n]), nil
+}
+
+// storeThumbnail generates and stores a thumbnail of an uploaded image,
+// recording its size on the attachment. An image that can't be decoded is
+// still accepted, just without a thumbnail.
+func storeThumbnail(ctx context.Context, a *model.Attachment, f multipart.File) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		log.Println("Error rewinding upload for thumbnail:", err)
+		return
+	}
+	data, size, err := thumbnail.Generate(f, thumbnailSize)
+	if err != nil {
+		log.Println("Error generating thumbnail of attachment", a.ID, err)
+		return
+	}
+
+	key := "thumbnails/" + a.ID + ".jpg"
+	if err := attachmentStore.Put(ctx, key, bytes.NewReader(data), int64(len(data)), "image/jpeg"); err != nil {
+		log.Println("Error storing thumbnail of attachment", a.ID, err)
+		return
+	}
+	a.ThumbnailKey, a.Width, a.Height = key, size.X, size.Y
+}
+
+// uploadAttachmentHandler stores the multipart "file" of the request. The
+// attachment it returns is sent by listing its ID in a chat's attachment_ids.
+func uploadAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	username, ok := currentUser(w, r, r.URL.Query().Get("username"))
+	if !ok {
+		return
+	}
+
+	// Leave room for the multipart framing around the file
+	r.Body = http.MaxBytesReader(w, r.Body, attachmentMaxBytes+64<<10)
+	if err := r.ParseMultipartForm(1 << 20); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			jsonError(w, http.StatusRequestEntityTooLarge, "File is too large")
+			return
+		}
+		jsonResponse(w, false, "Invalid multipart form", nil, 0)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		jsonResponse(w, false, "A file is required", nil, 0)
+		return
+	}
+	defer file.Close()
+
+	if header.Size > attachmentMaxBytes {
+		jsonError(w, http.StatusRequestEntityTooLarge, "File is too large")
+		return
+	}
+	if header.Size == 0 {
+		jsonResponse(w, false, "File is empty", nil, 0)
+		return
+	}
+
+	contentType, err := sniffContentType(file)
+	if err != nil {
+		jsonResponse(w, false, "Unable to read file", nil, 0)
+		return
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if !attachmentTypes[mediaType] {
+		jsonError(w, http.StatusUnsupportedMediaType, "Files of type "+mediaType+" can't be uploaded")
+		return
+	}
+
+	id, err := newAttachmentID()
+	if err != nil {
+		jsonResponse(w, false, "Failed to upload file", nil, 0)
+		return
+	}
+	a := &model.Attachment{
+		ID:          id,
+		Uploader:    username,
+		Filename:    cleanFilename(header.Filename),
+		ContentType: contentType,
+		Size:        header.Size,
+		StorageKey:  "attachments/" + id,
+	}
+
+	ctx := r.Context()
+	if err := attachmentStore.Put(ctx, a.StorageKey, file, a.Size, contentType); err != nil {
+		log.Println("Error storing attachment", a.ID, err)
+		jsonResponse(w, false, "Failed to upload file", nil, 0)
+		return
+	}
+	if thumbnailTypes[mediaType] {
+		storeThumbnail(ctx, a, file)
+	}
+
+	if err := db.StoreAttachment(db.DB, a); err != nil {
+		deleteBlobs(a)
+		jsonResponse(w, false, "Failed to upload file", nil, 0)
+		return
+	}
+
+	attachments := []model.Attachment{*a}
+	ws.SignAttachments(attachments)
+	jsonResponse(w, true, "File uploaded successfully", attachments[0], 0)
+}
+
+// deleteBlobs removes the stored files of an attachment that won't be recorded
+func deleteBlobs(a *model.Attachment) {
+	for _, key := range []string{a.StorageKey, a.ThumbnailKey} {
+		if key == "" {
+			continue
+		}
+		if err := attachmentStore.Delete(context.Background(), key); err != nil {
+			log.Println("Error deleting blob", key, err)
+		}
+	}
+}
+
+// visibleAttachment returns the attachment if username may see it: it's
+// their own upload or was sent to a conversation they're in. Attachments of
+// chats deleted for everyone are gone.
+func visibleAttachment(id, username string) (*model.Attachment, error) {
+	a, err := db.FetchAttachment(db.DB, id)
+	if err != nil {
+		return nil, err
+	}
+	if a.MessageID == "" {
+		if a.Uploader != username {
+			return nil, db.ErrAttachmentNotFound
+		}
+		return a, nil
+	}
+
+	chat, err := db.FetchChat(a.MessageID)
+	if err != nil {
+		return nil, err
+	}
+	if chat.Deleted {
+		return nil, db.ErrAttachmentNotFound
+	}
+	if _, err := db.FetchConversation(db.DB, chat.ConversationID, username); err != nil {
+		return nil, db.ErrAttachmentNotFound
+	}
+	return a, nil
+}
+
+// attachmentLinkHandler hands out fresh download URLs for an attachment,
+// once the ones a chat came with have expired
+func attachmentLinkHandler(w http.ResponseWriter, r *http.Request) {
+	username, ok := currentUser(w, r, r.URL.Query().Get("username"))
+	if !ok {
+		return
+	}
+
+	a, err := visibleAttachment(mux.Vars(r)["id"], username)
+	if err == db.ErrAttachmentNotFound || err == db.ErrChatNotFound {
+		jsonError(w, http.StatusNotFound, "Attachment not found")
+		return
+	}
+	if err != nil {
+		jsonResponse(w, false, "Unable to fetch attachment", nil, 0)
+		return
+	}
+
+	attachments := []model.Attachment{*a}
+	ws.SignAttachments(attachments)
+	jsonResponse(w, true, "Attachment fetched successfully", attachments[0], 0)
+}
+
+// downloadAttachmentHandler streams an attachment, or its thumbnail, to
+// whoever holds a signed URL for it. The URL is the authorization, so
+// clients can use it directly as an image source.
+func downloadAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if !utils.VerifyPath(r.URL.Path, q.Get("expires"), q.Get("signature")) {
+		jsonError(w, http.StatusForbidden, "Invalid or expired link")
+		return
+	}
+
+	a, err := db.FetchAttachment(db.DB, mux.Vars(r)["id"])
+	if err == nil && a.MessageID != "" {
+		// Deleting a chat for everyone revokes the links it was sent with
+		var chat *model.Chat
+		if chat, err = db.FetchChat(a.MessageID); err == nil && chat.Deleted {
+			err = db.ErrAttachmentNotFound
+		}
+	}
+	if err == db.ErrAttachmentNotFound || err == db.ErrChatNotFound {
+		jsonError(w, http.StatusNotFound, "Attachment not found")
+		return
+	}
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, "Unable to fetch attachment")
+		return
+	}
+
+	key, contentType, size := a.StorageKey, a.ContentType, a.Size
+	disposition := "attachment"
+	if strings.HasSuffix(r.URL.Path, "/thumbnail") {
+		if a.ThumbnailKey == "" {
+			jsonError(w, http.StatusNotFound, "Attachment has no thumbnail")
+			return
+		}
+		key, contentType, size, disposition = a.ThumbnailKey, "image/jpeg", -1, "inline"
+	} else if thumbnailTypes[strings.SplitN(contentType, ";", 2)[0]] {
+		disposition = "inline"
+	}
+
+	body, err := attachmentStore.Get(r.Context(), key)
+	if err == blob.ErrNotFound {
+		jsonError(w, http.StatusNotFound, "Attachment not found")
+		return
+	}
+	if err != nil {
+		log.Println("Error reading attachment", a.ID, err)
+		jsonError(w, http.StatusInternalServerError, "Unable to fetch attachment")
+		return
+	}
+	defer body.Close()
+
+	h := w.Header()
+	h.Set("Content-Type", contentType)
+	h.Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": a.Filename}))
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("Cache-Control", "private, max-age=3600")
+	if size >= 0 {
+		h.Set("Content-Length", strconv.FormatInt(size, 10))
+	}
+	if _, err := io.Copy(w, body); err != nil {
+		log.Println("Error streaming attachment", a.ID, err)
+	}
+}
@@ -0,0 +1,104 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"gochatapp/model"
+	"gochatapp/pkg/db"
+
+	"github.com/gorilla/mux"
+)
+
+type blockReq struct {
+	Username string `json:"username"` // the caller
+	Blocked  string `json:"blocked"`
+}
+
+type privacyReq struct {
+	Username string `json:"username"`
+	Messages string `json:"messages"` // who may send direct messages
+}
+
+// blockListHandler lists the users the caller has blocked
+func blockListHandler(w http.ResponseWriter, r *http.Request) {
+	username, ok := currentUser(w, r, r.URL.Query().Get("username"))
+	if !ok {
+		return
+	}
+
+	blocked, err := db.FetchBlockedUsers(db.DB, username)
+	if err != nil {
+		jsonResponse(w, false, "Unable to fetch blocked users", nil, 0)
+		return
+	}
+
+	jsonResponse(w, true, "Blocked users fetched successfully", blocked, len(blocked))
+}
+
+func blockUserHandler(w http.ResponseWriter, r *http.Request) {
+	req := &blockReq{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		jsonResponse(w, false, "Invalid request payload", nil, 0)
+		return
+	}
+
+	username, ok := currentUser(w, r, req.Username)
+	if !ok {
+		return
+	}
+
+	if req.Blocked == username || !db.IsUserExist(db.DB, req.Blocked) {
+		jsonResponse(w, false, "Invalid username", nil, 0)
+		return
+	}
+
+	if err := db.BlockUser(db.DB, username, req.Blocked); err != nil {
+		jsonResponse(w, false, "Failed to block user", nil, 0)
+		return
+	}
+
+	jsonResponse(w, true, "User blocked", nil, 0)
+}
+
+func unblockUserHandler(w http.ResponseWriter, r *http.Request) {
+	blocked := mux.Vars(r)["username"]
+
+	username, ok := currentUser(w, r, r.URL.Query().Get("username"))
+	if !ok {
+		return
+	}
+
+	if err := db.UnblockUser(db.DB, username, blocked); err != nil {
+		jsonResponse(w, false, "Failed to unblock user", nil, 0)
+		return
+	}
+
+	jsonResponse(w, true, "User unblocked", nil, 0)
+}
+
+// privacySettingsHandler sets who may send the caller direct messages
+func privacySettingsHandler(w http.ResponseWriter, r *http.Request) {
+	req := &privacyReq{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		jsonResponse(w, false, "Invalid request payload", nil, 0)
+		return
+	}
+
+	username, ok := currentUser(w, r, req.Username)
+	if !ok {
+		return
+	}
+
+	if !model.ValidMessagePrivacy(req.Messages) {
+		jsonResponse(w, false, "Messages must be everyone, contacts or mutual", nil, 0)
+		return
+	}
+
+	if err := db.SetMessagePrivacy(db.DB, username, req.Messages); err != nil {
+		jsonResponse(w, false, "Failed to update privacy settings", nil, 0)
+		return
+	}
+
+	jsonResponse(w, true, "Privacy settings updated", nil, 0)
+}
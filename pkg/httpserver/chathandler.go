@@ -4,9 +4,14 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+	"time"
 
+	"gochatapp/model"
 	"gochatapp/pkg/db"
+	auth "gochatapp/pkg/middleware"
+	"gochatapp/pkg/ratelimit"
 	"gochatapp/pkg/redisrepo"
+	"gochatapp/pkg/ws"
 	"gochatapp/utils"
 )
 
@@ -19,11 +24,17 @@ type userReq struct {
 	Username string `json:"username"`
 }
 
+type tokenReq struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
 type response struct {
-	Status  bool        `json:"status"`
-	Message string      `json:"message"`
-	Data    interface{} `json:"data,omitempty"`
-	Total   int         `json:"total,omitempty"`
+	Status     bool        `json:"status"`
+	Message    string      `json:"message"`
+	Data       interface{} `json:"data,omitempty"`
+	Total      int         `json:"total,omitempty"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+	PrevCursor string      `json:"prev_cursor,omitempty"`
 }
 
 func setJSONHeader(w http.ResponseWriter) {
@@ -40,6 +51,30 @@ func jsonResponse(w http.ResponseWriter, status bool, message string, data inter
 	})
 }
 
+// jsonError writes a failed response with an HTTP status other than 200
+func jsonError(w http.ResponseWriter, code int, message string) {
+	setJSONHeader(w)
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(response{
+		Status:  false,
+		Message: message,
+	})
+}
+
+// pagedResponse writes a successful page along with the cursors for the
+// neighbouring pages
+func pagedResponse(w http.ResponseWriter, message string, data interface{}, total int, next, prev string) {
+	setJSONHeader(w)
+	json.NewEncoder(w).Encode(response{
+		Status:     true,
+		Message:    message,
+		Data:       data,
+		Total:      total,
+		NextCursor: next,
+		PrevCursor: prev,
+	})
+}
+
 func registerHandler(w http.ResponseWriter, r *http.Request) {
 	u := &userInfo{}
 	if err := json.NewDecoder(r.Body).Decode(u); err != nil {
@@ -79,21 +114,161 @@ func loginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	ip := ratelimit.ClientIP(r)
+	if loginLocked(w, u.Username, ip) {
+		return
+	}
+
 	err := db.IsUserAuthentic(db.DB, u.Username, u.Password)
-	if err != nil {
+	if err == db.ErrInvalidCredentials {
+		recordLoginFailure(u.Username, ip)
 		jsonResponse(w, false, err.Error(), nil, 0)
 		return
 	}
-	// If authentication is successful, generate the JWT token
-	token, err := utils.CreateJWT(u.Username)
+	if err != nil {
+		jsonResponse(w, false, "Unable to log in, please try again", nil, 0)
+		return
+	}
+	if err := db.ClearLoginFailuresFrom(db.DB, db.LoginScopeUser, u.Username, ip); err != nil {
+		log.Println("Error clearing failed logins of", u.Username, err)
+	}
+
+	// If authentication is successful, generate the access and refresh tokens
+	tokens, err := issueTokens(u.Username)
 	if err != nil {
 		// If there is an error generating the token, return an error response
 		jsonResponse(w, false, "Error generating JWT token", nil, 0)
 		return
 	}
 
-	// Return a successful response with the JWT token
-	jsonResponse(w, true, "Login successful", map[string]string{"token": token}, 0)
+	// Return a successful response with the tokens
+	jsonResponse(w, true, "Login successful", tokens, 0)
+}
+
+// issueTokens creates an access token and the refresh token of a new family
+func issueTokens(username string) (map[string]interface{}, error) {
+	refreshToken, hash, err := utils.CreateRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+	jti, err := utils.NewTokenID()
+	if err != nil {
+		return nil, err
+	}
+	if err := db.StoreRefreshToken(db.DB, username, hash, jti, time.Now().Add(utils.RefreshTokenTTL())); err != nil {
+		return nil, err
+	}
+	return accessTokens(username, jti, refreshToken)
+}
+
+// accessTokens pairs a new access token, identified by jti, with the given
+// refresh token
+func accessTokens(username, jti, refreshToken string) (map[string]interface{}, error) {
+	token, err := utils.CreateJWT(username, jti)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"token":         token,
+		"refresh_token": refreshToken,
+		"expires_in":    int(utils.AccessTokenTTL().Seconds()),
+	}, nil
+}
+
+// refreshHandler exchanges a refresh token for a new access token and a new
+// refresh token; the one presented can't be used again
+func refreshHandler(w http.ResponseWriter, r *http.Request) {
+	req := &tokenReq{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil || req.RefreshToken == "" {
+		jsonResponse(w, false, "Invalid request payload", nil, 0)
+		return
+	}
+
+	refreshToken, hash, err := utils.CreateRefreshToken()
+	if err != nil {
+		jsonResponse(w, false, "Error generating JWT token", nil, 0)
+		return
+	}
+	jti, err := utils.NewTokenID()
+	if err != nil {
+		jsonResponse(w, false, "Error generating JWT token", nil, 0)
+		return
+	}
+
+	username, err := db.RotateRefreshToken(db.DB, utils.HashToken(req.RefreshToken), hash, jti,
+		time.Now().Add(utils.RefreshTokenTTL()))
+	if err == db.ErrInvalidRefreshToken || err == db.ErrRefreshTokenReused {
+		jsonResponse(w, false, "Invalid or expired refresh token", nil, 0)
+		return
+	}
+	if err != nil {
+		jsonResponse(w, false, "Unable to refresh token", nil, 0)
+		return
+	}
+
+	tokens, err := accessTokens(username, jti, refreshToken)
+	if err != nil {
+		jsonResponse(w, false, "Error generating JWT token", nil, 0)
+		return
+	}
+
+	jsonResponse(w, true, "Token refreshed", tokens, 0)
+}
+
+// logoutHandler revokes the access token of the request and, when given, the
+// refresh token it was obtained with
+func logoutHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := auth.ClaimsFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Invalid or expired token", http.StatusUnauthorized)
+		return
+	}
+	username := claims.Username
+
+	req := &tokenReq{}
+	json.NewDecoder(r.Body).Decode(req)
+
+	if err := redisrepo.DenyToken(claims.TokenID, claims.ExpiresAt); err != nil {
+		jsonResponse(w, false, "Failed to log out", nil, 0)
+		return
+	}
+
+	if req.RefreshToken != "" {
+		if err := db.RevokeRefreshToken(db.DB, username, utils.HashToken(req.RefreshToken)); err != nil {
+			jsonResponse(w, false, "Failed to log out", nil, 0)
+			return
+		}
+	}
+
+	jsonResponse(w, true, "Logged out", nil, 0)
+}
+
+// logoutAllHandler revokes every token of the user and signs out all their
+// connected devices
+func logoutAllHandler(w http.ResponseWriter, r *http.Request) {
+	username, ok := currentUser(w, r, "")
+	if !ok {
+		return
+	}
+
+	if err := db.RevokeUserRefreshTokens(db.DB, username); err != nil {
+		jsonResponse(w, false, "Failed to log out", nil, 0)
+		return
+	}
+	if err := redisrepo.RevokeTokensBefore(username, utils.AccessTokenTTL()); err != nil {
+		jsonResponse(w, false, "Failed to log out", nil, 0)
+		return
+	}
+
+	sessions, err := redisrepo.FetchSessions(username)
+	if err != nil {
+		log.Println("Error fetching sessions to sign out:", err)
+	}
+	for _, s := range sessions {
+		ws.RevokeSession(username, s.ID)
+	}
+
+	jsonResponse(w, true, "Logged out of all devices", nil, 0)
 }
 
 func verifyContactHandler(w http.ResponseWriter, r *http.Request) {
@@ -112,7 +287,11 @@ func verifyContactHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func chatHistoryHandler(w http.ResponseWriter, r *http.Request) {
-	u1 := r.URL.Query().Get("u1")
+	// u1 is the caller, u2 the other party
+	u1, ok := currentUser(w, r, r.URL.Query().Get("u1"))
+	if !ok {
+		return
+	}
 	u2 := r.URL.Query().Get("u2")
 	fromTS := r.URL.Query().Get("from-ts")
 	toTS := r.URL.Query().Get("to-ts")
@@ -129,29 +308,26 @@ func chatHistoryHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Try fetching recent chat from Redis
-	chats, err := redisrepo.FetchChatBetween(u1, u2, fromTS, toTS)
+	conversationID, err := db.FetchDirectConversationID(db.DB, u1, u2)
 	if err != nil {
-		log.Println("Error fetching chat history from Redis:", err)
+		jsonResponse(w, false, "Unable to fetch chat history", nil, 0)
+		return
 	}
-
-	// If no chat found in Redis, try fetching from PostgreSQL
-	if len(chats) == 0 {
-		chats, err = db.FetchChatBetween(u1, u2, fromTS, toTS)
-		if err != nil {
-			log.Println("Error fetching chat history from PostgreSQL:", err)
-			jsonResponse(w, false, "Unable to fetch chat history", nil, 0)
-			return
-		}
+	if conversationID == "" {
+		// The users have never talked
+		jsonResponse(w, true, "Chat history fetched successfully", []model.Chat{}, 0)
+		return
 	}
 
-	// Return the chat history
-	jsonResponse(w, true, "Chat history fetched successfully", chats, len(chats))
+	// Leave out the messages the caller deleted for themselves
+	fetchConversationHistory(w, r, conversationID, u1, fromTS, toTS)
 }
 
-
 func contactListHandler(w http.ResponseWriter, r *http.Request) {
-	username := r.URL.Query().Get("username")
+	username, ok := currentUser(w, r, r.URL.Query().Get("username"))
+	if !ok {
+		return
+	}
 
 	if !db.IsUserExist(db.DB, username) {
 		jsonResponse(w, false, "Invalid username", nil, 0)
@@ -165,5 +341,11 @@ func contactListHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if unread, err := redisrepo.FetchUnreadCounts(username); err == nil {
+		for i := range contacts {
+			contacts[i].Unread = unread[contacts[i].ConversationID]
+		}
+	}
+
 	jsonResponse(w, true, "Contact list fetched successfully", contacts, len(contacts))
 }
@@ -0,0 +1,158 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"gochatapp/model"
+	"gochatapp/pkg/db"
+	"gochatapp/pkg/ws"
+
+	"github.com/gorilla/mux"
+)
+
+type markReadReq struct {
+	Username string `json:"username"`
+	UpTo     string `json:"up_to"` // message ID, the latest one if left out
+}
+
+type conversationSettingsReq struct {
+	Username string `json:"username"`
+	Muted    *bool  `json:"muted"`
+	Pinned   *bool  `json:"pinned"`
+	Archived *bool  `json:"archived"`
+}
+
+// conversation fetches the conversation in the route as seen by username,
+// writing the error response if they aren't a member
+func conversation(w http.ResponseWriter, conversationID, username string) (*model.Conversation, bool) {
+	conv, err := db.FetchConversation(db.DB, conversationID, username)
+	if err == db.ErrNotConversationMember {
+		jsonResponse(w, false, "You are not a member of this conversation", nil, 0)
+		return nil, false
+	}
+	if err != nil {
+		jsonResponse(w, false, "Unable to fetch conversation", nil, 0)
+		return nil, false
+	}
+	return conv, true
+}
+
+func conversationListHandler(w http.ResponseWriter, r *http.Request) {
+	username, ok := currentUser(w, r, r.URL.Query().Get("username"))
+	if !ok {
+		return
+	}
+	archived := r.URL.Query().Get("archived") == "true"
+
+	if !db.IsUserExist(db.DB, username) {
+		jsonResponse(w, false, "Invalid username", nil, 0)
+		return
+	}
+
+	conversations, err := db.FetchConversations(db.DB, username, archived)
+	if err != nil {
+		jsonResponse(w, false, "Unable to fetch conversations", nil, 0)
+		return
+	}
+
+	jsonResponse(w, true, "Conversations fetched successfully", conversations, len(conversations))
+}
+
+func conversationHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	conversationID := mux.Vars(r)["id"]
+	username, ok := currentUser(w, r, r.URL.Query().Get("username"))
+	if !ok {
+		return
+	}
+	fromTS := r.URL.Query().Get("from-ts")
+	toTS := r.URL.Query().Get("to-ts")
+
+	if fromTS == "" {
+		fromTS = "0"
+	}
+	if toTS == "" {
+		toTS = "+inf"
+	}
+
+	if _, ok := conversation(w, conversationID, username); !ok {
+		return
+	}
+
+	fetchConversationHistory(w, r, conversationID, username, fromTS, toTS)
+}
+
+func conversationUnreadHandler(w http.ResponseWriter, r *http.Request) {
+	conversationID := mux.Vars(r)["id"]
+	username, ok := currentUser(w, r, r.URL.Query().Get("username"))
+	if !ok {
+		return
+	}
+
+	conv, ok := conversation(w, conversationID, username)
+	if !ok {
+		return
+	}
+
+	jsonResponse(w, true, "Unread count fetched successfully", map[string]interface{}{
+		"conversation_id": conv.ID,
+		"last_read_id":    conv.LastReadID,
+		"unread":          conv.Unread,
+	}, conv.Unread)
+}
+
+// conversationReadHandler moves the caller's read marker in a conversation
+// and returns its new unread count along with the caller's total
+func conversationReadHandler(w http.ResponseWriter, r *http.Request) {
+	conversationID := mux.Vars(r)["id"]
+
+	req := &markReadReq{}
+	if err := decodeOptional(r, req); err != nil {
+		jsonResponse(w, false, "Invalid request payload", nil, 0)
+		return
+	}
+
+	username, ok := currentUser(w, r, req.Username)
+	if !ok {
+		return
+	}
+
+	unread, err := ws.MarkConversationRead(username, conversationID, req.UpTo)
+	switch err {
+	case nil:
+		jsonResponse(w, true, "Conversation marked as read", unread, unread.Unread)
+	case db.ErrNotConversationMember:
+		jsonResponse(w, false, "You are not a member of this conversation", nil, 0)
+	case db.ErrChatNotFound:
+		jsonResponse(w, false, "Invalid up_to", nil, 0)
+	default:
+		jsonResponse(w, false, "Failed to mark conversation as read", nil, 0)
+	}
+}
+
+func conversationSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	conversationID := mux.Vars(r)["id"]
+
+	req := &conversationSettingsReq{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		jsonResponse(w, false, "Invalid request payload", nil, 0)
+		return
+	}
+
+	username, ok := currentUser(w, r, req.Username)
+	if !ok {
+		return
+	}
+
+	err := db.UpdateConversationSettings(db.DB, conversationID, username, req.Muted, req.Pinned, req.Archived)
+	if err == db.ErrNotConversationMember {
+		jsonResponse(w, false, "You are not a member of this conversation", nil, 0)
+		return
+	}
+	if err != nil {
+		jsonResponse(w, false, "Failed to update conversation settings", nil, 0)
+		return
+	}
+
+	jsonResponse(w, true, "Conversation settings updated", nil, 0)
+}
@@ -0,0 +1,91 @@
+package httpserver
+
+import (
+	"net/http"
+	"testing"
+
+	"gochatapp/model"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+var conversationColumns = []string{"id", "kind", "peer", "group_id", "name", "last_activity",
+	"muted", "pinned", "archived", "last_read_id", "unread"}
+
+// expectConversation mocks fetching a conversation as username, as a member
+// with unread messages, or as an outsider if unread is negative
+func expectConversation(mock sqlmock.Sqlmock, conversationID, username string, unread int) {
+	rows := sqlmock.NewRows(conversationColumns)
+	if unread >= 0 {
+		rows.AddRow(conversationID, model.ConversationDirect, "user2", "", "", 1700000000.0, false, false, false, "5", unread)
+	}
+	mock.ExpectQuery("FROM conversations c").WithArgs(username, conversationID).WillReturnRows(rows)
+}
+
+func TestConversationListHandler(t *testing.T) {
+	mock := mockDB(t)
+
+	expectUserExists(mock, "user1", true)
+	mock.ExpectQuery("WHERE cm.username = \\$1 AND cm.archived = \\$2").
+		WithArgs("user1", false).
+		WillReturnRows(sqlmock.NewRows(conversationColumns).
+			AddRow("3", model.ConversationDirect, "user2", "", "", 1700000100.0, false, true, false, "5", 2).
+			AddRow("9", model.ConversationGroup, "", "4", "friends", 1700000000.0, true, false, false, "0", 0))
+
+	res := serve(t, conversationListHandler, http.MethodGet, "user1", "", nil)
+	if !res.Status || res.Total != 2 {
+		t.Fatalf("expected 2 conversations, got %+v", res)
+	}
+	convs, _ := res.Data.([]interface{})
+	first, _ := convs[0].(map[string]interface{})
+	if first["id"] != "3" || first["peer"] != "user2" || first["pinned"] != true || first["unread"] != 2.0 {
+		t.Errorf("unexpected first conversation %+v", first)
+	}
+}
+
+func TestConversationHandlersNeedMembership(t *testing.T) {
+	mock := mockDB(t)
+	vars := map[string]string{"id": "3"}
+
+	expectConversation(mock, "3", "user5", -1)
+	if res := serve(t, conversationHistoryHandler, http.MethodGet, "user5", "", vars); res.Status {
+		t.Error("expected an outsider to be unable to read the history")
+	}
+	expectConversation(mock, "3", "user5", -1)
+	if res := serve(t, conversationUnreadHandler, http.MethodGet, "user5", "", vars); res.Status {
+		t.Error("expected an outsider to be unable to see the unread count")
+	}
+	mock.ExpectExec("UPDATE conversation_members").
+		WithArgs("3", "user5", nil, true, nil).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	if res := serve(t, conversationSettingsHandler, http.MethodPut, "user5", `{"pinned":true}`, vars); res.Status {
+		t.Error("expected an outsider to be unable to change settings")
+	}
+}
+
+func TestConversationUnreadHandler(t *testing.T) {
+	mock := mockDB(t)
+
+	expectConversation(mock, "3", "user1", 4)
+	res := serve(t, conversationUnreadHandler, http.MethodGet, "user1", "", map[string]string{"id": "3"})
+	if !res.Status || res.Total != 4 {
+		t.Fatalf("expected 4 unread, got %+v", res)
+	}
+	data, _ := res.Data.(map[string]interface{})
+	if data["conversation_id"] != "3" || data["last_read_id"] != "5" {
+		t.Errorf("unexpected unread data %+v", data)
+	}
+}
+
+func TestConversationSettingsHandler(t *testing.T) {
+	mock := mockDB(t)
+
+	// Flags left out of the body stay as they are
+	mock.ExpectExec("UPDATE conversation_members").
+		WithArgs("3", "user1", true, nil, false).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	res := serve(t, conversationSettingsHandler, http.MethodPut, "user1", `{"muted":true,"archived":false}`, map[string]string{"id": "3"})
+	if !res.Status {
+		t.Errorf("expected the settings to be updated, got %q", res.Message)
+	}
+}
@@ -1,7 +1,6 @@
 package httpserver
 
 import (
-	"encoding/json"
 	"net/http"
 	"gochatapp/pkg/db"
 )
@@ -9,11 +8,16 @@ import (
 
 func sendFollowRequestHandler(w http.ResponseWriter, r *http.Request) {
 	u := &userReq{}
-	if err := json.NewDecoder(r.Body).Decode(u); err != nil {
+	if err := decodeOptional(r, u); err != nil {
 		jsonResponse(w, false, "Invalid request payload", nil, 0)
 		return
 	}
 
+	username, ok := currentUser(w, r, u.Username)
+	if !ok {
+		return
+	}
+
 	contactUsername := r.URL.Query().Get("contact_username")
 	if contactUsername == "" {
 		jsonResponse(w, false, "Contact username is required", nil, 0)
@@ -21,12 +25,17 @@ func sendFollowRequestHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Validate users
-	if !db.IsUserExist(db.DB, u.Username) || !db.IsUserExist(db.DB, contactUsername) {
+	if !db.IsUserExist(db.DB, username) || !db.IsUserExist(db.DB, contactUsername) {
 		jsonResponse(w, false, "Invalid username(s)", nil, 0)
 		return
 	}
 
-	err := db.SendFollowRequest(db.DB, u.Username, contactUsername)
+	if blocked, err := db.FetchBlockedBetween(db.DB, username, []string{contactUsername}); err != nil || blocked[contactUsername] {
+		jsonResponse(w, false, "Failed to send follow request", nil, 0)
+		return
+	}
+
+	err := db.SendFollowRequest(db.DB, username, contactUsername)
 	if err != nil {
 		jsonResponse(w, false, "Failed to send follow request", nil, 0)
 		return
@@ -37,11 +46,16 @@ func sendFollowRequestHandler(w http.ResponseWriter, r *http.Request) {
 
 func acceptFollowRequestHandler(w http.ResponseWriter, r *http.Request) {
 	u := &userReq{}
-	if err := json.NewDecoder(r.Body).Decode(u); err != nil {
+	if err := decodeOptional(r, u); err != nil {
 		jsonResponse(w, false, "Invalid request payload", nil, 0)
 		return
 	}
 
+	username, ok := currentUser(w, r, u.Username)
+	if !ok {
+		return
+	}
+
 	contactUsername := r.URL.Query().Get("contact_username")
 	if contactUsername == "" {
 		jsonResponse(w, false, "Contact username is required", nil, 0)
@@ -49,12 +63,12 @@ func acceptFollowRequestHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Validate users
-	if !db.IsUserExist(db.DB, u.Username) || !db.IsUserExist(db.DB, contactUsername) {
+	if !db.IsUserExist(db.DB, username) || !db.IsUserExist(db.DB, contactUsername) {
 		jsonResponse(w, false, "Invalid username(s)", nil, 0)
 		return
 	}
 
-	err := db.AcceptFollowRequest(db.DB, u.Username, contactUsername)
+	err := db.AcceptFollowRequest(db.DB, username, contactUsername)
 	if err != nil {
 		jsonResponse(w, false, "Failed to accept follow request", nil, 0)
 		return
@@ -65,11 +79,16 @@ func acceptFollowRequestHandler(w http.ResponseWriter, r *http.Request) {
 
 func rejectFollowRequestHandler(w http.ResponseWriter, r *http.Request) {
 	u := &userReq{}
-	if err := json.NewDecoder(r.Body).Decode(u); err != nil {
+	if err := decodeOptional(r, u); err != nil {
 		jsonResponse(w, false, "Invalid request payload", nil, 0)
 		return
 	}
 
+	username, ok := currentUser(w, r, u.Username)
+	if !ok {
+		return
+	}
+
 	contactUsername := r.URL.Query().Get("contact_username")
 	if contactUsername == "" {
 		jsonResponse(w, false, "Contact username is required", nil, 0)
@@ -77,12 +96,12 @@ func rejectFollowRequestHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Validate users
-	if !db.IsUserExist(db.DB, u.Username) || !db.IsUserExist(db.DB, contactUsername) {
+	if !db.IsUserExist(db.DB, username) || !db.IsUserExist(db.DB, contactUsername) {
 		jsonResponse(w, false, "Invalid username(s)", nil, 0)
 		return
 	}
 
-	err := db.RejectFollowRequest(db.DB, u.Username, contactUsername)
+	err := db.RejectFollowRequest(db.DB, username, contactUsername)
 	if err != nil {
 		jsonResponse(w, false, "Failed to reject follow request", nil, 0)
 		return
@@ -92,7 +111,10 @@ func rejectFollowRequestHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func pendingFollowRequestsHandler(w http.ResponseWriter, r *http.Request) {
-	username := r.URL.Query().Get("u")
+	username, ok := currentUser(w, r, r.URL.Query().Get("u"))
+	if !ok {
+		return
+	}
 
 	if !db.IsUserExist(db.DB, username) {
 		jsonResponse(w, false, "Invalid username", nil, 0)
@@ -0,0 +1,333 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"gochatapp/model"
+	"gochatapp/pkg/db"
+
+	"github.com/gorilla/mux"
+)
+
+type groupReq struct {
+	Username string   `json:"username"`
+	Name     string   `json:"name"`
+	Members  []string `json:"members"`
+	Member   string   `json:"member"`
+	Role     string   `json:"role"`
+}
+
+// canManageGroup reports whether a role may rename the group and add or remove members
+func canManageGroup(role string) bool {
+	return role == model.GroupRoleCreator || role == model.GroupRoleAdmin
+}
+
+// groupRole checks that username belongs to the group in the route and
+// returns their role, writing the error response if they don't
+func groupRole(w http.ResponseWriter, groupID, username string) (string, bool) {
+	role, err := db.FetchGroupRole(db.DB, groupID, username)
+	if err == db.ErrNotGroupMember {
+		jsonResponse(w, false, "You are not a member of this group", nil, 0)
+		return "", false
+	}
+	if err != nil {
+		jsonResponse(w, false, "Unable to fetch group", nil, 0)
+		return "", false
+	}
+	return role, true
+}
+
+func createGroupHandler(w http.ResponseWriter, r *http.Request) {
+	g := &groupReq{}
+	if err := json.NewDecoder(r.Body).Decode(g); err != nil {
+		jsonResponse(w, false, "Invalid request payload", nil, 0)
+		return
+	}
+
+	username, ok := currentUser(w, r, g.Username)
+	if !ok {
+		return
+	}
+
+	if g.Name == "" {
+		jsonResponse(w, false, "Group name is required", nil, 0)
+		return
+	}
+
+	// Validate members
+	for _, member := range g.Members {
+		if !db.IsUserExist(db.DB, member) {
+			jsonResponse(w, false, "Invalid member username(s)", nil, 0)
+			return
+		}
+	}
+
+	group, err := db.CreateGroup(db.DB, g.Name, username, g.Members)
+	if err != nil {
+		jsonResponse(w, false, "Failed to create group", nil, 0)
+		return
+	}
+
+	jsonResponse(w, true, "Group created", group, 0)
+}
+
+func listGroupsHandler(w http.ResponseWriter, r *http.Request) {
+	username, ok := currentUser(w, r, r.URL.Query().Get("username"))
+	if !ok {
+		return
+	}
+
+	if !db.IsUserExist(db.DB, username) {
+		jsonResponse(w, false, "Invalid username", nil, 0)
+		return
+	}
+
+	groups, err := db.FetchUserGroups(db.DB, username)
+	if err != nil {
+		jsonResponse(w, false, "Unable to fetch groups", nil, 0)
+		return
+	}
+
+	jsonResponse(w, true, "Groups fetched successfully", groups, len(groups))
+}
+
+func renameGroupHandler(w http.ResponseWriter, r *http.Request) {
+	groupID := mux.Vars(r)["id"]
+
+	g := &groupReq{}
+	if err := json.NewDecoder(r.Body).Decode(g); err != nil {
+		jsonResponse(w, false, "Invalid request payload", nil, 0)
+		return
+	}
+
+	username, ok := currentUser(w, r, g.Username)
+	if !ok {
+		return
+	}
+
+	if g.Name == "" {
+		jsonResponse(w, false, "Group name is required", nil, 0)
+		return
+	}
+
+	role, ok := groupRole(w, groupID, username)
+	if !ok {
+		return
+	}
+	if !canManageGroup(role) {
+		jsonResponse(w, false, "Only admins can rename the group", nil, 0)
+		return
+	}
+
+	if err := db.RenameGroup(db.DB, groupID, g.Name); err != nil {
+		jsonResponse(w, false, "Failed to rename group", nil, 0)
+		return
+	}
+
+	jsonResponse(w, true, "Group renamed", nil, 0)
+}
+
+func groupMembersHandler(w http.ResponseWriter, r *http.Request) {
+	groupID := mux.Vars(r)["id"]
+	username, ok := currentUser(w, r, r.URL.Query().Get("username"))
+	if !ok {
+		return
+	}
+
+	if _, ok := groupRole(w, groupID, username); !ok {
+		return
+	}
+
+	members, err := db.FetchGroupMembers(db.DB, groupID)
+	if err != nil {
+		jsonResponse(w, false, "Unable to fetch group members", nil, 0)
+		return
+	}
+
+	jsonResponse(w, true, "Group members fetched successfully", members, len(members))
+}
+
+func addGroupMemberHandler(w http.ResponseWriter, r *http.Request) {
+	groupID := mux.Vars(r)["id"]
+
+	g := &groupReq{}
+	if err := json.NewDecoder(r.Body).Decode(g); err != nil {
+		jsonResponse(w, false, "Invalid request payload", nil, 0)
+		return
+	}
+
+	username, ok := currentUser(w, r, g.Username)
+	if !ok {
+		return
+	}
+
+	role, ok := groupRole(w, groupID, username)
+	if !ok {
+		return
+	}
+	if !canManageGroup(role) {
+		jsonResponse(w, false, "Only admins can add members", nil, 0)
+		return
+	}
+
+	if !db.IsUserExist(db.DB, g.Member) {
+		jsonResponse(w, false, "Invalid member username", nil, 0)
+		return
+	}
+	if _, err := db.FetchGroupRole(db.DB, groupID, g.Member); err == nil {
+		jsonResponse(w, false, "User is already a member", nil, 0)
+		return
+	}
+
+	if err := db.AddGroupMember(db.DB, groupID, g.Member, model.GroupRoleMember); err != nil {
+		jsonResponse(w, false, "Failed to add member", nil, 0)
+		return
+	}
+
+	jsonResponse(w, true, "Member added", nil, 0)
+}
+
+// updateGroupMemberHandler promotes a member to admin or demotes an admin;
+// only the creator can change roles
+func updateGroupMemberHandler(w http.ResponseWriter, r *http.Request) {
+	groupID := mux.Vars(r)["id"]
+	member := mux.Vars(r)["member"]
+
+	g := &groupReq{}
+	if err := json.NewDecoder(r.Body).Decode(g); err != nil {
+		jsonResponse(w, false, "Invalid request payload", nil, 0)
+		return
+	}
+
+	username, ok := currentUser(w, r, g.Username)
+	if !ok {
+		return
+	}
+
+	if g.Role != model.GroupRoleAdmin && g.Role != model.GroupRoleMember {
+		jsonResponse(w, false, "Role must be admin or member", nil, 0)
+		return
+	}
+
+	role, ok := groupRole(w, groupID, username)
+	if !ok {
+		return
+	}
+	if role != model.GroupRoleCreator {
+		jsonResponse(w, false, "Only the creator can change roles", nil, 0)
+		return
+	}
+
+	memberRole, err := db.FetchGroupRole(db.DB, groupID, member)
+	if err != nil || memberRole == model.GroupRoleCreator {
+		jsonResponse(w, false, "Invalid member username", nil, 0)
+		return
+	}
+
+	if err := db.AddGroupMember(db.DB, groupID, member, g.Role); err != nil {
+		jsonResponse(w, false, "Failed to update member role", nil, 0)
+		return
+	}
+
+	jsonResponse(w, true, "Member role updated", nil, 0)
+}
+
+func removeGroupMemberHandler(w http.ResponseWriter, r *http.Request) {
+	groupID := mux.Vars(r)["id"]
+	member := mux.Vars(r)["member"]
+
+	g := &groupReq{}
+	if err := decodeOptional(r, g); err != nil {
+		jsonResponse(w, false, "Invalid request payload", nil, 0)
+		return
+	}
+
+	username, ok := currentUser(w, r, g.Username)
+	if !ok {
+		return
+	}
+
+	role, ok := groupRole(w, groupID, username)
+	if !ok {
+		return
+	}
+	if !canManageGroup(role) {
+		jsonResponse(w, false, "Only admins can remove members", nil, 0)
+		return
+	}
+
+	memberRole, err := db.FetchGroupRole(db.DB, groupID, member)
+	if err != nil {
+		jsonResponse(w, false, "Invalid member username", nil, 0)
+		return
+	}
+	// Nobody can remove the creator, and only the creator can remove admins
+	if memberRole == model.GroupRoleCreator || (memberRole == model.GroupRoleAdmin && role != model.GroupRoleCreator) {
+		jsonResponse(w, false, "Not allowed to remove this member", nil, 0)
+		return
+	}
+
+	if err := db.RemoveGroupMember(db.DB, groupID, member); err != nil {
+		jsonResponse(w, false, "Failed to remove member", nil, 0)
+		return
+	}
+
+	jsonResponse(w, true, "Member removed", nil, 0)
+}
+
+func leaveGroupHandler(w http.ResponseWriter, r *http.Request) {
+	groupID := mux.Vars(r)["id"]
+
+	u := &userReq{}
+	if err := decodeOptional(r, u); err != nil {
+		jsonResponse(w, false, "Invalid request payload", nil, 0)
+		return
+	}
+
+	username, ok := currentUser(w, r, u.Username)
+	if !ok {
+		return
+	}
+
+	err := db.LeaveGroup(db.DB, groupID, username)
+	if err == db.ErrNotGroupMember {
+		jsonResponse(w, false, "You are not a member of this group", nil, 0)
+		return
+	}
+	if err != nil {
+		jsonResponse(w, false, "Failed to leave group", nil, 0)
+		return
+	}
+
+	jsonResponse(w, true, "Left group", nil, 0)
+}
+
+func groupHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	groupID := mux.Vars(r)["id"]
+	username, ok := currentUser(w, r, r.URL.Query().Get("username"))
+	if !ok {
+		return
+	}
+	fromTS := r.URL.Query().Get("from-ts")
+	toTS := r.URL.Query().Get("to-ts")
+
+	if fromTS == "" {
+		fromTS = "0"
+	}
+	if toTS == "" {
+		toTS = "+inf"
+	}
+
+	if _, ok := groupRole(w, groupID, username); !ok {
+		return
+	}
+
+	conversationID, err := db.FetchGroupConversationID(db.DB, groupID)
+	if err != nil {
+		jsonResponse(w, false, "Unable to fetch group history", nil, 0)
+		return
+	}
+
+	fetchConversationHistory(w, r, conversationID, username, fromTS, toTS)
+}
@@ -0,0 +1,196 @@
+package httpserver
+
+import (
+	"net/http"
+	"testing"
+
+	"gochatapp/model"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestRemoveGroupMemberWithoutBody(t *testing.T) {
+	mock := mockDB(t)
+
+	mock.ExpectQuery("SELECT role FROM group_members").
+		WithArgs("4", "user1").
+		WillReturnRows(sqlmock.NewRows([]string{"role"}).AddRow(model.GroupRoleCreator))
+	mock.ExpectQuery("SELECT role FROM group_members").
+		WithArgs("4", "user3").
+		WillReturnRows(sqlmock.NewRows([]string{"role"}).AddRow(model.GroupRoleMember))
+	mock.ExpectExec("DELETE FROM group_members").
+		WithArgs("4", "user3").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	res := serve(t, removeGroupMemberHandler, http.MethodDelete, "user1", "", map[string]string{"id": "4", "member": "user3"})
+	if !res.Status {
+		t.Errorf("expected the member to be removed, got %q", res.Message)
+	}
+}
+
+// expectRole mocks looking up the role of username in a group, or finding
+// they aren't a member if role is empty
+func expectRole(mock sqlmock.Sqlmock, groupID, username, role string) {
+	rows := sqlmock.NewRows([]string{"role"})
+	if role != "" {
+		rows.AddRow(role)
+	}
+	mock.ExpectQuery("SELECT role FROM group_members").WithArgs(groupID, username).WillReturnRows(rows)
+}
+
+func expectUserExists(mock sqlmock.Sqlmock, username string, exists bool) {
+	mock.ExpectQuery("SELECT EXISTS \\(SELECT 1 FROM users").
+		WithArgs(username).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(exists))
+}
+
+func TestCreateGroupHandler(t *testing.T) {
+	mock := mockDB(t)
+
+	expectUserExists(mock, "user2", true)
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO groups").
+		WithArgs("friends", "user1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow("4", 1700000000.0))
+	// The caller creates the group, whatever the body says
+	mock.ExpectExec("INSERT INTO group_members").
+		WithArgs("4", "user1", model.GroupRoleCreator).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO group_members").
+		WithArgs("4", "user2", model.GroupRoleMember).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("INSERT INTO conversations").
+		WithArgs("4").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("9"))
+	mock.ExpectExec("INSERT INTO conversation_members").
+		WithArgs("9", "4").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	res := serve(t, createGroupHandler, http.MethodPost, "user1", `{"name":"friends","members":["user2"]}`, nil)
+	if !res.Status {
+		t.Fatalf("expected the group to be created, got %q", res.Message)
+	}
+	group, _ := res.Data.(map[string]interface{})
+	if group["id"] != "4" || group["created_by"] != "user1" || group["conversation_id"] != "9" {
+		t.Errorf("unexpected group %+v", res.Data)
+	}
+}
+
+func TestCreateGroupHandlerValidates(t *testing.T) {
+	mock := mockDB(t)
+
+	if res := serve(t, createGroupHandler, http.MethodPost, "user1", `{"members":["user2"]}`, nil); res.Status {
+		t.Error("expected a group without a name to be rejected")
+	}
+
+	expectUserExists(mock, "ghost", false)
+	if res := serve(t, createGroupHandler, http.MethodPost, "user1", `{"name":"friends","members":["ghost"]}`, nil); res.Status {
+		t.Error("expected an unknown member to be rejected")
+	}
+
+	if res := serve(t, createGroupHandler, http.MethodPost, "user1", `{"username":"user2","name":"friends"}`, nil); res.Status {
+		t.Error("expected creating a group as someone else to be rejected")
+	}
+}
+
+func TestGroupManagementNeedsAnAdmin(t *testing.T) {
+	mock := mockDB(t)
+	vars := map[string]string{"id": "4", "member": "user3"}
+
+	// A plain member can't rename the group or add and remove members, and
+	// nothing is written when they try
+	expectRole(mock, "4", "user2", model.GroupRoleMember)
+	if res := serve(t, renameGroupHandler, http.MethodPut, "user2", `{"name":"mine"}`, vars); res.Status {
+		t.Error("expected a member to be unable to rename the group")
+	}
+	expectRole(mock, "4", "user2", model.GroupRoleMember)
+	if res := serve(t, addGroupMemberHandler, http.MethodPost, "user2", `{"member":"user3"}`, vars); res.Status {
+		t.Error("expected a member to be unable to add members")
+	}
+	expectRole(mock, "4", "user2", model.GroupRoleMember)
+	if res := serve(t, removeGroupMemberHandler, http.MethodDelete, "user2", "", vars); res.Status {
+		t.Error("expected a member to be unable to remove members")
+	}
+
+	// Only the creator changes roles
+	expectRole(mock, "4", "user2", model.GroupRoleAdmin)
+	if res := serve(t, updateGroupMemberHandler, http.MethodPut, "user2", `{"role":"admin"}`, vars); res.Status {
+		t.Error("expected an admin to be unable to change roles")
+	}
+
+	// Outsiders don't see the members
+	expectRole(mock, "4", "user5", "")
+	if res := serve(t, groupMembersHandler, http.MethodGet, "user5", "", vars); res.Status {
+		t.Error("expected an outsider to be unable to list members")
+	}
+}
+
+func TestAddGroupMemberHandler(t *testing.T) {
+	mock := mockDB(t)
+	vars := map[string]string{"id": "4"}
+
+	expectRole(mock, "4", "user1", model.GroupRoleAdmin)
+	expectUserExists(mock, "user3", true)
+	expectRole(mock, "4", "user3", "")
+	mock.ExpectExec("INSERT INTO group_members").
+		WithArgs("4", "user3", model.GroupRoleMember).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	if res := serve(t, addGroupMemberHandler, http.MethodPost, "user1", `{"member":"user3"}`, vars); !res.Status {
+		t.Errorf("expected the member to be added, got %q", res.Message)
+	}
+
+	// Adding someone twice doesn't reset their role
+	expectRole(mock, "4", "user1", model.GroupRoleAdmin)
+	expectUserExists(mock, "user3", true)
+	expectRole(mock, "4", "user3", model.GroupRoleAdmin)
+	if res := serve(t, addGroupMemberHandler, http.MethodPost, "user1", `{"member":"user3"}`, vars); res.Status {
+		t.Error("expected an existing member to be rejected")
+	}
+}
+
+func TestRemoveGroupMemberProtectsTheCreator(t *testing.T) {
+	mock := mockDB(t)
+
+	// An admin can't remove the creator, nor another admin
+	for member, role := range map[string]string{"user1": model.GroupRoleCreator, "user3": model.GroupRoleAdmin} {
+		expectRole(mock, "4", "user2", model.GroupRoleAdmin)
+		expectRole(mock, "4", member, role)
+		vars := map[string]string{"id": "4", "member": member}
+		if res := serve(t, removeGroupMemberHandler, http.MethodDelete, "user2", "", vars); res.Status {
+			t.Errorf("expected an admin to be unable to remove the %s", role)
+		}
+	}
+}
+
+func TestLeaveGroupHandsTheGroupOver(t *testing.T) {
+	mock := mockDB(t)
+	vars := map[string]string{"id": "4"}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("DELETE FROM group_members").
+		WithArgs("4", "user1").
+		WillReturnRows(sqlmock.NewRows([]string{"role"}).AddRow(model.GroupRoleCreator))
+	mock.ExpectExec("DELETE FROM conversation_members").
+		WithArgs("4", "user1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT username FROM group_members").
+		WithArgs("4").
+		WillReturnRows(sqlmock.NewRows([]string{"username"}).AddRow("user2"))
+	mock.ExpectExec("UPDATE group_members SET role").
+		WithArgs("4", "user2", model.GroupRoleCreator).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	if res := serve(t, leaveGroupHandler, http.MethodPost, "user1", "", vars); !res.Status {
+		t.Errorf("expected the creator to leave, got %q", res.Message)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("DELETE FROM group_members").
+		WithArgs("4", "user5").
+		WillReturnRows(sqlmock.NewRows([]string{"role"}))
+	mock.ExpectRollback()
+	if res := serve(t, leaveGroupHandler, http.MethodPost, "user5", "", vars); res.Status {
+		t.Error("expected an outsider to be unable to leave")
+	}
+}
@@ -0,0 +1,61 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gochatapp/pkg/db"
+	auth "gochatapp/pkg/middleware"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+)
+
+// mockDB points the handlers at a mocked PostgreSQL for the duration of a test
+func mockDB(t *testing.T) sqlmock.Sqlmock {
+	conn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal("error creating sql mock", err)
+	}
+	db.DB = conn
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		conn.Close()
+	})
+	return mock
+}
+
+// request builds a request to target as username, the way the router hands
+// it on once JwtMiddleware has let it through. An empty body sends none.
+func request(method, target, username, body string, vars map[string]string) *http.Request {
+	var r io.Reader = http.NoBody
+	if body != "" {
+		r = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, target, r)
+	req = req.WithContext(auth.WithClaims(req.Context(), &auth.Claims{Username: username}))
+	return mux.SetURLVars(req, vars)
+}
+
+// respond calls a handler with a request and decodes its response
+func respond(t *testing.T, handler http.HandlerFunc, req *http.Request) response {
+	w := httptest.NewRecorder()
+	handler(w, req)
+
+	var res response
+	if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+		t.Fatal("error decoding response", err)
+	}
+	return res
+}
+
+// serve calls a handler as username, without query parameters
+func serve(t *testing.T, handler http.HandlerFunc, method, username, body string, vars map[string]string) response {
+	return respond(t, handler, request(method, "/", username, body, vars))
+}
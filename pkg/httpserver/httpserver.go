@@ -2,9 +2,12 @@ package httpserver
 
 import (
 	"fmt"
+	"gochatapp/pkg/fanout"
 	auth "gochatapp/pkg/middleware"
+	"gochatapp/pkg/ratelimit"
 	"gochatapp/pkg/redisrepo"
 	"gochatapp/pkg/ws"
+	"log"
 	"net/http"
 
 	"github.com/gorilla/mux"
@@ -17,18 +20,34 @@ func StartHTTPServer() {
 	redisClient := redisrepo.InitialiseRedis()
 	defer redisClient.Close() // Ensure Redis connection is closed after the server shuts down
 
-	// Create necessary indexes for Redis (e.g., for chat history)
-	redisrepo.CreateFetchChatBetweenIndex()
+	// Share WebSocket frames between instances over Redis Pub/Sub, then start
+	// publishing and delivering them
+	ws.SetBus(fanout.NewRedisBus(redisClient, "chat:events"))
+	go ws.Broadcaster()
+
+	// Rate limits are kept in Redis so they hold across instances
+	limiter := ratelimit.NewRedisLimiter(redisClient)
+	ws.SetLimiter(limiter)
+
+	// Uploaded files go to the local disk or an S3-compatible bucket
+	store, err := openBlobStore()
+	if err != nil {
+		log.Fatal("Unable to open the attachment store: ", err)
+	}
+	attachmentStore = store
 
 	// Create a new router
 	r := mux.NewRouter()
 
 	// Server status route (for health check)
-	r.HandleFunc("/status", statusHandler).Methods(http.MethodGet)
+	r.HandleFunc("/status", statusHandler).Methods(http.MethodGet).Name("status")
 
 	// Authentication routes
-	r.HandleFunc("/register", registerHandler).Methods(http.MethodPost) // User registration route
-	r.HandleFunc("/login", loginHandler).Methods(http.MethodPost)       // User login route
+	r.HandleFunc("/register", registerHandler).Methods(http.MethodPost).Name("register") // User registration route
+	r.HandleFunc("/login", loginHandler).Methods(http.MethodPost).Name("login")          // User login route
+	r.HandleFunc("/refresh", refreshHandler).Methods(http.MethodPost).Name("refresh")    // Refresh token rotation
+	r.Handle("/logout", auth.JwtMiddleware(http.HandlerFunc(logoutHandler))).Methods(http.MethodPost)
+	r.Handle("/logout-all", auth.JwtMiddleware(http.HandlerFunc(logoutAllHandler))).Methods(http.MethodPost)
 
 	// Protected routes with JWT authentication middleware
 	r.Handle("/verify-contact", auth.JwtMiddleware(http.HandlerFunc(verifyContactHandler))).Methods(http.MethodPost)
@@ -41,8 +60,66 @@ func StartHTTPServer() {
 	r.Handle("/reject-follow-request", auth.JwtMiddleware(http.HandlerFunc(rejectFollowRequestHandler))).Methods(http.MethodPut)
 	r.Handle("/pending-follow-request", auth.JwtMiddleware(http.HandlerFunc(pendingFollowRequestsHandler))).Methods(http.MethodGet)
 
+	// Message routes, keyed by message ID
+	r.Handle("/messages/{id}", auth.JwtMiddleware(http.HandlerFunc(editMessageHandler))).Methods(http.MethodPut)
+	r.Handle("/messages/{id}", auth.JwtMiddleware(http.HandlerFunc(deleteMessageHandler))).Methods(http.MethodDelete)
+	r.Handle("/messages/{id}/replies", auth.JwtMiddleware(http.HandlerFunc(messageRepliesHandler))).Methods(http.MethodGet)
+	r.Handle("/messages/{id}/edits", auth.JwtMiddleware(http.HandlerFunc(messageEditsHandler))).Methods(http.MethodGet)
+
+	// Connected devices of the caller, which can be signed out remotely
+	r.Handle("/sessions", auth.JwtMiddleware(http.HandlerFunc(sessionsHandler))).Methods(http.MethodGet)
+	r.Handle("/sessions/{id}", auth.JwtMiddleware(http.HandlerFunc(revokeSessionHandler))).Methods(http.MethodDelete)
+
+	// Presence of users and whether the caller shares their last-seen time
+	r.Handle("/presence", auth.JwtMiddleware(http.HandlerFunc(presenceHandler))).Methods(http.MethodGet)
+	r.Handle("/presence/settings", auth.JwtMiddleware(http.HandlerFunc(presenceSettingsHandler))).Methods(http.MethodPut)
+
+	// Blocked users and who may message the caller
+	r.Handle("/blocks", auth.JwtMiddleware(http.HandlerFunc(blockListHandler))).Methods(http.MethodGet)
+	r.Handle("/blocks", auth.JwtMiddleware(http.HandlerFunc(blockUserHandler))).Methods(http.MethodPost)
+	r.Handle("/blocks/{username}", auth.JwtMiddleware(http.HandlerFunc(unblockUserHandler))).Methods(http.MethodDelete)
+	r.Handle("/privacy/settings", auth.JwtMiddleware(http.HandlerFunc(privacySettingsHandler))).Methods(http.MethodPut)
+
+	// Full-text search over the caller's conversations
+	r.Handle("/search", auth.JwtMiddleware(http.HandlerFunc(searchHandler))).Methods(http.MethodGet)
+
+	// Contacts, follow requests and conversations of the caller in one feed
+	r.Handle("/inbox", auth.JwtMiddleware(http.HandlerFunc(inboxHandler))).Methods(http.MethodGet)
+
+	// Attachments are uploaded first and then sent by ID in a chat. Downloads
+	// are authorized by the signed URL alone.
+	r.Handle("/attachments", auth.JwtMiddleware(http.HandlerFunc(uploadAttachmentHandler))).Methods(http.MethodPost).Name("upload")
+	r.Handle("/attachments/{id}/link", auth.JwtMiddleware(http.HandlerFunc(attachmentLinkHandler))).Methods(http.MethodGet)
+	r.HandleFunc("/attachments/{id}", downloadAttachmentHandler).Methods(http.MethodGet).Name("download")
+	r.HandleFunc("/attachments/{id}/thumbnail", downloadAttachmentHandler).Methods(http.MethodGet).Name("thumbnail")
+
+	// Conversation routes, keyed by conversation ID
+	r.Handle("/conversations", auth.JwtMiddleware(http.HandlerFunc(conversationListHandler))).Methods(http.MethodGet)
+	r.Handle("/conversations/{id}/history", auth.JwtMiddleware(http.HandlerFunc(conversationHistoryHandler))).Methods(http.MethodGet)
+	r.Handle("/conversations/{id}/unread", auth.JwtMiddleware(http.HandlerFunc(conversationUnreadHandler))).Methods(http.MethodGet)
+	r.Handle("/conversations/{id}/read", auth.JwtMiddleware(http.HandlerFunc(conversationReadHandler))).Methods(http.MethodPost)
+	r.Handle("/conversations/{id}/settings", auth.JwtMiddleware(http.HandlerFunc(conversationSettingsHandler))).Methods(http.MethodPut)
+
+	// Group conversation routes
+	r.Handle("/groups", auth.JwtMiddleware(http.HandlerFunc(createGroupHandler))).Methods(http.MethodPost)
+	r.Handle("/groups", auth.JwtMiddleware(http.HandlerFunc(listGroupsHandler))).Methods(http.MethodGet)
+	r.Handle("/groups/{id}", auth.JwtMiddleware(http.HandlerFunc(renameGroupHandler))).Methods(http.MethodPut)
+	r.Handle("/groups/{id}/history", auth.JwtMiddleware(http.HandlerFunc(groupHistoryHandler))).Methods(http.MethodGet)
+	r.Handle("/groups/{id}/members", auth.JwtMiddleware(http.HandlerFunc(groupMembersHandler))).Methods(http.MethodGet)
+	r.Handle("/groups/{id}/members", auth.JwtMiddleware(http.HandlerFunc(addGroupMemberHandler))).Methods(http.MethodPost)
+	r.Handle("/groups/{id}/members/{member}", auth.JwtMiddleware(http.HandlerFunc(updateGroupMemberHandler))).Methods(http.MethodPut)
+	r.Handle("/groups/{id}/members/{member}", auth.JwtMiddleware(http.HandlerFunc(removeGroupMemberHandler))).Methods(http.MethodDelete)
+	r.Handle("/groups/{id}/leave", auth.JwtMiddleware(http.HandlerFunc(leaveGroupHandler))).Methods(http.MethodPost)
+
+	// Admin routes, for the users listed in ADMIN_USERS
+	r.Handle("/admin/unlock", auth.JwtMiddleware(http.HandlerFunc(unlockLoginHandler))).Methods(http.MethodPost)
+	r.Handle("/admin/conversations/{id}/retention", auth.JwtMiddleware(http.HandlerFunc(chatRetentionHandler))).Methods(http.MethodPut)
+
 	// WebSocket route for real-time communication
-	r.Handle("/ws", (http.HandlerFunc(ws.ServeWs)))
+	r.Handle("/ws", (http.HandlerFunc(ws.ServeWs))).Name("ws")
+
+	// Throttle every route per client IP or per user, see routeLimits
+	r.Use(ratelimit.Middleware(limiter, routeRule))
 
 	// Start the server with CORS configuration (Allow all origins for simplicity, can be restricted as needed)
 	handler := cors.AllowAll().Handler(r)
@@ -0,0 +1,34 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	auth "gochatapp/pkg/middleware"
+)
+
+// currentUser returns the authenticated user of the request. Handlers act as
+// this user only: a username the client still sends must match it, so nobody
+// can read or act on behalf of someone else.
+func currentUser(w http.ResponseWriter, r *http.Request, claimed string) (string, bool) {
+	me := auth.Username(r.Context())
+	if me == "" {
+		http.Error(w, "Invalid or expired token", http.StatusUnauthorized)
+		return "", false
+	}
+
+	if claimed != "" && claimed != me {
+		jsonError(w, http.StatusForbidden, "You can only act as the authenticated user")
+		return "", false
+	}
+	return me, true
+}
+
+// decodeOptional decodes a JSON body that may be left out entirely
+func decodeOptional(r *http.Request, v interface{}) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package httpserver
+
+import (
+	"net/http"
+
+	"gochatapp/model"
+	"gochatapp/pkg/db"
+)
+
+// inboxHandler lists the caller's contacts, follow requests, direct
+// conversations and groups in one feed, most recent first. Pages are
+// addressed by the position of an entry, so they don't shift as activity
+// moves entries to the top.
+func inboxHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	username, ok := currentUser(w, r, q.Get("username"))
+	if !ok {
+		return
+	}
+
+	limit, err := parsePageLimit(q)
+	if err != nil {
+		jsonResponse(w, false, "Invalid pagination parameters: "+err.Error(), nil, 0)
+		return
+	}
+	// One more entry than asked for tells whether there's another page
+	rng := model.InboxRange{Limit: limit + 1}
+	if cursor := q.Get("cursor"); cursor != "" {
+		pos, before, err := decodeInboxCursor(cursor)
+		if err != nil {
+			jsonResponse(w, false, "Invalid pagination parameters: "+err.Error(), nil, 0)
+			return
+		}
+		if before {
+			rng.Before = &pos
+		} else {
+			rng.After = &pos
+		}
+	}
+
+	entries, total, err := db.FetchInbox(db.DB, username, rng)
+	if err != nil {
+		jsonResponse(w, false, "Unable to fetch inbox", nil, 0)
+		return
+	}
+
+	more := len(entries) > limit
+	if more && rng.Before != nil {
+		entries = entries[1:]
+	} else if more {
+		entries = entries[:limit]
+	}
+
+	var next, prev string
+	if len(entries) > 0 {
+		first, last := entries[0].Position(), entries[len(entries)-1].Position()
+		if rng.Before != nil || more {
+			next = encodeInboxCursor(last, false)
+		}
+		if rng.After != nil || (rng.Before != nil && more) {
+			prev = encodeInboxCursor(first, true)
+		}
+	}
+
+	pagedResponse(w, "Inbox fetched successfully", entries, total, next, prev)
+}
@@ -0,0 +1,99 @@
+package httpserver
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gochatapp/pkg/db"
+	"gochatapp/pkg/ratelimit"
+	"gochatapp/utils"
+)
+
+// lockoutPolicy decides how long a subject is locked after a failed login.
+// The first few failures are free, after that the wait doubles with every
+// failure until there are enough of them to lock the subject out.
+type lockoutPolicy struct {
+	free    int           // failures allowed without any wait
+	max     int           // failures that lock the subject out
+	lockout time.Duration // how long a lockout lasts
+}
+
+func (p lockoutPolicy) backoff(failures int) time.Duration {
+	if failures >= p.max {
+		return p.lockout
+	}
+	if failures <= p.free {
+		return 0
+	}
+	doublings := failures - p.free - 1
+	if doublings > 30 {
+		return p.lockout
+	}
+	wait := time.Second << doublings
+	if wait > p.lockout {
+		return p.lockout
+	}
+	return wait
+}
+
+// Lockouts per username and client IP, and per client IP alone, configurable
+// with the LOGIN_* variables. Counting a username's failures per IP means a
+// guesser can't lock its owner out from their own devices. An IP gets more
+// room since users can share one. A username's failures from every IP
+// together only slow its logins down, up to LOGIN_ACCOUNT_MAX_WAIT, so
+// guessing from rotating addresses is throttled without a lockout anyone
+// could trigger.
+var (
+	userLockout = lockoutPolicy{
+		free:    3,
+		max:     utils.IntFromEnv("LOGIN_MAX_FAILURES", 10),
+		lockout: utils.DurationFromEnv("LOGIN_LOCKOUT", 15*time.Minute),
+	}
+	accountBackoff = lockoutPolicy{
+		free:    utils.IntFromEnv("LOGIN_MAX_FAILURES", 10),
+		max:     math.MaxInt,
+		lockout: utils.DurationFromEnv("LOGIN_ACCOUNT_MAX_WAIT", time.Minute),
+	}
+	ipLockout = lockoutPolicy{
+		free:    10,
+		max:     utils.IntFromEnv("LOGIN_MAX_FAILURES_PER_IP", 50),
+		lockout: utils.DurationFromEnv("LOGIN_LOCKOUT", 15*time.Minute),
+	}
+	// loginFailureWindow is how long failures are remembered without another one
+	loginFailureWindow = utils.DurationFromEnv("LOGIN_FAILURE_WINDOW", time.Hour)
+)
+
+// loginLocked writes a 429 with Retry-After if logins for the username from
+// the client or from anywhere, or any from the client, are locked. The same
+// answer is given whether the user exists or not.
+func loginLocked(w http.ResponseWriter, username, ip string) bool {
+	remaining, err := db.LoginLockRemaining(db.DB, username, ip)
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, "Unable to log in, please try again")
+		return true
+	}
+	if remaining <= 0 {
+		return false
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(ratelimit.RetryAfterSeconds(remaining)))
+	jsonError(w, http.StatusTooManyRequests, "Too many failed login attempts, please try again later")
+	return true
+}
+
+// recordLoginFailure counts a failed login against the username from the
+// client IP, against the username from any client, and against the IP
+func recordLoginFailure(username, ip string) {
+	if err := db.RecordLoginFailure(db.DB, db.LoginScopeUser, username, ip, loginFailureWindow, userLockout.backoff); err != nil {
+		log.Println("Error recording failed login for", username, err)
+	}
+	if err := db.RecordLoginFailure(db.DB, db.LoginScopeUser, username, "", loginFailureWindow, accountBackoff.backoff); err != nil {
+		log.Println("Error recording failed login for", username, err)
+	}
+	if err := db.RecordLoginFailure(db.DB, db.LoginScopeIP, ip, "", loginFailureWindow, ipLockout.backoff); err != nil {
+		log.Println("Error recording failed login from", ip, err)
+	}
+}
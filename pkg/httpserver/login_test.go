@@ -0,0 +1,127 @@
+package httpserver
+
+import (
+	"math"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gochatapp/pkg/db"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestLockoutBackoff(t *testing.T) {
+	p := lockoutPolicy{free: 3, max: 10, lockout: 15 * time.Minute}
+
+	cases := map[int]time.Duration{
+		0:  0,
+		3:  0,
+		4:  time.Second,
+		5:  2 * time.Second,
+		9:  32 * time.Second,
+		10: 15 * time.Minute,
+		50: 15 * time.Minute,
+	}
+	for failures, want := range cases {
+		if got := p.backoff(failures); got != want {
+			t.Errorf("backoff(%d) = %s, want %s", failures, got, want)
+		}
+	}
+
+	// The doubling never waits longer than a lockout
+	short := lockoutPolicy{free: 0, max: 100, lockout: time.Minute}
+	if got := short.backoff(20); got != time.Minute {
+		t.Errorf("expected the wait to be capped at the lockout, got %s", got)
+	}
+}
+
+func TestAccountBackoffNeverLocksOut(t *testing.T) {
+	p := lockoutPolicy{free: 10, max: math.MaxInt, lockout: time.Minute}
+
+	cases := map[int]time.Duration{
+		10:   0,
+		11:   time.Second,
+		16:   32 * time.Second,
+		17:   time.Minute,
+		1000: time.Minute,
+	}
+	for failures, want := range cases {
+		if got := p.backoff(failures); got != want {
+			t.Errorf("backoff(%d) = %s, want %s", failures, got, want)
+		}
+	}
+}
+
+func TestLoginFailuresFromManyClientsSlowTheUsername(t *testing.T) {
+	conn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal("error creating sql mock", err)
+	}
+	db.DB = conn
+	t.Cleanup(func() { conn.Close() })
+
+	// A fresh address for every guess still adds up against the username
+	failures := accountBackoff.free + 1
+	mock.ExpectQuery("INSERT INTO login_failures").
+		WithArgs(db.LoginScopeUser, "user1", "192.0.2.99", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"failures"}).AddRow(1))
+	mock.ExpectQuery("INSERT INTO login_failures").
+		WithArgs(db.LoginScopeUser, "user1", "", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"failures"}).AddRow(failures))
+	mock.ExpectExec("UPDATE login_failures SET locked_until").
+		WithArgs(db.LoginScopeUser, "user1", "", time.Second.Seconds()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("INSERT INTO login_failures").
+		WithArgs(db.LoginScopeIP, "192.0.2.99", "", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"failures"}).AddRow(1))
+	recordLoginFailure("user1", "192.0.2.99")
+
+	mock.ExpectQuery("FROM login_failures").
+		WithArgs(db.LoginScopeUser, "user1", db.LoginScopeIP, "192.0.2.100").
+		WillReturnRows(sqlmock.NewRows([]string{"seconds"}).AddRow(1.0))
+	w := httptest.NewRecorder()
+	if !loginLocked(w, "user1", "192.0.2.100") {
+		t.Error("expected the next guess from a new address to wait")
+	}
+	if got := w.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("expected Retry-After 1, got %q", got)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLoginFailuresAreCountedPerClient(t *testing.T) {
+	conn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal("error creating sql mock", err)
+	}
+	db.DB = conn
+	t.Cleanup(func() { conn.Close() })
+
+	mock.ExpectQuery("INSERT INTO login_failures").
+		WithArgs(db.LoginScopeUser, "user1", "203.0.113.7", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"failures"}).AddRow(1))
+	mock.ExpectQuery("INSERT INTO login_failures").
+		WithArgs(db.LoginScopeUser, "user1", "", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"failures"}).AddRow(1))
+	mock.ExpectQuery("INSERT INTO login_failures").
+		WithArgs(db.LoginScopeIP, "203.0.113.7", "", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"failures"}).AddRow(1))
+	recordLoginFailure("user1", "203.0.113.7")
+
+	// The owner logging in from elsewhere checks their own address and the
+	// username's failures from every address
+	mock.ExpectQuery("FROM login_failures").
+		WithArgs(db.LoginScopeUser, "user1", db.LoginScopeIP, "198.51.100.1").
+		WillReturnRows(sqlmock.NewRows([]string{"seconds"}).AddRow(0.0))
+	if loginLocked(httptest.NewRecorder(), "user1", "198.51.100.1") {
+		t.Error("expected logins from another address to stay open")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
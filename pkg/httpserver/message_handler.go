@@ -0,0 +1,250 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"gochatapp/model"
+	"gochatapp/pkg/db"
+	"gochatapp/pkg/ws"
+
+	"github.com/gorilla/mux"
+)
+
+// attachQuotes embeds a preview of the quoted message in every reply. The
+// previews are read fresh, so edits and deletions of the quoted message show
+// even when the reply came from the Redis tier.
+func attachQuotes(chats []model.Chat) {
+	var ids []string
+	for _, c := range chats {
+		if c.ReplyTo != "" {
+			ids = append(ids, c.ReplyTo)
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+
+	quotes, err := db.FetchQuotes(ids)
+	if err != nil {
+		log.Println("Error fetching quotes:", err)
+		return
+	}
+	for i := range chats {
+		if q, ok := quotes[chats[i].ReplyTo]; ok {
+			chats[i].Quote = &q
+		} else {
+			chats[i].Quote = nil
+		}
+	}
+}
+
+// attachReactions embeds the reaction counts of every chat, as seen by viewer
+func attachReactions(chats []model.Chat, viewer string) {
+	if len(chats) == 0 {
+		return
+	}
+
+	ids := make([]string, len(chats))
+	for i, c := range chats {
+		ids[i] = c.ID
+	}
+
+	reactions, err := db.FetchReactions(ids, viewer)
+	if err != nil {
+		log.Println("Error fetching reactions:", err)
+		return
+	}
+	for i := range chats {
+		chats[i].Reactions = reactions[chats[i].ID]
+	}
+}
+
+// attachFiles embeds the attachments of every chat with fresh download URLs.
+// Chats deleted for everyone keep none.
+func attachFiles(chats []model.Chat) {
+	var ids []string
+	for _, c := range chats {
+		if !c.Deleted {
+			ids = append(ids, c.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+
+	attachments, err := db.FetchMessageAttachments(ids)
+	if err != nil {
+		log.Println("Error fetching attachments:", err)
+		return
+	}
+	for i := range chats {
+		chats[i].Attachments = nil
+		if !chats[i].Deleted {
+			chats[i].Attachments = attachments[chats[i].ID]
+			ws.SignAttachments(chats[i].Attachments)
+		}
+	}
+}
+
+type messageReq struct {
+	Username string `json:"username"`
+	Msg      string `json:"message"`
+	Scope    string `json:"scope"`
+}
+
+func editMessageHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	req := &messageReq{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		jsonResponse(w, false, "Invalid request payload", nil, 0)
+		return
+	}
+
+	username, ok := currentUser(w, r, req.Username)
+	if !ok {
+		return
+	}
+
+	chat, err := ws.EditChat(username, id, req.Msg)
+	switch err {
+	case nil:
+		jsonResponse(w, true, "Message edited", chat, 0)
+	case db.ErrChatNotFound, db.ErrNotConversationMember:
+		jsonResponse(w, false, "Message not found", nil, 0)
+	case db.ErrNotChatSender:
+		jsonResponse(w, false, "Only the sender can edit this message", nil, 0)
+	case ws.ErrEmptyMessage:
+		jsonResponse(w, false, "Message cannot be empty", nil, 0)
+	default:
+		jsonResponse(w, false, "Failed to edit message", nil, 0)
+	}
+}
+
+func deleteMessageHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	req := &messageReq{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		jsonResponse(w, false, "Invalid request payload", nil, 0)
+		return
+	}
+
+	username, ok := currentUser(w, r, req.Username)
+	if !ok {
+		return
+	}
+
+	_, err := ws.DeleteChat(username, id, req.Scope)
+	switch err {
+	case nil:
+		jsonResponse(w, true, "Message deleted", nil, 0)
+	case db.ErrChatNotFound, db.ErrNotConversationMember:
+		jsonResponse(w, false, "Message not found", nil, 0)
+	case db.ErrNotChatSender:
+		jsonResponse(w, false, "Only the sender can delete this message for everyone", nil, 0)
+	case db.ErrDeleteWindowPassed:
+		jsonResponse(w, false, "Message is too old to delete for everyone", nil, 0)
+	case ws.ErrInvalidDeleteScope:
+		jsonResponse(w, false, "Scope must be me or everyone", nil, 0)
+	default:
+		jsonResponse(w, false, "Failed to delete message", nil, 0)
+	}
+}
+
+// messageEditsHandler lists the prior revisions of a message to the members
+// of its conversation
+func messageEditsHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	username, ok := currentUser(w, r, r.URL.Query().Get("username"))
+	if !ok {
+		return
+	}
+
+	chat, err := db.FetchChat(id)
+	if err == db.ErrChatNotFound {
+		jsonResponse(w, false, "Message not found", nil, 0)
+		return
+	}
+	if err != nil {
+		jsonResponse(w, false, "Unable to fetch message", nil, 0)
+		return
+	}
+
+	if _, ok := conversation(w, chat.ConversationID, username); !ok {
+		return
+	}
+
+	edits, err := db.FetchChatEdits(id)
+	if err != nil {
+		jsonResponse(w, false, "Unable to fetch message edits", nil, 0)
+		return
+	}
+
+	jsonResponse(w, true, "Message edits fetched successfully", edits, len(edits))
+}
+
+// messageRepliesHandler returns the thread of replies to a message, oldest
+// first, paged forwards with the after cursor
+func messageRepliesHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	q := r.URL.Query()
+	username, ok := currentUser(w, r, q.Get("username"))
+	if !ok {
+		return
+	}
+
+	root, err := db.FetchChat(id)
+	if err == db.ErrChatNotFound {
+		jsonResponse(w, false, "Message not found", nil, 0)
+		return
+	}
+	if err != nil {
+		jsonResponse(w, false, "Unable to fetch message", nil, 0)
+		return
+	}
+
+	if _, ok := conversation(w, root.ConversationID, username); !ok {
+		return
+	}
+
+	rng := model.ChatRange{Ascending: true, Viewer: username}
+	if rng.Limit, err = parsePageLimit(q); err == nil && q.Get("after") != "" {
+		rng.AfterID, err = decodeCursor(q.Get("after"))
+	}
+	if err != nil {
+		jsonResponse(w, false, "Invalid pagination parameters: "+err.Error(), nil, 0)
+		return
+	}
+
+	// Ask for one extra reply to know whether there is another page
+	limit := rng.Limit
+	rng.Limit++
+
+	replies, err := db.FetchReplies(id, rng)
+	if err != nil {
+		jsonResponse(w, false, "Unable to fetch replies", nil, 0)
+		return
+	}
+
+	var next string
+	if len(replies) > limit {
+		replies = replies[:limit]
+		next = encodeCursor(chatID(replies[len(replies)-1]))
+	}
+	if replies == nil {
+		replies = []model.Chat{}
+	}
+	attachReactions(replies, username)
+	attachFiles(replies)
+	thread := []model.Chat{*root}
+	attachReactions(thread, username)
+	attachFiles(thread)
+
+	pagedResponse(w, "Replies fetched successfully", map[string]interface{}{
+		"message": thread[0],
+		"replies": replies,
+	}, len(replies), next, "")
+}
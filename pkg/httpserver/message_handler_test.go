@@ -0,0 +1,107 @@
+package httpserver
+
+import (
+	"net/http"
+	"testing"
+
+	"gochatapp/model"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+var chatColumns = []string{"id", "sender", "receiver", "content", "timestamp", "status",
+	"group_id", "conversation_id", "edited_at", "deleted", "reply_to", "link_preview"}
+
+// chatRow adds a chat of user1 to user2 in conversation 3 to rows
+func chatRow(rows *sqlmock.Rows, id, content, replyTo string) *sqlmock.Rows {
+	return rows.AddRow(id, "user1", "user2", content, 1700000000.0, "sent", "", "3", 0.0, false, replyTo, nil)
+}
+
+func TestAttachQuotesReadsThemFresh(t *testing.T) {
+	mock := mockDB(t)
+
+	// The quoted message of 8 was edited since, and that of 9 is gone
+	mock.ExpectQuery("SELECT id, sender, left\\(content, \\$2\\), deleted_at IS NOT NULL").
+		WithArgs(sqlmock.AnyArg(), model.QuoteLength).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "sender", "content", "deleted"}).
+			AddRow("5", "user2", "edited", false))
+
+	chats := []model.Chat{
+		{ID: "7"},
+		{ID: "8", ReplyTo: "5", Quote: &model.Quote{ID: "5", Msg: "original"}},
+		{ID: "9", ReplyTo: "6", Quote: &model.Quote{ID: "6", Msg: "removed"}},
+	}
+	attachQuotes(chats)
+
+	if chats[0].Quote != nil {
+		t.Errorf("expected no quote on a chat that isn't a reply, got %+v", chats[0].Quote)
+	}
+	if q := chats[1].Quote; q == nil || q.Msg != "edited" || q.From != "user2" {
+		t.Errorf("expected the edited quote, got %+v", q)
+	}
+	if chats[2].Quote != nil {
+		t.Errorf("expected the quote of a missing message to be dropped, got %+v", chats[2].Quote)
+	}
+}
+
+func TestMessageRepliesHandler(t *testing.T) {
+	mock := mockDB(t)
+	vars := map[string]string{"id": "5"}
+
+	mock.ExpectQuery("FROM messages WHERE id = \\$1").
+		WithArgs("5").
+		WillReturnRows(chatRow(sqlmock.NewRows(chatColumns), "5", "question", ""))
+	expectConversation(mock, "3", "user2", 0)
+	// One reply more than the page is asked for, to tell there's another page
+	mock.ExpectQuery("WHERE reply_to = \\$1").
+		WithArgs("5", int64(0), 3, "user2").
+		WillReturnRows(chatRow(chatRow(chatRow(sqlmock.NewRows(chatColumns), "6", "a", "5"), "8", "b", "5"), "9", "c", "5"))
+	mock.ExpectQuery("FROM message_reactions").WillReturnRows(sqlmock.NewRows(nil))
+	mock.ExpectQuery("FROM attachments").WillReturnRows(sqlmock.NewRows(nil))
+	mock.ExpectQuery("FROM message_reactions").WillReturnRows(sqlmock.NewRows(nil))
+	mock.ExpectQuery("FROM attachments").WillReturnRows(sqlmock.NewRows(nil))
+
+	res := respond(t, messageRepliesHandler, request(http.MethodGet, "/?limit=2", "user2", "", vars))
+	if !res.Status || res.Total != 2 || res.NextCursor != encodeCursor(8) {
+		t.Fatalf("expected a page of 2 replies continuing after 8, got %+v", res)
+	}
+	data, _ := res.Data.(map[string]interface{})
+	root, _ := data["message"].(map[string]interface{})
+	if root["id"] != "5" {
+		t.Errorf("expected the thread to start at message 5, got %+v", root)
+	}
+}
+
+func TestMessageRepliesHandlerNeedsMembership(t *testing.T) {
+	mock := mockDB(t)
+
+	mock.ExpectQuery("FROM messages WHERE id = \\$1").
+		WithArgs("5").
+		WillReturnRows(chatRow(sqlmock.NewRows(chatColumns), "5", "question", ""))
+	expectConversation(mock, "3", "user5", -1)
+
+	if res := serve(t, messageRepliesHandler, http.MethodGet, "user5", "", map[string]string{"id": "5"}); res.Status {
+		t.Error("expected an outsider to be unable to read the thread")
+	}
+}
+
+func TestAttachReactions(t *testing.T) {
+	mock := mockDB(t)
+
+	mock.ExpectQuery("FROM message_reactions").
+		WithArgs(sqlmock.AnyArg(), "user2").
+		WillReturnRows(sqlmock.NewRows([]string{"message_id", "emoji", "count", "reacted"}).
+			AddRow("7", "👍", 3, true).
+			AddRow("7", "🎉", 1, false))
+
+	chats := []model.Chat{{ID: "7"}, {ID: "8"}}
+	attachReactions(chats, "user2")
+
+	r := chats[0].Reactions
+	if len(r) != 2 || r[0] != (model.Reaction{Emoji: "👍", Count: 3, Reacted: true}) || r[1] != (model.Reaction{Emoji: "🎉", Count: 1}) {
+		t.Errorf("unexpected reactions on 7: %+v", r)
+	}
+	if len(chats[1].Reactions) != 0 {
+		t.Errorf("expected no reactions on 8, got %+v", chats[1].Reactions)
+	}
+}
@@ -0,0 +1,261 @@
+package httpserver
+
+import (
+	"encoding/base64"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"gochatapp/model"
+	"gochatapp/pkg/db"
+	"gochatapp/pkg/redisrepo"
+)
+
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+
+	cursorPrefix       = "m:"
+	offsetCursorPrefix = "o:"
+	inboxCursorPrefix  = "i:"
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// encodeCursor turns a message ID into an opaque page cursor
+func encodeCursor(id int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(cursorPrefix + strconv.FormatInt(id, 10)))
+}
+
+// decodeCursor returns the message ID a cursor points at
+func decodeCursor(cursor string) (int64, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || !strings.HasPrefix(string(raw), cursorPrefix) {
+		return 0, errInvalidCursor
+	}
+	id, err := strconv.ParseInt(strings.TrimPrefix(string(raw), cursorPrefix), 10, 64)
+	if err != nil || id <= 0 {
+		return 0, errInvalidCursor
+	}
+	return id, nil
+}
+
+// encodeOffsetCursor turns a result offset into an opaque page cursor, for
+// results that aren't ordered by message ID
+func encodeOffsetCursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(offsetCursorPrefix + strconv.Itoa(offset)))
+}
+
+// decodeOffsetCursor returns the result offset a cursor points at
+func decodeOffsetCursor(cursor string) (int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || !strings.HasPrefix(string(raw), offsetCursorPrefix) {
+		return 0, errInvalidCursor
+	}
+	offset, err := strconv.Atoi(strings.TrimPrefix(string(raw), offsetCursorPrefix))
+	if err != nil || offset < 0 {
+		return 0, errInvalidCursor
+	}
+	return offset, nil
+}
+
+// encodeInboxCursor turns an inbox position into an opaque page cursor for
+// the entries after it, or before it if before is set
+func encodeInboxCursor(p model.InboxPosition, before bool) string {
+	dir := "a"
+	if before {
+		dir = "b"
+	}
+	raw := inboxCursorPrefix + dir + ":" + strconv.FormatFloat(p.Activity, 'g', -1, 64) + ":" + p.Key
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// decodeInboxCursor returns the inbox position a cursor points at and whether
+// it asks for the entries before it
+func decodeInboxCursor(cursor string) (model.InboxPosition, bool, error) {
+	var p model.InboxPosition
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || !strings.HasPrefix(string(raw), inboxCursorPrefix) {
+		return p, false, errInvalidCursor
+	}
+	parts := strings.SplitN(strings.TrimPrefix(string(raw), inboxCursorPrefix), ":", 3)
+	if len(parts) != 3 || (parts[0] != "a" && parts[0] != "b") || parts[2] == "" {
+		return p, false, errInvalidCursor
+	}
+	if p.Activity, err = strconv.ParseFloat(parts[1], 64); err != nil {
+		return p, false, errInvalidCursor
+	}
+	p.Key = parts[2]
+	return p, parts[0] == "b", nil
+}
+
+// parsePageLimit reads the limit parameter, capped at maxPageLimit
+func parsePageLimit(q url.Values) (int, error) {
+	l := q.Get("limit")
+	if l == "" {
+		return defaultPageLimit, nil
+	}
+	limit, err := strconv.Atoi(l)
+	if err != nil || limit <= 0 {
+		return 0, errors.New("invalid limit")
+	}
+	if limit > maxPageLimit {
+		limit = maxPageLimit
+	}
+	return limit, nil
+}
+
+// parseChatRange reads the limit, before and after parameters of a history
+// request. Without a cursor the newest messages are returned.
+func parseChatRange(q url.Values) (model.ChatRange, error) {
+	var r model.ChatRange
+
+	limit, err := parsePageLimit(q)
+	if err != nil {
+		return r, err
+	}
+	r.Limit = limit
+
+	before, after := q.Get("before"), q.Get("after")
+	if before != "" && after != "" {
+		return r, errors.New("use either before or after")
+	}
+
+	if before != "" {
+		r.BeforeID, err = decodeCursor(before)
+	}
+	if after != "" {
+		r.AfterID, err = decodeCursor(after)
+		r.Ascending = true
+	}
+	return r, err
+}
+
+func chatID(c model.Chat) int64 {
+	id, _ := strconv.ParseInt(c.ID, 10, 64)
+	return id
+}
+
+// fetchChatPage reads a page of history from the Redis hot tier and the
+// PostgreSQL cold tier. Redis holds every chat of the conversation from its
+// floor onwards, so IDs at or above the floor are read from Redis and older
+// ones from PostgreSQL, which keeps the tiers from overlapping or leaving gaps.
+func fetchChatPage(conversationID string, r model.ChatRange, fromTS, toTS string) ([]model.Chat, error) {
+	// Time windows are only applied by PostgreSQL
+	if fromTS != "0" || toTS != "+inf" {
+		return db.FetchConversationPage(conversationID, r, fromTS, toTS)
+	}
+
+	floor, err := redisrepo.ConversationFloor(conversationID)
+	if err != nil || floor == 0 {
+		return db.FetchConversationPage(conversationID, r, fromTS, toTS)
+	}
+
+	if r.Ascending {
+		var chats []model.Chat
+		hot := r
+
+		// Start with the part of the range that is older than the floor
+		if r.AfterID < floor {
+			cold := r
+			if cold.BeforeID == 0 || cold.BeforeID > floor {
+				cold.BeforeID = floor
+			}
+			chats, err = db.FetchConversationPage(conversationID, cold, fromTS, toTS)
+			if err != nil {
+				return nil, err
+			}
+			if len(chats) >= r.Limit || (r.BeforeID != 0 && r.BeforeID <= floor) {
+				return chats, nil
+			}
+			hot.AfterID = floor - 1
+			hot.Limit = r.Limit - len(chats)
+		}
+
+		hotChats, err := redisrepo.FetchConversationPage(conversationID, hot)
+		if err != nil {
+			hotChats, err = db.FetchConversationPage(conversationID, hot, fromTS, toTS)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return append(chats, hotChats...), nil
+	}
+
+	// Newest first: read from Redis down to the floor, then continue in PostgreSQL
+	var chats []model.Chat
+	cold := r
+	if r.BeforeID == 0 || r.BeforeID > floor {
+		chats, err = redisrepo.FetchConversationPage(conversationID, r)
+		if err != nil {
+			log.Println("Error fetching chat page from Redis:", err)
+			return db.FetchConversationPage(conversationID, r, fromTS, toTS)
+		}
+		if len(chats) >= r.Limit {
+			return chats, nil
+		}
+		cold.BeforeID = floor
+		cold.Limit = r.Limit - len(chats)
+	}
+
+	coldChats, err := db.FetchConversationPage(conversationID, cold, fromTS, toTS)
+	if err != nil {
+		return nil, err
+	}
+	return append(chats, coldChats...), nil
+}
+
+// fetchConversationHistory writes a page of a conversation's history as seen
+// by viewer, newest first, with cursors for the older (next) and newer (prev) pages
+func fetchConversationHistory(w http.ResponseWriter, r *http.Request, conversationID, viewer, fromTS, toTS string) {
+	rng, err := parseChatRange(r.URL.Query())
+	if err != nil {
+		jsonResponse(w, false, "Invalid pagination parameters: "+err.Error(), nil, 0)
+		return
+	}
+	rng.Viewer = viewer
+
+	// Ask for one extra chat to know whether there is another page
+	limit := rng.Limit
+	rng.Limit++
+
+	chats, err := fetchChatPage(conversationID, rng, fromTS, toTS)
+	if err != nil {
+		log.Println("Error fetching chat history:", err)
+		jsonResponse(w, false, "Unable to fetch chat history", nil, 0)
+		return
+	}
+
+	hasMore := len(chats) > limit
+	if hasMore {
+		chats = chats[:limit]
+	}
+
+	if rng.Ascending {
+		for i, j := 0, len(chats)-1; i < j; i, j = i+1, j-1 {
+			chats[i], chats[j] = chats[j], chats[i]
+		}
+	}
+
+	var next, prev string
+	if len(chats) > 0 {
+		newest, oldest := chatID(chats[0]), chatID(chats[len(chats)-1])
+		if (rng.Ascending && rng.AfterID > 0) || (!rng.Ascending && hasMore) {
+			next = encodeCursor(oldest)
+		}
+		if (rng.Ascending && hasMore) || (!rng.Ascending && rng.BeforeID > 0) {
+			prev = encodeCursor(newest)
+		}
+	}
+
+	if chats == nil {
+		chats = []model.Chat{}
+	}
+	attachQuotes(chats)
+	attachReactions(chats, viewer)
+	attachFiles(chats)
+	pagedResponse(w, "Chat history fetched successfully", chats, len(chats), next, prev)
+}
@@ -0,0 +1,27 @@
+package httpserver
+
+import (
+	"testing"
+
+	"gochatapp/model"
+)
+
+func TestInboxCursor(t *testing.T) {
+	pos := model.InboxPosition{Activity: 1700000000.123456, Key: "ualice:smith"}
+
+	for _, before := range []bool{false, true} {
+		got, gotBefore, err := decodeInboxCursor(encodeInboxCursor(pos, before))
+		if err != nil {
+			t.Fatal("error decoding cursor", err)
+		}
+		if got != pos || gotBefore != before {
+			t.Errorf("expected %+v (before %v), got %+v (before %v)", pos, before, got, gotBefore)
+		}
+	}
+
+	for _, bad := range []string{"", "!!", encodeOffsetCursor(20), encodeCursor(5)} {
+		if _, _, err := decodeInboxCursor(bad); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"gochatapp/model"
+	"gochatapp/pkg/db"
+	"gochatapp/pkg/redisrepo"
+)
+
+type presenceSettingsReq struct {
+	Username     string `json:"username"`
+	HideLastSeen bool   `json:"hide_last_seen"`
+}
+
+// presenceHandler returns the presence of a comma separated list of users.
+// Last-seen times are left out for users who hide them, and users blocked
+// either way show as offline.
+func presenceHandler(w http.ResponseWriter, r *http.Request) {
+	username, ok := currentUser(w, r, r.URL.Query().Get("username"))
+	if !ok {
+		return
+	}
+
+	var users []string
+	for _, u := range strings.Split(r.URL.Query().Get("users"), ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			users = append(users, u)
+		}
+	}
+	if len(users) == 0 {
+		jsonResponse(w, false, "At least one user is required", nil, 0)
+		return
+	}
+	if len(users) > maxPageLimit {
+		jsonResponse(w, false, "Too many users requested", nil, 0)
+		return
+	}
+
+	presence, err := redisrepo.FetchPresence(users...)
+	if err != nil {
+		jsonResponse(w, false, "Unable to fetch presence", nil, 0)
+		return
+	}
+
+	hidden, err := db.FetchHiddenLastSeen(db.DB, users)
+	if err != nil {
+		jsonResponse(w, false, "Unable to fetch presence", nil, 0)
+		return
+	}
+	blocked, err := db.FetchBlockedBetween(db.DB, username, users)
+	if err != nil {
+		jsonResponse(w, false, "Unable to fetch presence", nil, 0)
+		return
+	}
+	for i := range presence {
+		if hidden[presence[i].Username] && presence[i].Username != username {
+			presence[i].LastSeen = 0
+		}
+		// Users blocked either way always appear offline to each other
+		if blocked[presence[i].Username] {
+			presence[i].Status, presence[i].LastSeen = model.PresenceOffline, 0
+		}
+	}
+
+	jsonResponse(w, true, "Presence fetched successfully", presence, len(presence))
+}
+
+func presenceSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	req := &presenceSettingsReq{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		jsonResponse(w, false, "Invalid request payload", nil, 0)
+		return
+	}
+
+	username, ok := currentUser(w, r, req.Username)
+	if !ok {
+		return
+	}
+
+	if err := db.SetHideLastSeen(db.DB, username, req.HideLastSeen); err != nil {
+		jsonResponse(w, false, "Failed to update presence settings", nil, 0)
+		return
+	}
+
+	jsonResponse(w, true, "Presence settings updated", nil, 0)
+}
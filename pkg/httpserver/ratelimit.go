@@ -0,0 +1,59 @@
+package httpserver
+
+import (
+	"net/http"
+	"time"
+
+	auth "gochatapp/pkg/middleware"
+	"gochatapp/pkg/ratelimit"
+	"gochatapp/utils"
+
+	"github.com/gorilla/mux"
+)
+
+// routeLimits are the limits of named routes, overridable with
+// RATE_LIMIT_<NAME>, e.g. RATE_LIMIT_LOGIN=10/1m. Routes without a name share
+// the "api" limit; the health check isn't limited.
+var routeLimits = map[string]ratelimit.Limit{
+	"register":  ratelimit.FromEnv("register", ratelimit.Limit{Burst: 5, Per: 10 * time.Minute}),
+	"login":     ratelimit.FromEnv("login", ratelimit.Limit{Burst: 10, Per: time.Minute}),
+	"refresh":   ratelimit.FromEnv("refresh", ratelimit.Limit{Burst: 30, Per: time.Minute}),
+	"ws":        ratelimit.FromEnv("ws", ratelimit.Limit{Burst: 20, Per: time.Minute}),
+	"upload":    ratelimit.FromEnv("upload", ratelimit.Limit{Burst: 20, Per: time.Minute}),
+	"download":  ratelimit.FromEnv("download", ratelimit.Limit{Burst: 300, Per: time.Minute}),
+	"thumbnail": ratelimit.FromEnv("thumbnail", ratelimit.Limit{Burst: 300, Per: time.Minute}),
+	"api":       ratelimit.FromEnv("api", ratelimit.Limit{Burst: 120, Per: time.Minute}),
+}
+
+// routeRule limits anonymous routes per client IP and everything else per
+// user, so users behind one NAT don't share a bucket
+func routeRule(r *http.Request) (string, ratelimit.Limit, bool) {
+	name := "api"
+	if route := mux.CurrentRoute(r); route != nil && route.GetName() != "" {
+		name = route.GetName()
+	}
+	limit, ok := routeLimits[name]
+	if !ok {
+		return "", ratelimit.Limit{}, false
+	}
+
+	switch name {
+	case "register", "login", "refresh", "ws":
+		return name + ":ip:" + ratelimit.ClientIP(r), limit, true
+	}
+	return name + ":" + caller(r), limit, true
+}
+
+// caller identifies who a request counts against. The middleware runs before
+// JwtMiddleware, so the token is only checked for its signature here; a
+// forged token falls back to the client IP.
+func caller(r *http.Request) string {
+	if token, err := auth.BearerToken(r); err == nil {
+		if claims, err := utils.ValidateJWT(token); err == nil {
+			if username, _ := claims["username"].(string); username != "" {
+				return "user:" + username
+			}
+		}
+	}
+	return "ip:" + ratelimit.ClientIP(r)
+}
@@ -0,0 +1,92 @@
+package httpserver
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gochatapp/model"
+	"gochatapp/pkg/db"
+)
+
+// searchHandler runs a full-text search over the caller's conversations.
+// Results are ranked, so pages are addressed by offset rather than message ID.
+func searchHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	username, ok := currentUser(w, r, q.Get("username"))
+	if !ok {
+		return
+	}
+
+	f := model.SearchFilter{
+		Query:   strings.TrimSpace(q.Get("q")),
+		Contact: q.Get("contact"),
+		Sender:  q.Get("sender"),
+		FromTS:  q.Get("from-ts"),
+		ToTS:    q.Get("to-ts"),
+	}
+	if f.FromTS == "" {
+		f.FromTS = "0"
+	}
+	if f.ToTS == "" {
+		f.ToTS = "+inf"
+	}
+
+	if f.Query == "" {
+		jsonResponse(w, false, "Search query is required", nil, 0)
+		return
+	}
+
+	if !db.IsUserExist(db.DB, username) {
+		jsonResponse(w, false, "Invalid username", nil, 0)
+		return
+	}
+
+	limit, err := parsePageLimit(q)
+	if err != nil {
+		jsonResponse(w, false, "Invalid pagination parameters: "+err.Error(), nil, 0)
+		return
+	}
+	if cursor := q.Get("cursor"); cursor != "" {
+		if f.Offset, err = decodeOffsetCursor(cursor); err != nil {
+			jsonResponse(w, false, "Invalid pagination parameters: "+err.Error(), nil, 0)
+			return
+		}
+	}
+
+	// Ask for one extra hit to know whether there is another page
+	f.Limit = limit + 1
+
+	hits, err := db.SearchMessages(db.DB, username, f)
+	if err != nil {
+		jsonResponse(w, false, "Unable to search messages", nil, 0)
+		return
+	}
+
+	var next, prev string
+	if len(hits) > limit {
+		hits = hits[:limit]
+		next = encodeOffsetCursor(f.Offset + limit)
+	}
+	if f.Offset > 0 {
+		prevOffset := f.Offset - limit
+		if prevOffset < 0 {
+			prevOffset = 0
+		}
+		prev = encodeOffsetCursor(prevOffset)
+	}
+
+	for i := range hits {
+		// A before cursor just past the match opens the history on it, with
+		// older messages below and newer ones a prev page away
+		id, _ := strconv.ParseInt(hits[i].Chat.ID, 10, 64)
+		hits[i].HistoryURL = fmt.Sprintf("/conversations/%s/history?before=%s",
+			hits[i].Chat.ConversationID, encodeCursor(id+1))
+	}
+
+	if hits == nil {
+		hits = []model.SearchHit{}
+	}
+	pagedResponse(w, "Search results fetched successfully", hits, len(hits), next, prev)
+}
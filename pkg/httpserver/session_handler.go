@@ -0,0 +1,70 @@
+package httpserver
+
+import (
+	"net/http"
+
+	"gochatapp/pkg/redisrepo"
+	"gochatapp/pkg/ws"
+
+	"github.com/gorilla/mux"
+)
+
+// sessionsHandler lists the devices a user is connected from
+func sessionsHandler(w http.ResponseWriter, r *http.Request) {
+	username, ok := currentUser(w, r, r.URL.Query().Get("username"))
+	if !ok {
+		return
+	}
+
+	sessions, err := redisrepo.FetchSessions(username)
+	if err != nil {
+		jsonResponse(w, false, "Unable to fetch sessions", nil, 0)
+		return
+	}
+	for i := range sessions {
+		sessions[i].TokenID, sessions[i].TokenExpiresAt = "", 0
+	}
+
+	jsonResponse(w, true, "Sessions fetched successfully", sessions, len(sessions))
+}
+
+// revokeSessionHandler signs one of the user's devices out remotely
+func revokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	sessionID := mux.Vars(r)["id"]
+
+	u := &userReq{}
+	if err := decodeOptional(r, u); err != nil {
+		jsonResponse(w, false, "Invalid request payload", nil, 0)
+		return
+	}
+
+	username, ok := currentUser(w, r, u.Username)
+	if !ok {
+		return
+	}
+
+	sessions, err := redisrepo.FetchSessions(username)
+	if err != nil {
+		jsonResponse(w, false, "Unable to fetch sessions", nil, 0)
+		return
+	}
+
+	found := false
+	for _, s := range sessions {
+		if s.ID == sessionID {
+			found = true
+			break
+		}
+	}
+	if !found {
+		jsonResponse(w, false, "Session not found", nil, 0)
+		return
+	}
+
+	if err := ws.RevokeSession(username, sessionID); err != nil {
+		jsonResponse(w, false, "Failed to revoke session", nil, 0)
+		return
+	}
+
+	jsonResponse(w, true, "Session revoked", nil, 0)
+}
@@ -0,0 +1,310 @@
+package linkpreview
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+
+	"gochatapp/model"
+	"gochatapp/utils"
+
+	"golang.org/x/net/html"
+)
+
+var (
+	// ErrNotAllowed is returned for links the fetcher refuses to follow: other
+	// schemes, denied domains, or hosts on private networks
+	ErrNotAllowed = errors.New("link not allowed")
+	// ErrNoPreview is returned for pages without metadata worth showing
+	ErrNoPreview = errors.New("no preview available")
+)
+
+// Lengths previews are cut to
+const (
+	maxTitle       = 300
+	maxDescription = 500
+	maxRedirects   = 5
+)
+
+// Config bounds what a Fetcher fetches
+type Config struct {
+	Timeout  time.Duration // for a whole fetch, redirects included
+	MaxBytes int64         // read from a page; metadata sits in its head
+	// Allow limits previews to these domains and their subdomains; empty
+	// allows any domain that isn't denied
+	Allow []string
+	Deny  []string
+}
+
+// ConfigFromEnv reads LINK_PREVIEW_TIMEOUT, LINK_PREVIEW_MAX_BYTES and the
+// comma separated domain lists LINK_PREVIEW_ALLOW and LINK_PREVIEW_DENY
+func ConfigFromEnv() Config {
+	return Config{
+		Timeout:  utils.DurationFromEnv("LINK_PREVIEW_TIMEOUT", 5*time.Second),
+		MaxBytes: int64(utils.IntFromEnv("LINK_PREVIEW_MAX_BYTES", 512<<10)),
+		Allow:    splitList(os.Getenv("LINK_PREVIEW_ALLOW")),
+		Deny:     splitList(os.Getenv("LINK_PREVIEW_DENY")),
+	}
+}
+
+func splitList(v string) []string {
+	var list []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.ToLower(strings.TrimSpace(item)); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// Fetcher reads the OpenGraph and HTML metadata of pages. It only connects
+// to public addresses, checked on the resolved IP of every connection so
+// DNS tricks and redirects can't reach the internal network.
+type Fetcher struct {
+	cfg    Config
+	client *http.Client
+	// blocked reports addresses that may not be dialed
+	blocked func(netip.Addr) bool
+}
+
+// New creates a Fetcher bounded by cfg
+func New(cfg Config) *Fetcher {
+	f := &Fetcher{cfg: cfg, blocked: isInternal}
+
+	dialer := &net.Dialer{
+		Timeout: cfg.Timeout,
+		Control: f.checkDial,
+	}
+	f.client = &http.Client{
+		Timeout: cfg.Timeout,
+		Transport: &http.Transport{
+			// No proxy, it would dial on our behalf past the address check
+			Proxy:                 nil,
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   cfg.Timeout,
+			ResponseHeaderTimeout: cfg.Timeout,
+			MaxIdleConns:          10,
+			IdleConnTimeout:       30 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return errors.New("too many redirects")
+			}
+			return f.checkURL(req.URL)
+		},
+	}
+	return f
+}
+
+// checkDial runs right before a connection is made, on the resolved address
+func (f *Fetcher) checkDial(network, address string, _ syscall.RawConn) error {
+	ap, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if f.blocked(ap.Addr().Unmap()) {
+		return fmt.Errorf("%w: %s is not a public address", ErrNotAllowed, ap.Addr())
+	}
+	return nil
+}
+
+// nonPublic are the ranges the standard library doesn't already classify
+var nonPublic = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"), // carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"), // benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"), // NAT64 can reach any IPv4 address
+}
+
+// isInternal reports addresses of loopback, private, link-local and other
+// non-public networks
+func isInternal(addr netip.Addr) bool {
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() || addr.IsLoopback() || addr.IsLinkLocalUnicast() {
+		return true
+	}
+	for _, p := range nonPublic {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkURL accepts http and https links to domains the config allows
+func (f *Fetcher) checkURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return ErrNotAllowed
+	}
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if host == "" || u.User != nil {
+		return ErrNotAllowed
+	}
+	if matchDomain(host, f.cfg.Deny) {
+		return ErrNotAllowed
+	}
+	if len(f.cfg.Allow) > 0 && !matchDomain(host, f.cfg.Allow) {
+		return ErrNotAllowed
+	}
+	return nil
+}
+
+// matchDomain reports whether host is one of domains or a subdomain of one
+func matchDomain(host string, domains []string) bool {
+	for _, d := range domains {
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+// urlPattern finds links in message text. Trailing punctuation is trimmed
+// separately, it's more likely to end the sentence than the link.
+var urlPattern = regexp.MustCompile(`(?i)\bhttps?://[^\s<>"']+`)
+
+// FindURL returns the first http or https link in text, or ""
+func FindURL(text string) string {
+	link := urlPattern.FindString(text)
+	return strings.TrimRight(link, ".,;:!?)]}")
+}
+
+// Fetch reads the preview of the page at link
+func (f *Fetcher) Fetch(ctx context.Context, link string) (*model.LinkPreview, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return nil, ErrNotAllowed
+	}
+	if err := f.checkURL(u); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, f.cfg.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+	req.Header.Set("User-Agent", "gochatapp-linkpreview/1.0")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		if errors.Is(err, ErrNotAllowed) {
+			return nil, ErrNotAllowed
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, ErrNoPreview
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return nil, ErrNoPreview
+	}
+
+	p := parse(io.LimitReader(resp.Body, f.cfg.MaxBytes), resp.Request.URL)
+	if p.Title == "" && p.Description == "" {
+		return nil, ErrNoPreview
+	}
+	p.URL = link
+	return p, nil
+}
+
+// parse reads the metadata in the head of a page, preferring OpenGraph
+// properties over Twitter cards over plain HTML. Relative image links are
+// resolved against base, the URL the page was served from.
+func parse(r io.Reader, base *url.URL) *model.LinkPreview {
+	meta := make(map[string]string)
+	var title string
+
+	z := html.NewTokenizer(r)
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break // EOF or the size cap
+		}
+		name, hasAttr := z.TagName()
+		tag := string(name)
+
+		if (tt == html.EndTagToken && tag == "head") || (tt == html.StartTagToken && tag == "body") {
+			break
+		}
+		if tt == html.StartTagToken && tag == "title" && title == "" {
+			if z.Next() == html.TextToken {
+				title = string(z.Text())
+			}
+			continue
+		}
+		if (tt == html.StartTagToken || tt == html.SelfClosingTagToken) && tag == "meta" && hasAttr {
+			var key, content string
+			for {
+				k, v, more := z.TagAttr()
+				switch string(k) {
+				case "property", "name":
+					if key == "" {
+						key = strings.ToLower(string(v))
+					}
+				case "content":
+					content = string(v)
+				}
+				if !more {
+					break
+				}
+			}
+			if _, seen := meta[key]; key != "" && !seen {
+				meta[key] = content
+			}
+		}
+	}
+
+	first := func(values ...string) string {
+		for _, v := range values {
+			if v = strings.Join(strings.Fields(v), " "); v != "" {
+				return v
+			}
+		}
+		return ""
+	}
+
+	return &model.LinkPreview{
+		Title:       truncate(first(meta["og:title"], meta["twitter:title"], title), maxTitle),
+		Description: truncate(first(meta["og:description"], meta["twitter:description"], meta["description"]), maxDescription),
+		Image:       resolveImage(base, first(meta["og:image"], meta["og:image:url"], meta["twitter:image"])),
+		SiteName:    truncate(first(meta["og:site_name"]), maxTitle),
+	}
+}
+
+// resolveImage makes an image link absolute, dropping anything but http(s)
+func resolveImage(base *url.URL, ref string) string {
+	if ref == "" {
+		return ""
+	}
+	u, err := base.Parse(ref)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return ""
+	}
+	return u.String()
+}
+
+func truncate(s string, n int) string {
+	if runes := []rune(s); len(runes) > n {
+		return string(runes[:n])
+	}
+	return s
+}
@@ -0,0 +1,169 @@
+package linkpreview
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+const page = `<!doctype html>
+<html><head>
+<title>Plain title</title>
+<meta name="description" content="Plain description">
+<meta property="og:title" content="Go &amp; chat">
+<meta property="og:description" content="  An   article
+	about chats ">
+<meta property="og:image" content="/img/cover.png">
+<meta property="og:site_name" content="Example">
+</head><body><meta property="og:title" content="Not from the body"></body></html>`
+
+// newTestFetcher returns a fetcher that may reach the loopback test server
+func newTestFetcher(cfg Config) *Fetcher {
+	f := New(cfg)
+	f.blocked = func(netip.Addr) bool { return false }
+	return f
+}
+
+func testConfig() Config {
+	return Config{Timeout: 2 * time.Second, MaxBytes: 64 << 10}
+}
+
+func newServer(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/article", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, page)
+	})
+	mux.HandleFunc("/plain", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<html><head><title>Only a title</title></head></html>`)
+	})
+	mux.HandleFunc("/huge", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, "<html><head>"+strings.Repeat("<!-- padding -->", 10000)+`<title>Too far</title></head>`)
+	})
+	mux.HandleFunc("/image.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("\x89PNG"))
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(500 * time.Millisecond)
+	})
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, r.URL.Query().Get("to"), http.StatusFound)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestFetch(t *testing.T) {
+	srv := newServer(t)
+	f := newTestFetcher(testConfig())
+
+	p, err := f.Fetch(context.Background(), srv.URL+"/article")
+	if err != nil {
+		t.Fatal("error fetching preview", err)
+	}
+	if p.URL != srv.URL+"/article" || p.Title != "Go & chat" || p.Description != "An article about chats" ||
+		p.Image != srv.URL+"/img/cover.png" || p.SiteName != "Example" {
+		t.Errorf("unexpected preview %+v", p)
+	}
+
+	// Without OpenGraph tags the HTML title is used
+	p, err = f.Fetch(context.Background(), srv.URL+"/plain")
+	if err != nil || p.Title != "Only a title" {
+		t.Errorf("expected the HTML title, got %+v, %v", p, err)
+	}
+
+	// Only HTML pages have a preview
+	if _, err := f.Fetch(context.Background(), srv.URL+"/image.png"); err != ErrNoPreview {
+		t.Errorf("expected ErrNoPreview for an image, got %v", err)
+	}
+}
+
+func TestFetchLimits(t *testing.T) {
+	srv := newServer(t)
+
+	f := newTestFetcher(Config{Timeout: 2 * time.Second, MaxBytes: 4 << 10})
+	if _, err := f.Fetch(context.Background(), srv.URL+"/huge"); err != ErrNoPreview {
+		t.Errorf("expected metadata past the size cap to be ignored, got %v", err)
+	}
+
+	f = newTestFetcher(Config{Timeout: 100 * time.Millisecond, MaxBytes: 4 << 10})
+	if _, err := f.Fetch(context.Background(), srv.URL+"/slow"); err == nil {
+		t.Error("expected a slow page to time out")
+	}
+}
+
+func TestFetchRefusesInternalAddresses(t *testing.T) {
+	srv := newServer(t)
+	f := New(testConfig())
+
+	if _, err := f.Fetch(context.Background(), srv.URL+"/article"); !errors.Is(err, ErrNotAllowed) {
+		t.Errorf("expected the loopback server to be refused, got %v", err)
+	}
+
+	// Nor through a redirect from a server that may be reached
+	f = New(testConfig())
+	f.blocked = func(addr netip.Addr) bool { return addr != netip.MustParseAddr("127.0.0.1") }
+	target := strings.Replace(srv.URL, "127.0.0.1", "127.0.0.2", 1) + "/article"
+	if _, err := f.Fetch(context.Background(), srv.URL+"/redirect?to="+target); !errors.Is(err, ErrNotAllowed) {
+		t.Errorf("expected the redirect to be refused, got %v", err)
+	}
+
+	for _, addr := range []string{"127.0.0.1", "10.1.2.3", "192.168.0.1", "172.16.5.4", "169.254.169.254",
+		"100.64.0.1", "0.0.0.0", "::1", "fd00::1", "fe80::1", "::ffff:127.0.0.1"} {
+		if !isInternal(netip.MustParseAddr(addr).Unmap()) {
+			t.Errorf("expected %s to be internal", addr)
+		}
+	}
+	for _, addr := range []string{"93.184.216.34", "2606:2800:220:1::248"} {
+		if isInternal(netip.MustParseAddr(addr)) {
+			t.Errorf("expected %s to be public", addr)
+		}
+	}
+}
+
+func TestDomainLists(t *testing.T) {
+	f := New(Config{Timeout: time.Second, Allow: []string{"example.com"}, Deny: []string{"bad.example.com"}})
+
+	for link, allowed := range map[string]bool{
+		"https://example.com/a":         true,
+		"https://www.example.com/a":     true,
+		"https://bad.example.com/a":     false,
+		"https://x.bad.example.com/a":   false,
+		"https://notexample.com/a":      false,
+		"ftp://example.com/a":           false,
+		"https://user:pw@example.com/a": false,
+	} {
+		u, err := url.Parse(link)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := f.checkURL(u) == nil; got != allowed {
+			t.Errorf("%s: expected allowed=%v", link, allowed)
+		}
+	}
+}
+
+func TestFindURL(t *testing.T) {
+	cases := map[string]string{
+		"see https://example.com/a?b=c.":          "https://example.com/a?b=c",
+		"(http://example.com/x) and https://b.io": "http://example.com/x",
+		"no links here":                           "",
+		"javascript:alert(1)":                     "",
+	}
+	for text, want := range cases {
+		if got := FindURL(text); got != want {
+			t.Errorf("FindURL(%q) = %q, want %q", text, got, want)
+		}
+	}
+}
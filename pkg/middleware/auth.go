@@ -1,17 +1,100 @@
 package auth
 
 import (
-	"fmt"
+	"context"
+	"errors"
 	"log"
+	"math"
 	"net/http"
-	"os"
 	"strings"
+	"time"
 
-	"github.com/dgrijalva/jwt-go"
+	"gochatapp/pkg/redisrepo"
+	"gochatapp/utils"
 )
 
-// Get the secret key from environment variables
-var secretKey = []byte(os.Getenv("SECRET_KEY"))
+var errRevokedToken = errors.New("token has been revoked")
+
+// Claims are the verified claims of the access token a request carries
+type Claims struct {
+	Username  string
+	TokenID   string // jti
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+type contextKey struct{}
+
+// WithClaims returns a copy of ctx carrying the claims
+func WithClaims(ctx context.Context, c *Claims) context.Context {
+	return context.WithValue(ctx, contextKey{}, c)
+}
+
+// ClaimsFromContext returns the claims JwtMiddleware put in the request context
+func ClaimsFromContext(ctx context.Context) (*Claims, bool) {
+	c, ok := ctx.Value(contextKey{}).(*Claims)
+	return c, ok
+}
+
+// Username returns the authenticated username of a request context, or "" if
+// the request didn't go through JwtMiddleware
+func Username(ctx context.Context) string {
+	if c, ok := ClaimsFromContext(ctx); ok {
+		return c.Username
+	}
+	return ""
+}
+
+// BearerToken extracts the token from an "Authorization: Bearer <token>" header
+func BearerToken(r *http.Request) (string, error) {
+	// Get the "Authorization" header
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		return "", errors.New("missing Authorization Header")
+	}
+
+	// Extract token from "Bearer <token>" format
+	const bearerPrefix = "Bearer "
+	if !strings.HasPrefix(authHeader, bearerPrefix) {
+		return "", errors.New("invalid Authorization Format")
+	}
+
+	return strings.TrimPrefix(authHeader, bearerPrefix), nil
+}
+
+// ValidateToken checks the signature and expiry of an access token and that
+// it hasn't been revoked through the jti denylist
+func ValidateToken(tokenString string) (*Claims, error) {
+	mc, err := utils.ValidateJWT(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	jti, _ := mc["jti"].(string)
+	username, _ := mc["username"].(string)
+	iat, _ := mc["iat"].(float64)
+	exp, _ := mc["exp"].(float64)
+	if jti == "" || username == "" {
+		return nil, errors.New("token is missing its jti or username claim")
+	}
+
+	issuedAt := time.UnixMilli(int64(math.Round(iat * 1000)))
+	revoked, err := redisrepo.IsTokenRevoked(jti, username, issuedAt)
+	if err != nil {
+		// Fail closed, a revoked token must never get through
+		return nil, err
+	}
+	if revoked {
+		return nil, errRevokedToken
+	}
+
+	return &Claims{
+		Username:  username,
+		TokenID:   jti,
+		IssuedAt:  issuedAt,
+		ExpiresAt: time.Unix(int64(exp), 0),
+	}, nil
+}
 
 // JwtMiddleware validates the JWT token from the request
 func JwtMiddleware(next http.Handler) http.Handler {
@@ -19,41 +102,22 @@ func JwtMiddleware(next http.Handler) http.Handler {
 		// Log the incoming request
 		log.Printf("Incoming request: %s %s", r.Method, r.URL.Path)
 
-		// Get the "Authorization" header
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			log.Println("Missing Authorization Header")
-			http.Error(w, "Missing Authorization Header", http.StatusUnauthorized)
+		tokenString, err := BearerToken(r)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
 			return
 		}
 
-		// Extract token from "Bearer <token>" format
-		const bearerPrefix = "Bearer "
-		if !strings.HasPrefix(authHeader, bearerPrefix) {
-			log.Println("Invalid Authorization Format")
-			http.Error(w, "Invalid Authorization Format", http.StatusUnauthorized)
-			return
-		}
-
-		tokenString := strings.TrimPrefix(authHeader, bearerPrefix)
-
 		// Parse and validate the JWT token
-		parsedToken, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			// Ensure the signing method is HMAC
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				log.Printf("Unexpected signing method: %v", token.Method)
-				return nil, fmt.Errorf("unexpected signing method")
-			}
-			return secretKey, nil
-		})
-
-		if err != nil || !parsedToken.Valid {
+		claims, err := ValidateToken(tokenString)
+		if err != nil {
 			log.Printf("Invalid or expired token: %v", err)
 			http.Error(w, "Invalid or expired token", http.StatusUnauthorized)
 			return
 		}
 
-		// If token is valid, pass the request to the next handler
-		next.ServeHTTP(w, r)
+		// If token is valid, pass the request on with its claims
+		next.ServeHTTP(w, r.WithContext(WithClaims(r.Context(), claims)))
 	})
-}
\ No newline at end of file
+}
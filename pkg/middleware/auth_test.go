@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gochatapp/pkg/redisrepo"
+	"gochatapp/utils"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+func setupRedis(t *testing.T) *miniredis.Miniredis {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	redisrepo.UseClient(client)
+	t.Cleanup(func() { client.Close() })
+	utils.SecretKey = []byte("test-secret")
+	return mr
+}
+
+func TestValidateTokenChecksDenylist(t *testing.T) {
+	setupRedis(t)
+	token, _ := utils.CreateJWT("user1", "jti-1")
+	other, _ := utils.CreateJWT("user1", "jti-2")
+
+	claims, err := ValidateToken(token)
+	if err != nil {
+		t.Fatal("expected a fresh token to be valid", err)
+	}
+	if claims.Username != "user1" || claims.TokenID != "jti-1" {
+		t.Errorf("unexpected claims %+v", claims)
+	}
+
+	redisrepo.DenyToken("jti-1", claims.ExpiresAt)
+	if _, err := ValidateToken(token); err != errRevokedToken {
+		t.Errorf("expected a denied token to be revoked, got %v", err)
+	}
+	if _, err := ValidateToken(other); err != nil {
+		t.Errorf("expected other tokens to stay valid, got %v", err)
+	}
+}
+
+func TestValidateTokenChecksCutoff(t *testing.T) {
+	setupRedis(t)
+	token, _ := utils.CreateJWT("user1", "jti-1")
+	other, _ := utils.CreateJWT("user2", "jti-2")
+
+	// Signing a user out everywhere revokes every token issued so far
+	redisrepo.RevokeTokensBefore("user1", time.Hour)
+	if _, err := ValidateToken(token); err != errRevokedToken {
+		t.Errorf("expected a token issued before the cutoff to be revoked, got %v", err)
+	}
+	if _, err := ValidateToken(other); err != nil {
+		t.Errorf("expected other users' tokens to stay valid, got %v", err)
+	}
+}
+
+func TestValidateTokenFailsClosed(t *testing.T) {
+	mr := setupRedis(t)
+	token, _ := utils.CreateJWT("user1", "jti-1")
+
+	// Without the denylist a token can't be trusted
+	mr.Close()
+	if _, err := ValidateToken(token); err == nil {
+		t.Error("expected the token to be rejected while Redis is down")
+	}
+}
+
+func TestJwtMiddlewareRejectsDeniedTokens(t *testing.T) {
+	setupRedis(t)
+	token, _ := utils.CreateJWT("user1", "jti-1")
+	redisrepo.DenyToken("jti-1", time.Now().Add(time.Hour))
+
+	handler := JwtMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("expected the request to be stopped")
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401, got %d", rec.Code)
+	}
+}
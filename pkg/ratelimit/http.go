@@ -0,0 +1,123 @@
+package ratelimit
+
+import (
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Rule picks the bucket and limit a request counts against. Requests it
+// returns ok=false for aren't limited.
+type Rule func(r *http.Request) (key string, l Limit, ok bool)
+
+// Middleware rejects requests over their limit with 429 Too Many Requests and
+// a Retry-After header
+func Middleware(limiter Limiter, rule Rule) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key, l, ok := rule(r)
+			if !ok || l.Unlimited() {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			res := limiter.Allow(r.Context(), key, l)
+			w.Header().Set("X-RateLimit-Limit", l.String())
+			w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(res.Remaining))
+			if !res.Allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(RetryAfterSeconds(res.RetryAfter)))
+				http.Error(w, "Too many requests", http.StatusTooManyRequests)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RetryAfterSeconds rounds a wait up to whole seconds, as Retry-After wants
+func RetryAfterSeconds(d time.Duration) int {
+	if d <= 0 {
+		return 1
+	}
+	return int(math.Ceil(d.Seconds()))
+}
+
+// trustedProxies are the hops whose X-Forwarded-For entries ClientIP believes,
+// set with TRUSTED_PROXIES as a comma separated list of IPs and CIDRs
+var trustedProxies = ParseProxies(os.Getenv("TRUSTED_PROXIES"))
+
+// SetTrustedProxies replaces the proxies ClientIP trusts
+func SetTrustedProxies(proxies []netip.Prefix) {
+	trustedProxies = proxies
+}
+
+// ParseProxies reads a comma separated list of IPs and CIDRs, skipping
+// entries that are neither
+func ParseProxies(list string) []netip.Prefix {
+	var proxies []netip.Prefix
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			addr, err := netip.ParseAddr(item)
+			if err != nil {
+				log.Println("Ignoring invalid trusted proxy", item)
+				continue
+			}
+			addr = addr.Unmap()
+			proxies = append(proxies, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(item)
+		if err != nil {
+			log.Println("Ignoring invalid trusted proxy", item)
+			continue
+		}
+		proxies = append(proxies, prefix.Masked())
+	}
+	return proxies
+}
+
+func trusted(addr netip.Addr) bool {
+	for _, p := range trustedProxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the address the request came from. When it came through
+// trusted proxies, that's the nearest hop in X-Forwarded-For they didn't add
+// themselves; the list is read right to left since a client can write anything
+// to its start.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	client, err := netip.ParseAddr(host)
+	if err != nil {
+		return host
+	}
+	client = client.Unmap()
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0 && trusted(client); i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		client = hop.Unmap()
+	}
+	return client.String()
+}
@@ -0,0 +1,84 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// sweepEvery is how many buckets the memory limiter creates between sweeps
+// of the ones that have refilled and can be forgotten
+const sweepEvery = 1024
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	per    time.Duration
+}
+
+// memoryLimiter keeps buckets in process. It is used when running a single
+// instance and whenever Redis can't be reached.
+type memoryLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	created int
+	now     func() time.Time
+}
+
+// NewMemoryLimiter creates an in-process Limiter
+func NewMemoryLimiter() Limiter {
+	return newMemoryLimiter(time.Now)
+}
+
+func newMemoryLimiter(now func() time.Time) *memoryLimiter {
+	return &memoryLimiter{buckets: make(map[string]*bucket), now: now}
+}
+
+func (m *memoryLimiter) Allow(ctx context.Context, key string, l Limit) Result {
+	if l.Unlimited() {
+		return Result{Allowed: true, Remaining: math.MaxInt32}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	b, ok := m.buckets[key]
+	if !ok {
+		m.sweep(now)
+		b = &bucket{tokens: float64(l.Burst), last: now}
+		m.buckets[key] = b
+	}
+	b.per = l.Per
+
+	tokens, allowed, retry := take(b.tokens, now.Sub(b.last), l)
+	b.tokens, b.last = tokens, now
+	return Result{Allowed: allowed, Remaining: int(tokens), RetryAfter: retry}
+}
+
+// sweep drops buckets that have been idle long enough to be full again
+func (m *memoryLimiter) sweep(now time.Time) {
+	m.created++
+	if m.created%sweepEvery != 0 {
+		return
+	}
+	for key, b := range m.buckets {
+		if now.Sub(b.last) >= b.per {
+			delete(m.buckets, key)
+		}
+	}
+}
+
+// take refills a bucket for the time elapsed since it was last used and
+// takes a token from it if there is one
+func take(tokens float64, elapsed time.Duration, l Limit) (float64, bool, time.Duration) {
+	rate := float64(l.Burst) / float64(l.Per) // tokens per nanosecond
+	if elapsed > 0 {
+		tokens = math.Min(float64(l.Burst), tokens+float64(elapsed)*rate)
+	}
+	if tokens >= 1 {
+		return tokens - 1, true, 0
+	}
+	return tokens, false, time.Duration(math.Ceil((1 - tokens) / rate))
+}
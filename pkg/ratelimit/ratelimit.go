@@ -0,0 +1,80 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limit is a token bucket: up to Burst requests at once, refilled evenly
+// over Per. A zero Limit lets everything through.
+type Limit struct {
+	Burst int
+	Per   time.Duration
+}
+
+// Unlimited reports whether the limit lets everything through
+func (l Limit) Unlimited() bool {
+	return l.Burst <= 0 || l.Per <= 0
+}
+
+func (l Limit) String() string {
+	if l.Unlimited() {
+		return "off"
+	}
+	return fmt.Sprintf("%d/%s", l.Burst, l.Per)
+}
+
+// Result is the outcome of taking a token from a bucket
+type Result struct {
+	Allowed   bool
+	Remaining int
+	// RetryAfter is how long until the next token, when not allowed
+	RetryAfter time.Duration
+}
+
+// Limiter takes tokens from buckets identified by key
+type Limiter interface {
+	Allow(ctx context.Context, key string, l Limit) Result
+}
+
+// ParseLimit parses a limit written as "<burst>/<duration>", e.g. "10/1m".
+// "off" and "0" disable the limit.
+func ParseLimit(s string) (Limit, error) {
+	s = strings.TrimSpace(s)
+	if s == "off" || s == "0" {
+		return Limit{}, nil
+	}
+
+	burst, per, ok := strings.Cut(s, "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("invalid rate limit %q", s)
+	}
+	n, err := strconv.Atoi(burst)
+	if err != nil || n < 0 {
+		return Limit{}, fmt.Errorf("invalid rate limit %q", s)
+	}
+	d, err := time.ParseDuration(per)
+	if err != nil || d <= 0 {
+		return Limit{}, fmt.Errorf("invalid rate limit %q", s)
+	}
+	return Limit{Burst: n, Per: d}, nil
+}
+
+// FromEnv returns the limit configured in RATE_LIMIT_<NAME>, or fallback if
+// it isn't set or can't be parsed
+func FromEnv(name string, fallback Limit) Limit {
+	key := "RATE_LIMIT_" + strings.ToUpper(strings.NewReplacer("-", "_", ":", "_").Replace(name))
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	l, err := ParseLimit(v)
+	if err != nil {
+		return fallback
+	}
+	return l
+}
@@ -0,0 +1,198 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+// clock is a settable time source for the limiters
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time { return c.t }
+
+func newRedisLimiter(t *testing.T, c *clock) (*redisLimiter, *miniredis.Miniredis) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return &redisLimiter{client: client, fallback: newMemoryLimiter(c.now), now: c.now}, mr
+}
+
+// exerciseBucket takes a 3/1s bucket through its burst, the rejection and the refill
+func exerciseBucket(t *testing.T, l Limiter, c *clock) {
+	ctx := context.Background()
+	limit := Limit{Burst: 3, Per: time.Second}
+
+	for i := 0; i < 3; i++ {
+		if res := l.Allow(ctx, "user1", limit); !res.Allowed || res.Remaining != 2-i {
+			t.Fatalf("request %d: expected allowed with %d remaining, got %+v", i, 2-i, res)
+		}
+	}
+
+	res := l.Allow(ctx, "user1", limit)
+	if res.Allowed {
+		t.Fatal("expected the fourth request to be limited")
+	}
+	if res.RetryAfter < 330*time.Millisecond || res.RetryAfter > 340*time.Millisecond {
+		t.Errorf("expected to retry in about a third of a second, got %s", res.RetryAfter)
+	}
+
+	// Other keys have their own bucket
+	if res := l.Allow(ctx, "user2", limit); !res.Allowed {
+		t.Error("expected another key to be allowed")
+	}
+
+	// A token is back once RetryAfter has passed
+	c.t = c.t.Add(res.RetryAfter)
+	if res := l.Allow(ctx, "user1", limit); !res.Allowed {
+		t.Error("expected a refilled token to be allowed")
+	}
+	if res := l.Allow(ctx, "user1", limit); res.Allowed {
+		t.Error("expected the bucket to be empty again")
+	}
+}
+
+func TestMemoryLimiter(t *testing.T) {
+	c := &clock{t: time.Unix(1700000000, 0)}
+	exerciseBucket(t, newMemoryLimiter(c.now), c)
+}
+
+func TestRedisLimiter(t *testing.T) {
+	c := &clock{t: time.Unix(1700000000, 0)}
+	l, mr := newRedisLimiter(t, c)
+	exerciseBucket(t, l, c)
+
+	if !mr.Exists("ratelimit:user1") {
+		t.Error("expected the bucket to be stored in Redis")
+	}
+	if ttl := mr.TTL("ratelimit:user1"); ttl <= 0 || ttl > time.Second {
+		t.Errorf("expected the bucket to expire within its period, got %s", ttl)
+	}
+}
+
+func TestRedisLimiterFallsBackWhenRedisIsDown(t *testing.T) {
+	c := &clock{t: time.Unix(1700000000, 0)}
+	l, mr := newRedisLimiter(t, c)
+	mr.Close()
+
+	limit := Limit{Burst: 1, Per: time.Minute}
+	if res := l.Allow(context.Background(), "user1", limit); !res.Allowed {
+		t.Fatal("expected the first request to be allowed")
+	}
+	if res := l.Allow(context.Background(), "user1", limit); res.Allowed {
+		t.Error("expected the local fallback to keep limiting")
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	limiter := NewMemoryLimiter()
+	rule := func(r *http.Request) (string, Limit, bool) {
+		return "login:" + ClientIP(r), Limit{Burst: 2, Per: time.Minute}, r.URL.Path == "/login"
+	}
+	handler := Middleware(limiter, rule)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	do := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		req.RemoteAddr = "203.0.113.7:4321"
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for i := 0; i < 2; i++ {
+		if rec := do("/login"); rec.Code != http.StatusOK {
+			t.Fatalf("request %d: expected 200, got %d", i, rec.Code)
+		}
+	}
+
+	rec := do("/login")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "30" {
+		t.Errorf("expected Retry-After 30, got %q", got)
+	}
+
+	// Routes without a rule aren't limited
+	if rec := do("/status"); rec.Code != http.StatusOK {
+		t.Errorf("expected unlimited route to pass, got %d", rec.Code)
+	}
+}
+
+func TestParseLimit(t *testing.T) {
+	cases := map[string]Limit{
+		"10/1m": {Burst: 10, Per: time.Minute},
+		"5/30s": {Burst: 5, Per: 30 * time.Second},
+		"off":   {},
+		"0":     {},
+	}
+	for in, want := range cases {
+		got, err := ParseLimit(in)
+		if err != nil || got != want {
+			t.Errorf("ParseLimit(%q) = %v, %v; want %v", in, got, err, want)
+		}
+	}
+
+	for _, in := range []string{"10", "ten/1m", "10/forever", "-1/1m"} {
+		if _, err := ParseLimit(in); err == nil {
+			t.Errorf("ParseLimit(%q) should fail", in)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	SetTrustedProxies(ParseProxies("10.0.0.0/8, 192.0.2.1"))
+	t.Cleanup(func() { SetTrustedProxies(nil) })
+
+	cases := []struct {
+		remote, forwarded, want string
+	}{
+		// Clients can't claim another address
+		{"203.0.113.7:4321", "198.51.100.1", "203.0.113.7"},
+		// Trusted proxies are looked through
+		{"10.0.0.2:80", "198.51.100.1", "198.51.100.1"},
+		{"10.0.0.2:80", "198.51.100.1, 192.0.2.1", "198.51.100.1"},
+		// Anything left of the first untrusted hop may be forged
+		{"10.0.0.2:80", "1.1.1.1, 198.51.100.1", "198.51.100.1"},
+		{"10.0.0.2:80", "1.1.1.1, 198.51.100.1, 10.0.0.3", "198.51.100.1"},
+		// Garbage stops at the last hop that could be checked
+		{"10.0.0.2:80", "198.51.100.1, unknown", "10.0.0.2"},
+		{"10.0.0.2:80", "", "10.0.0.2"},
+		{"[::ffff:10.0.0.2]:80", "198.51.100.1", "198.51.100.1"},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = c.remote
+		if c.forwarded != "" {
+			req.Header.Set("X-Forwarded-For", c.forwarded)
+		}
+		if got := ClientIP(req); got != c.want {
+			t.Errorf("ClientIP(%s, %q) = %s, want %s", c.remote, c.forwarded, got, c.want)
+		}
+	}
+}
+
+func TestParseProxies(t *testing.T) {
+	got := ParseProxies("10.1.2.3/8, ::1, bogus, 192.0.2.1")
+	want := []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("::1/128"),
+		netip.MustParsePrefix("192.0.2.1/32"),
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("expected %v, got %v", want, got)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package ratelimit
+
+import (
+	"context"
+	"log"
+	"math"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// tokenBucket refills and takes from a bucket stored as a hash in one round
+// trip. Times are in milliseconds; the bucket expires once it would be full.
+var tokenBucket = redis.NewScript(`
+local burst = tonumber(ARGV[1])
+local per = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+local state = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(state[1]) or burst
+local ts = tonumber(state[2]) or now
+
+local elapsed = now - ts
+if elapsed > 0 then
+	tokens = math.min(burst, tokens + elapsed * burst / per)
+end
+
+local allowed = 0
+local retry = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	retry = math.ceil((1 - tokens) * per / burst)
+end
+
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "ts", tostring(now))
+redis.call("PEXPIRE", KEYS[1], per)
+return {allowed, math.floor(tokens), retry}
+`)
+
+// redisLimiter shares buckets between instances through Redis, falling back
+// to buckets in process while Redis is unavailable
+type redisLimiter struct {
+	client   *redis.Client
+	fallback *memoryLimiter
+	now      func() time.Time
+}
+
+// NewRedisLimiter creates a Limiter that keeps its buckets in Redis
+func NewRedisLimiter(client *redis.Client) Limiter {
+	return &redisLimiter{client: client, fallback: newMemoryLimiter(time.Now), now: time.Now}
+}
+
+func (rl *redisLimiter) Allow(ctx context.Context, key string, l Limit) Result {
+	if l.Unlimited() {
+		return Result{Allowed: true, Remaining: math.MaxInt32}
+	}
+
+	res, err := tokenBucket.Run(ctx, rl.client, []string{"ratelimit:" + key},
+		l.Burst, l.Per.Milliseconds(), rl.now().UnixMilli()).Int64Slice()
+	if err != nil || len(res) != 3 {
+		log.Println("Error checking rate limit, using local limits:", err)
+		return rl.fallback.Allow(ctx, key, l)
+	}
+
+	return Result{
+		Allowed:    res[0] == 1,
+		Remaining:  int(res[1]),
+		RetryAfter: time.Duration(res[2]) * time.Millisecond,
+	}
+}
@@ -0,0 +1,342 @@
+package redisrepo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"gochatapp/model"
+	"gochatapp/utils"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// The hot tier keeps the recent chats of each conversation: every chat is a
+// chat#<id> document expiring after the retention window, and
+// conversation:<id>:chats is a sorted set of the cached IDs scored by ID.
+// Documents expire in ID order, so the cached chats are always a contiguous
+// suffix of the conversation and PostgreSQL serves everything older.
+
+// defaultChatRetention applies unless REDIS_CHAT_RETENTION says otherwise
+const defaultChatRetention = 7 * 24 * time.Hour
+
+// chatRetention returns how long chats stay cached in conversations without
+// a retention of their own
+func chatRetention() time.Duration {
+	return utils.DurationFromEnv("REDIS_CHAT_RETENTION", defaultChatRetention)
+}
+
+// cacheChat stores a chat document and indexes it. Its time to live is the
+// retention window, clamped between those of the cached chats next to it by
+// ID, so documents keep expiring in ID order even when chats are cached out
+// of order or the window changes. TTLs are relative, so no clock is trusted.
+var cacheChat = redis.NewScript(`
+local ttl = tonumber(ARGV[3])
+local lower = redis.call("ZREVRANGEBYSCORE", KEYS[2], "(" .. ARGV[2], "-inf", "LIMIT", 0, 1)[1]
+if lower then
+	local t = redis.call("PTTL", ARGV[4] .. lower)
+	if t > ttl then ttl = t end
+end
+local higher = redis.call("ZRANGEBYSCORE", KEYS[2], "(" .. ARGV[2], "+inf", "LIMIT", 0, 1)[1]
+if higher then
+	local t = redis.call("PTTL", ARGV[4] .. higher)
+	if t > 0 and t < ttl then ttl = t end
+end
+redis.call("SET", KEYS[1], ARGV[1], "PX", ttl)
+redis.call("ZADD", KEYS[2], ARGV[2], ARGV[2])
+if redis.call("PTTL", KEYS[2]) < ttl then
+	redis.call("PEXPIRE", KEYS[2], ttl)
+end
+return ttl
+`)
+
+// CacheChat writes a chat to the hot tier of its conversation, for as long as
+// the conversation's retention, or the default one if that's zero
+func CacheChat(c *model.Chat, retention time.Duration) error {
+	id, err := strconv.ParseInt(c.ID, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid chat id %q: %w", c.ID, err)
+	}
+
+	by, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	if retention <= 0 {
+		retention = chatRetention()
+	}
+	keys := []string{chatKey(c.ID), conversationChatZKey(c.ConversationID)}
+	err = cacheChat.Run(context.Background(), redisClient, keys,
+		string(by), id, retention.Milliseconds(), chatKey("")).Err()
+	if err != nil {
+		return err
+	}
+
+	// Drop the IDs whose documents have expired since the last write
+	_, err = ConversationFloor(c.ConversationID)
+	return err
+}
+
+// InvalidateConversation drops the index of a conversation's cached chats,
+// after a chat of it couldn't be cached. The floor then starts over above the
+// missing chat, so history reads it from PostgreSQL rather than skipping it.
+func InvalidateConversation(conversationID string) error {
+	err := redisClient.Del(context.Background(), conversationChatZKey(conversationID)).Err()
+	if err != nil {
+		log.Println("Error invalidating cached chats of conversation", conversationID, err)
+	}
+	return err
+}
+
+// RefreshChat overwrites the cached document of a chat after it changed,
+// keeping its expiry. Chats that aren't cached are left to PostgreSQL.
+func RefreshChat(c *model.Chat) error {
+	by, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	err = redisClient.SetXX(context.Background(), chatKey(c.ID), string(by), redis.KeepTTL).Err()
+	if err != nil && err != redis.Nil {
+		log.Println("Error refreshing cached chat", c.ID, err)
+		return err
+	}
+	return nil
+}
+
+// replaceChat swaps a cached chat document for a new version only if it still
+// is the one the new version was made from
+var replaceChat = redis.NewScript(`
+if redis.call("GET", KEYS[1]) ~= ARGV[1] then
+	return 0
+end
+redis.call("SET", KEYS[1], ARGV[2], "KEEPTTL")
+return 1
+`)
+
+// maxPatchRetries bounds how often patchChat starts over when the cached chat
+// changes under it
+const maxPatchRetries = 5
+
+// patchChat applies change to the cached chat document, if the chat is
+// cached. change reports whether it changed anything. The document is only
+// replaced if nothing else wrote it since it was read, so a concurrent edit
+// or delete is never undone.
+func patchChat(id string, change func(c *model.Chat) bool) error {
+	ctx := context.Background()
+	for i := 0; i < maxPatchRetries; i++ {
+		current, err := redisClient.Get(ctx, chatKey(id)).Result()
+		if err == redis.Nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var c model.Chat
+		if err := json.Unmarshal([]byte(current), &c); err != nil {
+			return err
+		}
+		if !change(&c) {
+			return nil
+		}
+		by, err := json.Marshal(c)
+		if err != nil {
+			return err
+		}
+
+		replaced, err := replaceChat.Run(ctx, redisClient, []string{chatKey(id)}, current, string(by)).Int()
+		if err != nil || replaced == 1 {
+			return err
+		}
+	}
+	return fmt.Errorf("chat %s kept changing while it was patched", id)
+}
+
+// HideChat leaves a cached chat out of the pages username reads. The set
+// lives as long as the chats it hides stay cached.
+func HideChat(conversationID, username, id string) error {
+	ctx := context.Background()
+	key := hiddenChatSKey(conversationID, username)
+
+	ttl := redisClient.PTTL(ctx, conversationChatZKey(conversationID)).Val()
+	if ttl <= 0 {
+		ttl = chatRetention()
+	}
+	_, err := redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.SAdd(ctx, key, id)
+		pipe.PExpire(ctx, key, ttl)
+		return nil
+	})
+	if err != nil {
+		log.Println("Error hiding chat", id, "for", username, err)
+	}
+	return err
+}
+
+// FetchConversationPage returns a page of the cached chats of a conversation,
+// without the ones the viewer of the range hid. A zero limit returns every
+// cached chat in the range.
+func FetchConversationPage(conversationID string, r model.ChatRange) ([]model.Chat, error) {
+	ctx := context.Background()
+
+	// Exclusive score bounds, since scores are the message IDs
+	lo, hi := "-inf", "+inf"
+	if r.AfterID > 0 {
+		lo = fmt.Sprintf("(%d", r.AfterID)
+	}
+	if r.BeforeID > 0 {
+		hi = fmt.Sprintf("(%d", r.BeforeID)
+	}
+
+	var chats []model.Chat
+	for {
+		by := &redis.ZRangeBy{Min: lo, Max: hi, Count: int64(r.Limit)}
+
+		var ids []string
+		var err error
+		if r.Ascending {
+			ids, err = redisClient.ZRangeByScore(ctx, conversationChatZKey(conversationID), by).Result()
+		} else {
+			ids, err = redisClient.ZRevRangeByScore(ctx, conversationChatZKey(conversationID), by).Result()
+		}
+		if err != nil {
+			log.Println("Error fetching chat IDs for conversation", conversationID, err)
+			return nil, err
+		}
+
+		visible, err := visibleChatIDs(conversationID, r.Viewer, ids)
+		if err != nil {
+			return nil, err
+		}
+		page, err := fetchChats(visible)
+		if err != nil {
+			return nil, err
+		}
+		chats = append(chats, page...)
+
+		// Keep reading past hidden chats until the page is full, since callers
+		// take a short page to mean the cache is exhausted
+		if r.Limit == 0 || len(ids) < r.Limit || len(chats) >= r.Limit {
+			break
+		}
+		last := "(" + ids[len(ids)-1]
+		if r.Ascending {
+			lo = last
+		} else {
+			hi = last
+		}
+	}
+
+	if r.Limit > 0 && len(chats) > r.Limit {
+		chats = chats[:r.Limit]
+	}
+	return chats, nil
+}
+
+// visibleChatIDs drops the IDs username hid in the conversation
+func visibleChatIDs(conversationID, username string, ids []string) ([]string, error) {
+	if username == "" || len(ids) == 0 {
+		return ids, nil
+	}
+
+	members := make([]interface{}, len(ids))
+	for i, id := range ids {
+		members[i] = id
+	}
+	hidden, err := redisClient.SMIsMember(context.Background(), hiddenChatSKey(conversationID, username), members...).Result()
+	if err != nil {
+		log.Println("Error checking hidden chats for", username, err)
+		return nil, err
+	}
+
+	visible := make([]string, 0, len(ids))
+	for i, id := range ids {
+		if !hidden[i] {
+			visible = append(visible, id)
+		}
+	}
+	return visible, nil
+}
+
+// fetchChats loads chat documents by ID, skipping the ones that have expired
+func fetchChats(ids []string) ([]model.Chat, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = chatKey(id)
+	}
+
+	payloads, err := redisClient.MGet(context.Background(), keys...).Result()
+	if err != nil {
+		log.Println("Error fetching chats:", err)
+		return nil, err
+	}
+
+	chats := make([]model.Chat, 0, len(payloads))
+	for _, p := range payloads {
+		s, ok := p.(string)
+		if !ok {
+			continue
+		}
+		var c model.Chat
+		if err := json.Unmarshal([]byte(s), &c); err == nil {
+			chats = append(chats, c)
+		}
+	}
+	return chats, nil
+}
+
+// ConversationFloor returns the ID of the oldest cached chat of a
+// conversation, or 0 if none is cached. Every chat of the conversation from
+// the floor onwards is in Redis; anything older is only in PostgreSQL. IDs
+// whose documents have expired are removed from the index on the way.
+func ConversationFloor(conversationID string) (int64, error) {
+	const batch = 16
+
+	ctx := context.Background()
+	zkey := conversationChatZKey(conversationID)
+
+	for {
+		res, err := redisClient.ZRangeWithScores(ctx, zkey, 0, batch-1).Result()
+		if err != nil {
+			log.Println("Error fetching cache floor for conversation", conversationID, err)
+			return 0, err
+		}
+		if len(res) == 0 {
+			return 0, nil
+		}
+
+		cmds := make([]*redis.IntCmd, len(res))
+		_, err = redisClient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i, z := range res {
+				cmds[i] = pipe.Exists(ctx, chatKey(z.Member.(string)))
+			}
+			return nil
+		})
+		if err != nil {
+			log.Println("Error checking cached chats for conversation", conversationID, err)
+			return 0, err
+		}
+
+		for i, cmd := range cmds {
+			if cmd.Val() == 0 {
+				continue
+			}
+			// Everything below the first live document has expired
+			if i > 0 {
+				redisClient.ZRemRangeByScore(ctx, zkey, "-inf", fmt.Sprintf("(%d", int64(res[i].Score)))
+			}
+			return int64(res[i].Score), nil
+		}
+
+		// The whole batch has expired, drop it and look further
+		redisClient.ZRemRangeByRank(ctx, zkey, 0, int64(len(res)-1))
+	}
+}
@@ -29,4 +29,10 @@ func InitialiseRedis() *redis.Client {
 
 	redisClient = conn
 	return redisClient
-}
\ No newline at end of file
+}
+
+// UseClient points the package at an existing connection, such as an
+// in-memory Redis in the tests of other packages
+func UseClient(client *redis.Client) {
+	redisClient = client
+}
@@ -1,28 +1,78 @@
 package redisrepo
 
 import (
-	"fmt"
-	"time"
+	"crypto/sha256"
+	"encoding/hex"
 )
 
 func userSetKey() string {
 	return "users"
 }
 
-// sessionKey generates a key for client sessions.
-// Currently unused but can be useful for managing session data in Redis.
-// func sessionKey(client string) string {
-// 	return "session#" + client
-// }
+func chatKey(id string) string {
+	return "chat#" + id
+}
+
+// conversationChatZKey indexes the cached chats of a conversation, scored by
+// message ID
+func conversationChatZKey(conversationID string) string {
+	return "conversation:" + conversationID + ":chats"
+}
+
+// hiddenChatSKey holds the cached chats of a conversation that a user deleted
+// for themselves
+func hiddenChatSKey(conversationID, username string) string {
+	return "conversation:" + conversationID + ":hidden:" + username
+}
+
+// pendingZKey orders the messages waiting to be delivered to an offline user
+func pendingZKey(username string) string {
+	return "pending:" + username
+}
+
+// pendingHKey holds the payloads of the messages queued in pendingZKey
+func pendingHKey(username string) string {
+	return "pending#" + username
+}
+
+// presenceHKey holds the status, last-seen and heartbeat times of a user
+func presenceHKey(username string) string {
+	return "presence#" + username
+}
+
+// sessionPresenceHKey holds the status and last heartbeat of each of a
+// user's connected sessions, on whichever instance they are
+func sessionPresenceHKey(username string) string {
+	return "presence:sessions#" + username
+}
+
+// sessionHKey holds the details of a user's connected devices by session ID
+func sessionHKey(username string) string {
+	return "sessions#" + username
+}
+
+// sessionZKey scores a user's sessions by their last heartbeat
+func sessionZKey(username string) string {
+	return "sessions:" + username
+}
+
+// deniedTokenKey marks a revoked access token by its jti
+func deniedTokenKey(jti string) string {
+	return "token:denied:" + jti
+}
 
-func chatKey() string {
-	return fmt.Sprintf("chat#%d", time.Now().UnixMilli())
+// tokenCutoffKey holds the time before which a user's access tokens are revoked
+func tokenCutoffKey(username string) string {
+	return "token:cutoff:" + username
 }
 
-func chatIndex() string {
-	return "idx#chats"
+// unreadHKey caches the unread count of each of a user's conversations
+func unreadHKey(username string) string {
+	return "unread#" + username
 }
 
-func contactListZKey(username string) string {
-	return "contacts:" + username
+// previewKey caches the link preview of a URL, by its hash to bound the key size
+func previewKey(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return "preview:" + hex.EncodeToString(sum[:])
 }
@@ -0,0 +1,148 @@
+package redisrepo
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+
+	"gochatapp/model"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// PresenceTimeout is how long a user stays online or away without a
+// heartbeat. The socket ping loop beats every 30 seconds, so a user only goes
+// stale when the instance holding their socket is gone.
+const PresenceTimeout = 90 * time.Second
+
+// updatePresence sets the status of the sessions in ARGV[4..], drops them
+// when it's offline or only renews their heartbeats when it's empty, then
+// works the user's presence out from every session with a fresh heartbeat:
+// online beats away, and the user is offline with no session left. Sessions
+// of instances that stopped beating are dropped. It returns the user's status
+// before and after.
+var updatePresence = redis.NewScript(`
+local now, timeout, status = tonumber(ARGV[1]), tonumber(ARGV[2]), ARGV[3]
+for i = 4, #ARGV do
+	if status == "offline" then
+		redis.call("HDEL", KEYS[1], ARGV[i])
+	elseif status ~= "" then
+		redis.call("HSET", KEYS[1], ARGV[i], status .. ":" .. now)
+	else
+		local current = redis.call("HGET", KEYS[1], ARGV[i])
+		if current then
+			redis.call("HSET", KEYS[1], ARGV[i], string.match(current, "^[^:]*") .. ":" .. now)
+		end
+	end
+end
+
+local after = "offline"
+local sessions = redis.call("HGETALL", KEYS[1])
+for i = 1, #sessions, 2 do
+	local s, beat = string.match(sessions[i + 1], "^([^:]*):(%d+)$")
+	if not beat or now - tonumber(beat) > timeout then
+		redis.call("HDEL", KEYS[1], sessions[i])
+	elseif s == "online" then
+		after = "online"
+	elseif s == "away" and after == "offline" then
+		after = "away"
+	end
+end
+redis.call("EXPIRE", KEYS[1], timeout)
+
+local before = redis.call("HGET", KEYS[2], "status") or "offline"
+local beat = tonumber(redis.call("HGET", KEYS[2], "heartbeat")) or 0
+if now - beat > timeout then
+	before = "offline"
+end
+if before ~= "offline" or after ~= "offline" then
+	redis.call("HSET", KEYS[2], "status", after, "last_seen", now, "heartbeat", now)
+end
+return {before, after}
+`)
+
+func presenceArgs(status string, sessionIDs []string) []interface{} {
+	args := []interface{}{time.Now().Unix(), int(PresenceTimeout.Seconds()), status}
+	for _, id := range sessionIDs {
+		args = append(args, id)
+	}
+	return args
+}
+
+// SetSessionPresence records the status of one of a user's sessions, or
+// drops the session when the status is offline, and returns the user's
+// presence across all their sessions before and after
+func SetSessionPresence(username, sessionID, status string) (before, after string, err error) {
+	keys := []string{sessionPresenceHKey(username), presenceHKey(username)}
+	res, err := updatePresence.Run(context.Background(), redisClient, keys, presenceArgs(status, []string{sessionID})...).StringSlice()
+	if err != nil {
+		log.Println("Error setting presence for", username, err)
+		return "", "", err
+	}
+	return res[0], res[1], nil
+}
+
+// TouchPresence renews the heartbeats of live sessions, keyed by username,
+// and returns the users whose presence changed as sessions on other
+// instances went stale. The script is sent in full, as EVALSHA can't fall
+// back to EVAL inside a pipeline.
+func TouchPresence(sessions map[string][]string) (map[string]string, error) {
+	if len(sessions) == 0 {
+		return nil, nil
+	}
+
+	ctx := context.Background()
+	cmds := make(map[string]*redis.Cmd, len(sessions))
+	_, err := redisClient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for username, ids := range sessions {
+			keys := []string{sessionPresenceHKey(username), presenceHKey(username)}
+			cmds[username] = updatePresence.Eval(ctx, pipe, keys, presenceArgs("", ids)...)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println("Error renewing presence heartbeats:", err)
+		return nil, err
+	}
+
+	changed := make(map[string]string)
+	for username, cmd := range cmds {
+		if res, err := cmd.StringSlice(); err == nil && res[0] != res[1] {
+			changed[username] = res[1]
+		}
+	}
+	return changed, nil
+}
+
+// FetchPresence returns the presence of each user, in order. Users without a
+// recent heartbeat are offline.
+func FetchPresence(usernames ...string) ([]model.Presence, error) {
+	ctx := context.Background()
+	cmds := make([]*redis.StringStringMapCmd, len(usernames))
+	_, err := redisClient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, username := range usernames {
+			cmds[i] = pipe.HGetAll(ctx, presenceHKey(username))
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println("Error fetching presence:", err)
+		return nil, err
+	}
+
+	now := time.Now().Unix()
+	presence := make([]model.Presence, len(usernames))
+	for i, cmd := range cmds {
+		fields := cmd.Val()
+		heartbeat, _ := strconv.ParseInt(fields["heartbeat"], 10, 64)
+		lastSeen, _ := strconv.ParseInt(fields["last_seen"], 10, 64)
+
+		p := model.Presence{Username: usernames[i], Status: fields["status"], LastSeen: float64(lastSeen)}
+		if p.Status == "" || now-heartbeat > int64(PresenceTimeout.Seconds()) {
+			p.Status = model.PresenceOffline
+		}
+		presence[i] = p
+	}
+	return presence, nil
+}
@@ -0,0 +1,73 @@
+package redisrepo
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"gochatapp/model"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// How long link previews are cached. Links without a preview are retried
+// sooner, the page may have been down.
+const (
+	previewTTL       = 24 * time.Hour
+	missedPreviewTTL = time.Hour
+)
+
+// CachePreview caches the preview of a URL. A nil preview records that the
+// URL has none, so it isn't fetched for every message that links it.
+func CachePreview(url string, preview *model.LinkPreview) error {
+	ttl := previewTTL
+	by := []byte("null")
+	if preview != nil {
+		var err error
+		if by, err = json.Marshal(preview); err != nil {
+			return err
+		}
+	} else {
+		ttl = missedPreviewTTL
+	}
+
+	if err := redisClient.Set(context.Background(), previewKey(url), by, ttl).Err(); err != nil {
+		log.Println("Error caching link preview of", url, err)
+		return err
+	}
+	return nil
+}
+
+// FetchCachedPreview returns the cached preview of a URL. ok is false when
+// the URL isn't cached; a cached URL without a preview gives nil and true.
+func FetchCachedPreview(url string) (preview *model.LinkPreview, ok bool, err error) {
+	by, err := redisClient.Get(context.Background(), previewKey(url)).Bytes()
+	if err == redis.Nil {
+		return nil, false, nil
+	}
+	if err != nil {
+		log.Println("Error fetching cached link preview of", url, err)
+		return nil, false, err
+	}
+	if err := json.Unmarshal(by, &preview); err != nil {
+		return nil, false, err
+	}
+	return preview, true, nil
+}
+
+// SetChatPreview sets the link preview in the cached chat document, if the
+// chat is cached, still reads text and isn't deleted
+func SetChatPreview(id, text string, preview *model.LinkPreview) error {
+	err := patchChat(id, func(c *model.Chat) bool {
+		if c.Msg != text || c.Deleted {
+			return false
+		}
+		c.Preview = preview
+		return true
+	})
+	if err != nil {
+		log.Println("Error setting link preview of chat", id, err)
+	}
+	return err
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"gochatapp/model"
 	"gochatapp/pkg/db"
+	"gochatapp/utils"
 	"log"
 	"strings"
 	"time"
@@ -41,132 +42,176 @@ func IsUserAuthentic(username, password string) error {
 	return nil
 }
 
-func UpdateContactList(username, contact string) error {
-	zs := &redis.Z{Score: float64(time.Now().Unix()), Member: contact}
-	err := redisClient.ZAdd(context.Background(), contactListZKey(username), zs).Err()
-	if err != nil {
-		log.Println("Error updating contact list for user:", username, "Contact:", contact, err)
-		return err
-	}
-	return nil
-}
-
+// CreateChat persists a chat and returns its ID. The chat is written to
+// PostgreSQL first so the row ID is used as the message ID everywhere.
 func CreateChat(c *model.Chat) (string, error) {
-	chatKey := chatKey()
-	
-	// Serialize the chat object
-	by, err := json.Marshal(c)
+	c.Status = model.ChatStatusSent
+	retention, err := db.StoreChatInPostgres(c)
 	if err != nil {
-		log.Println("Error marshaling chat:", err)
 		return "", err
 	}
-	
-	// Save chat in Redis concurrently using standard SET command instead of JSON.SET
-	redisDone := make(chan string, 1)
-	go func() {
-		res, err := redisClient.Set(
-			context.Background(),
-			chatKey,
-			string(by),
-			0, // no expiration
-		).Result()
-		
-		if err != nil {
-			log.Println("Error setting chat in Redis:", err)
-		} else {
-			log.Println("Chat successfully set in Redis:", res)
-			redisDone <- chatKey
-		}
-		close(redisDone)
-	}()
-	
-	// Update contact lists concurrently
-	go func() {
-		if err := UpdateContactList(c.From, c.To); err != nil {
-			log.Println("Error updating contact list for", c.From)
-		}
-		if err := UpdateContactList(c.To, c.From); err != nil {
-			log.Println("Error updating contact list for", c.To)
-		}
-	}()
-	
-	// Store chat in PostgreSQL concurrently
-	go func() {
-		if err := db.StoreChatInPostgres(c); err != nil {
-			log.Println("Error storing chat in PostgreSQL:", err)
-		}
-	}()
-	
-	// Wait for Redis operation to complete to return key
-	chatKey = <-redisDone
-	return chatKey, nil
-}
 
+	// Cache the chat in Redis. PostgreSQL already holds it, but the hot tier
+	// must not have a hole above its floor, so a failure resets the tier.
+	if err := CacheChat(c, retention); err != nil {
+		log.Println("Error caching chat in Redis:", err)
+		InvalidateConversation(c.ConversationID)
+	}
 
+	return c.ID, nil
+}
 
-func CreateFetchChatBetweenIndex() {
-	res, err := redisClient.Do(context.Background(),
-		"FT.CREATE", chatIndex(),
-		"ON", "JSON",
-		"PREFIX", "1", "chat#",
-		"SCHEMA",
-		"$.from", "AS", "from", "TAG",
-		"$.to", "AS", "to", "TAG",
-		"$.timestamp", "AS", "timestamp", "NUMERIC", "SORTABLE",
-	).Result()
+// statusRank orders chat states so an update never moves a message backwards
+var statusRank = map[string]int{
+	model.ChatStatusSent:      1,
+	model.ChatStatusDelivered: 2,
+	model.ChatStatusRead:      3,
+}
 
+// UpdateChatStatus advances the status stored in the cached chat document.
+// Chats that aren't in the hot tier have their state in PostgreSQL.
+func UpdateChatStatus(id, status string) error {
+	err := patchChat(id, func(c *model.Chat) bool {
+		if statusRank[status] <= statusRank[c.Status] {
+			return false
+		}
+		c.Status = status
+		return true
+	})
 	if err != nil {
-		log.Println("Error creating chat index:", err)
-		return
+		log.Println("Error updating status of chat", id, err)
 	}
-
-	fmt.Println("Index created successfully:", res)
+	return err
 }
 
-func FetchChatBetween(username1, username2, fromTS, toTS string) ([]model.Chat, error) {
-	// Fetch messages from Redis with a limit (20 for recent messages)
-	limit := "20" // or pass the limit dynamically based on your server load or user preferences
-
-	query := fmt.Sprintf("@from:{%s|%s} @to:{%s|%s} @timestamp:[%s %s]",
-		username1, username2, username1, username2, fromTS, toTS)
+// enqueuePending adds a chat to a pending queue ordered by message ID, then
+// drops what's past its age or size limit, oldest first, and keeps the queue
+// alive for the age limit. A chat's age is read from its own timestamp.
+var enqueuePending = redis.NewScript(`
+redis.call("HSET", KEYS[2], ARGV[1], ARGV[2])
+redis.call("ZADD", KEYS[1], ARGV[1], ARGV[1])
+
+local dropped = 0
+local max = tonumber(ARGV[5])
+while true do
+	local oldest = redis.call("ZRANGE", KEYS[1], 0, 0)[1]
+	if not oldest then
+		break
+	end
+	local stale = redis.call("ZCARD", KEYS[1]) > max
+	if not stale then
+		local payload = redis.call("HGET", KEYS[2], oldest)
+		stale = not payload or cjson.decode(payload).timestamp < tonumber(ARGV[3])
+	end
+	if not stale then
+		break
+	end
+	redis.call("ZREM", KEYS[1], oldest)
+	redis.call("HDEL", KEYS[2], oldest)
+	dropped = dropped + 1
+end
+
+redis.call("PEXPIRE", KEYS[1], ARGV[4])
+redis.call("PEXPIRE", KEYS[2], ARGV[4])
+return dropped
+`)
+
+// pendingLimits returns how long a chat waits in a pending queue and how many
+// chats a queue holds, set with PENDING_TTL and PENDING_MAX. Chats dropped
+// from the queue are still in the history the client fetches.
+func pendingLimits() (time.Duration, int) {
+	return utils.DurationFromEnv("PENDING_TTL", 7*24*time.Hour), utils.IntFromEnv("PENDING_MAX", 1000)
+}
 
-	// Redis search query to fetch messages in batches (20 messages at a time)
-	res, err := redisClient.Do(context.Background(),
-		"FT.SEARCH",
-		chatIndex(),
-		query,
-		"SORTBY", "timestamp", "DESC",
-		"LIMIT", "0", limit,
-	).Result()
+// EnqueuePending queues a chat for the recipient until it's handed off to one
+// of their sockets. A full queue drops its oldest chats.
+func EnqueuePending(username string, c *model.Chat) error {
+	by, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
 
+	ttl, max := pendingLimits()
+	keys := []string{pendingZKey(username), pendingHKey(username)}
+	dropped, err := enqueuePending.Run(context.Background(), redisClient, keys,
+		c.ID, by, time.Now().Add(-ttl).Unix(), ttl.Milliseconds(), max).Int()
 	if err != nil {
-		log.Println("Error fetching chat between users:", err)
-		return nil, err
+		log.Println("Error queueing pending chat for", username, err)
+		return err
+	}
+	if dropped > 0 {
+		log.Printf("Dropped %d pending chats of %s past the queue limits", dropped, username)
 	}
+	return nil
+}
 
-	// Deserialize the response from Redis
-	data := Deserialise(res)
-	if data == nil {
-		return nil, fmt.Errorf("no data found for the given query")
+// RefreshPending replaces the queued payload of a chat that hasn't been
+// delivered to username yet, so they receive its latest state
+func RefreshPending(username string, c *model.Chat) error {
+	ctx := context.Background()
+	if !redisClient.HExists(ctx, pendingHKey(username), c.ID).Val() {
+		return nil
 	}
 
-	// Convert deserialized data to model.Chat objects
-	return DeserialiseChat(data), nil
+	by, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	if err := redisClient.HSet(ctx, pendingHKey(username), c.ID, by).Err(); err != nil {
+		log.Println("Error refreshing pending chat for", username, err)
+		return err
+	}
+	return nil
 }
 
-func FetchContactList(username string) ([]model.ContactList, error) {
-	zRangeArg := redis.ZRangeArgs{
-		Key:   contactListZKey(username),
-		Start: 0,
-		Stop:  -1,
-		Rev:   true,
+// FetchPending returns the chats still waiting to be delivered to a user,
+// oldest first
+func FetchPending(username string) ([]model.Chat, error) {
+	ctx := context.Background()
+	ids, err := redisClient.ZRange(ctx, pendingZKey(username), 0, -1).Result()
+	if err != nil {
+		log.Println("Error fetching pending chats for", username, err)
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, nil
 	}
 
-	res, err := redisClient.ZRangeArgsWithScores(context.Background(), zRangeArg).Result()
+	payloads, err := redisClient.HMGet(ctx, pendingHKey(username), ids...).Result()
 	if err != nil {
-		log.Println("Error fetching contact list for user:", username, err)
+		log.Println("Error fetching pending payloads for", username, err)
 		return nil, err
 	}
 
-	return DeserialiseContactList(res), nil
+	chats := make([]model.Chat, 0, len(payloads))
+	for i, p := range payloads {
+		s, ok := p.(string)
+		if !ok {
+			// Payload is gone, drop the dangling entry
+			redisClient.ZRem(ctx, pendingZKey(username), ids[i])
+			continue
+		}
+		var c model.Chat
+		if err := json.Unmarshal([]byte(s), &c); err != nil {
+			log.Println("Error decoding pending chat", ids[i], err)
+			continue
+		}
+		chats = append(chats, c)
+	}
+	return chats, nil
+}
+
+// AckPending removes a chat from the user's pending queue once it has been
+// written to one of their sockets
+func AckPending(username, id string) error {
+	ctx := context.Background()
+	_, err := redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZRem(ctx, pendingZKey(username), id)
+		pipe.HDel(ctx, pendingHKey(username), id)
+		return nil
+	})
+	if err != nil {
+		log.Println("Error acknowledging pending chat", id, "for", username, err)
+	}
+	return err
 }
@@ -1,11 +1,15 @@
 package redisrepo
 
 import (
-	"context"
 	"fmt"
+	"strconv"
 	"testing"
 	"time"
 
+	"gochatapp/model"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
 	"github.com/joho/godotenv"
 )
 
@@ -13,123 +17,530 @@ func init() {
 	godotenv.Load("../../.env")
 }
 
-func TestUpdateContactList(t *testing.T) {
-	InitialiseRedis()
-	defer redisClient.Close()
+// setupRedis points the package at an in-memory Redis for the duration of a test
+func setupRedis(t *testing.T) *miniredis.Miniredis {
+	mr := miniredis.RunT(t)
+	redisClient = redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { redisClient.Close() })
+	return mr
+}
+
+func cacheChats(t *testing.T, conversationID string, ids ...int) {
+	for _, id := range ids {
+		err := CacheChat(&model.Chat{
+			ID:             fmt.Sprint(id),
+			From:           "user1",
+			To:             "user2",
+			Msg:            fmt.Sprintf("message %d", id),
+			Timestamp:      float64(time.Now().Unix()),
+			ConversationID: conversationID,
+		}, 0)
+		if err != nil {
+			t.Fatal("error caching chat", id, err)
+		}
+	}
+}
+
+func chatIDs(chats []model.Chat) []string {
+	ids := make([]string, len(chats))
+	for i, c := range chats {
+		ids[i] = c.ID
+	}
+	return ids
+}
+
+func TestFetchConversationPage(t *testing.T) {
+	setupRedis(t)
+	cacheChats(t, "1", 1, 2, 3, 4, 5)
+	// Chats of other conversations stay out of the page
+	cacheChats(t, "2", 6)
+
+	res, err := FetchConversationPage("1", model.ChatRange{BeforeID: 5, Limit: 2})
+	if err != nil {
+		t.Fatal("error in fetch", err)
+	}
+	if got := fmt.Sprint(chatIDs(res)); got != "[4 3]" {
+		t.Errorf("expected [4 3] before 5, got %s", got)
+	}
+
+	res, err = FetchConversationPage("1", model.ChatRange{AfterID: 2, Limit: 10, Ascending: true})
+	if err != nil {
+		t.Fatal("error in fetch", err)
+	}
+	if got := fmt.Sprint(chatIDs(res)); got != "[3 4 5]" {
+		t.Errorf("expected [3 4 5] after 2, got %s", got)
+	}
+}
+
+func TestConversationFloorDropsExpiredChats(t *testing.T) {
+	mr := setupRedis(t)
+	t.Setenv("REDIS_CHAT_RETENTION", "1h")
+
+	cacheChats(t, "1", 1)
+	mr.FastForward(30 * time.Minute)
+	cacheChats(t, "1", 2, 3)
+	mr.FastForward(40 * time.Minute)
+
+	floor, err := ConversationFloor("1")
+	if err != nil {
+		t.Fatal("error fetching floor", err)
+	}
+	if floor != 2 {
+		t.Errorf("expected floor 2 once chat 1 expired, got %d", floor)
+	}
+
+	members, _ := redisClient.ZRange(redisClient.Context(), conversationChatZKey("1"), 0, -1).Result()
+	if fmt.Sprint(members) != "[2 3]" {
+		t.Errorf("expected expired IDs to be dropped from the index, got %v", members)
+	}
+}
+
+func TestChatRetention(t *testing.T) {
+	if d := chatRetention(); d != defaultChatRetention {
+		t.Errorf("expected default retention, got %s", d)
+	}
+
+	t.Setenv("REDIS_CHAT_RETENTION", "48h")
+	if d := chatRetention(); d != 48*time.Hour {
+		t.Errorf("expected retention from environment, got %s", d)
+	}
+}
+
+func TestConversationChatRetention(t *testing.T) {
+	mr := setupRedis(t)
+	t.Setenv("REDIS_CHAT_RETENTION", "1h")
+
+	err := CacheChat(&model.Chat{ID: "1", From: "user1", To: "user2", ConversationID: "1"}, 30*24*time.Hour)
+	if err != nil {
+		t.Fatal("error caching chat", err)
+	}
+	cacheChats(t, "2", 2)
+
+	// A conversation's own retention overrides the default, and only for it
+	if ttl := mr.TTL(chatKey("1")); ttl != 30*24*time.Hour {
+		t.Errorf("expected chat 1 to be kept for 30 days, got %s", ttl)
+	}
+	if ttl := mr.TTL(chatKey("2")); ttl != time.Hour {
+		t.Errorf("expected chat 2 to be kept for the default hour, got %s", ttl)
+	}
+
+	// What a user hides is remembered as long as the chats stay cached
+	HideChat("1", "user1", "1")
+	if ttl := mr.TTL(hiddenChatSKey("1", "user1")); ttl != 30*24*time.Hour {
+		t.Errorf("expected the hidden chats to be kept for 30 days, got %s", ttl)
+	}
+}
+
+func TestCachedChatsExpireInIDOrder(t *testing.T) {
+	mr := setupRedis(t)
+	t.Setenv("REDIS_CHAT_RETENTION", "2h")
+	cacheChats(t, "1", 1)
+
+	// A shorter window doesn't let a newer chat expire before an older one
+	t.Setenv("REDIS_CHAT_RETENTION", "1h")
+	cacheChats(t, "1", 3)
+	if ttl := mr.TTL(chatKey("3")); ttl != 2*time.Hour {
+		t.Errorf("expected chat 3 to live as long as chat 1, got %s", ttl)
+	}
+
+	// Nor does an older chat cached late outlive a newer one
+	mr.FastForward(30 * time.Minute)
+	t.Setenv("REDIS_CHAT_RETENTION", "2h")
+	cacheChats(t, "1", 2)
+	if ttl := mr.TTL(chatKey("2")); ttl != 90*time.Minute {
+		t.Errorf("expected chat 2 to expire with chat 3, got %s", ttl)
+	}
+
+	mr.FastForward(90 * time.Minute)
+	if floor, _ := ConversationFloor("1"); floor != 0 {
+		t.Errorf("expected every chat to have expired, got floor %d", floor)
+	}
+}
+
+func TestInvalidateConversation(t *testing.T) {
+	setupRedis(t)
+	cacheChats(t, "1", 1, 2)
+
+	// Chat 3 couldn't be cached, so the tier starts over above it
+	InvalidateConversation("1")
+	cacheChats(t, "1", 4)
+
+	if floor, _ := ConversationFloor("1"); floor != 4 {
+		t.Errorf("expected the floor to move above the missing chat, got %d", floor)
+	}
+}
+
+func TestPendingQueue(t *testing.T) {
+	setupRedis(t)
+	now := float64(time.Now().Unix())
+
+	// Chats queued within the same millisecond keep the order of their IDs
+	for _, id := range []string{"9", "10", "11"} {
+		EnqueuePending("user2", &model.Chat{ID: id, From: "user1", To: "user2", Timestamp: now})
+	}
+
+	chats, err := FetchPending("user2")
+	if err != nil {
+		t.Fatal("error fetching pending", err)
+	}
+	if got := fmt.Sprint(chatIDs(chats)); got != "[9 10 11]" {
+		t.Errorf("expected pending [9 10 11], got %s", got)
+	}
+
+	AckPending("user2", "9")
+	chats, _ = FetchPending("user2")
+	if got := fmt.Sprint(chatIDs(chats)); got != "[10 11]" {
+		t.Errorf("expected pending [10 11] after ack, got %s", got)
+	}
+}
+
+func TestPendingQueueLimits(t *testing.T) {
+	mr := setupRedis(t)
+	t.Setenv("PENDING_MAX", "3")
+	t.Setenv("PENDING_TTL", "1h")
+	now := float64(time.Now().Unix())
+
+	for id := 1; id <= 5; id++ {
+		EnqueuePending("user2", &model.Chat{ID: fmt.Sprint(id), From: "user1", To: "user2", Timestamp: now})
+	}
+
+	// A full queue drops its oldest chats, payloads included
+	chats, _ := FetchPending("user2")
+	if got := fmt.Sprint(chatIDs(chats)); got != "[3 4 5]" {
+		t.Errorf("expected pending [3 4 5], got %s", got)
+	}
+	if payloads, _ := mr.HKeys(pendingHKey("user2")); len(payloads) != 3 {
+		t.Errorf("expected 3 queued payloads, got %v", payloads)
+	}
+
+	// Chats past the age limit are dropped when the next one is queued
+	old := now - (2 * time.Hour).Seconds()
+	mr.HSet(pendingHKey("user2"), "3", fmt.Sprintf(`{"id":"3","timestamp":%f}`, old))
+	EnqueuePending("user2", &model.Chat{ID: "6", From: "user1", To: "user2", Timestamp: now})
+	chats, _ = FetchPending("user2")
+	if got := fmt.Sprint(chatIDs(chats)); got != "[4 5 6]" {
+		t.Errorf("expected pending [4 5 6], got %s", got)
+	}
+
+	// An abandoned queue expires
+	if ttl := mr.TTL(pendingZKey("user2")); ttl != time.Hour {
+		t.Errorf("expected the queue to expire in an hour, got %s", ttl)
+	}
+	mr.FastForward(time.Hour)
+	if chats, _ := FetchPending("user2"); len(chats) != 0 {
+		t.Errorf("expected the queue to have expired, got %d chats", len(chats))
+	}
+}
+
+func TestUpdateChatStatus(t *testing.T) {
+	setupRedis(t)
+	cacheChats(t, "1", 1)
+
+	UpdateChatStatus("1", model.ChatStatusRead)
+	// A late delivered receipt must not move the chat backwards
+	UpdateChatStatus("1", model.ChatStatusDelivered)
+
+	chats, _ := FetchConversationPage("1", model.ChatRange{})
+	if len(chats) != 1 || chats[0].Status != model.ChatStatusRead {
+		t.Errorf("expected chat to stay read, got %+v", chats)
+	}
+}
+
+func TestPatchChatKeepsConcurrentWrites(t *testing.T) {
+	setupRedis(t)
+	cacheChats(t, "1", 1)
+
+	// An edit lands between the read and the write of the first attempt
+	edited := false
+	err := patchChat("1", func(c *model.Chat) bool {
+		if !edited {
+			edited = true
+			RefreshChat(&model.Chat{ID: "1", From: "user1", To: "user2", Msg: "edited", ConversationID: "1"})
+		}
+		c.Status = model.ChatStatusRead
+		return true
+	})
+	if err != nil {
+		t.Fatal("error patching chat", err)
+	}
+
+	chats, _ := FetchConversationPage("1", model.ChatRange{})
+	if len(chats) != 1 || chats[0].Msg != "edited" || chats[0].Status != model.ChatStatusRead {
+		t.Errorf("expected the edit and the status to both stick, got %+v", chats)
+	}
+}
+
+func TestRefreshChat(t *testing.T) {
+	mr := setupRedis(t)
+	cacheChats(t, "1", 1)
+	ttl := mr.TTL(chatKey("1"))
+
+	RefreshChat(&model.Chat{ID: "1", Msg: "edited", ConversationID: "1", Edited: true})
+	// Chats that aren't cached stay out of Redis
+	RefreshChat(&model.Chat{ID: "2", Msg: "edited", ConversationID: "1"})
+
+	chats, _ := FetchConversationPage("1", model.ChatRange{})
+	if len(chats) != 1 || chats[0].Msg != "edited" || !chats[0].Edited {
+		t.Errorf("expected the edited chat, got %+v", chats)
+	}
+	if got := mr.TTL(chatKey("1")); got != ttl {
+		t.Errorf("expected expiry %s to be kept, got %s", ttl, got)
+	}
+	if mr.Exists(chatKey("2")) {
+		t.Error("expected uncached chat to stay out of Redis")
+	}
+}
+
+func TestFetchConversationPageSkipsHiddenChats(t *testing.T) {
+	setupRedis(t)
+	cacheChats(t, "1", 1, 2, 3, 4, 5)
+	HideChat("1", "user1", "5")
+	HideChat("1", "user1", "4")
+
+	// The page still fills up past the hidden chats
+	res, err := FetchConversationPage("1", model.ChatRange{Limit: 2, Viewer: "user1"})
+	if err != nil {
+		t.Fatal("error in fetch", err)
+	}
+	if got := fmt.Sprint(chatIDs(res)); got != "[3 2]" {
+		t.Errorf("expected [3 2] for user1, got %s", got)
+	}
 
-	for i := 4; i < 20; i++ {
-		UpdateContactList("user1", fmt.Sprintf("user%d", i))
-		time.Sleep(time.Second * 2)
+	res, _ = FetchConversationPage("1", model.ChatRange{Limit: 2, Viewer: "user2"})
+	if got := fmt.Sprint(chatIDs(res)); got != "[5 4]" {
+		t.Errorf("expected [5 4] for user2, got %s", got)
 	}
 }
 
-func TestFetchContactList(t *testing.T) {
-	InitialiseRedis()
-	defer redisClient.Close()
+func TestFetchPresence(t *testing.T) {
+	setupRedis(t)
+
+	SetSessionPresence("user1", "s1", model.PresenceAway)
+	SetSessionPresence("user2", "s2", model.PresenceOnline)
+	// The instance holding user2's socket stopped beating a while ago
+	stale := time.Now().Add(-PresenceTimeout - time.Minute).Unix()
+	redisClient.HSet(redisClient.Context(), presenceHKey("user2"), "heartbeat", fmt.Sprint(stale))
 
-	res, err := FetchContactList("user1")
+	presence, err := FetchPresence("user1", "user2", "user3")
 	if err != nil {
-		t.Error("error in fetch", err)
-		return
+		t.Fatal("error fetching presence", err)
 	}
 
-	t.Log("success", res)
+	want := []string{model.PresenceAway, model.PresenceOffline, model.PresenceOffline}
+	for i, p := range presence {
+		if p.Status != want[i] {
+			t.Errorf("expected %s to be %s, got %s", p.Username, want[i], p.Status)
+		}
+	}
+	if presence[1].LastSeen == 0 {
+		t.Error("expected a last-seen time for user2")
+	}
 }
 
-func TestFetchChatBetween(t *testing.T) {
-	InitialiseRedis()
-	defer redisClient.Close()
+func TestSessionPresenceAcrossInstances(t *testing.T) {
+	setupRedis(t)
 
-	res, err := FetchChatBetween("user1", "user2", "0", "+inf")
+	// user1 has a device on each of two instances
+	if before, after, _ := SetSessionPresence("user1", "a", model.PresenceOnline); before != model.PresenceOffline || after != model.PresenceOnline {
+		t.Errorf("expected user1 to come online, got %s -> %s", before, after)
+	}
+	SetSessionPresence("user1", "b", model.PresenceAway)
 
+	// Closing one device leaves them connected through the other
+	if before, after, _ := SetSessionPresence("user1", "a", model.PresenceOffline); before != model.PresenceOnline || after != model.PresenceAway {
+		t.Errorf("expected user1 to stay connected as away, got %s -> %s", before, after)
+	}
+	presence, _ := FetchPresence("user1")
+	if presence[0].Status != model.PresenceAway {
+		t.Errorf("expected user1 to be away, got %s", presence[0].Status)
+	}
+
+	// Devices connect to both instances, then the one holding b and d stops beating
+	SetSessionPresence("user1", "c", model.PresenceAway)
+	SetSessionPresence("user1", "d", model.PresenceOnline)
+	stale := time.Now().Add(-PresenceTimeout - time.Minute).Unix()
+	redisClient.HSet(redisClient.Context(), sessionPresenceHKey("user1"),
+		"b", fmt.Sprintf("away:%d", stale), "d", fmt.Sprintf("online:%d", stale))
+
+	changed, err := TouchPresence(map[string][]string{"user1": {"c"}})
 	if err != nil {
-		t.Error("error in fetch", err)
-		return
+		t.Fatal("error touching presence", err)
+	}
+	if changed["user1"] != model.PresenceAway {
+		t.Errorf("expected user1 to drop to away with the stale device gone, got %v", changed)
 	}
 
-	t.Log("success", res)
+	// The last device going away takes the user offline
+	if _, after, _ := SetSessionPresence("user1", "c", model.PresenceOffline); after != model.PresenceOffline {
+		t.Errorf("expected user1 to be offline, got %s", after)
+	}
+}
+
+func TestSessions(t *testing.T) {
+	setupRedis(t)
+
+	AddSession("user1", &model.Session{ID: "phone", Device: "phone"})
+	AddSession("user1", &model.Session{ID: "laptop", Device: "laptop"})
+	AddSession("user1", &model.Session{ID: "tablet", Device: "tablet"})
+
+	// The tablet's instance went away without cleaning up
+	stale := time.Now().Add(-PresenceTimeout - time.Minute).Unix()
+	redisClient.ZAdd(redisClient.Context(), sessionZKey("user1"), &redis.Z{Score: float64(stale), Member: "tablet"})
+	RemoveSession("user1", "laptop")
+	// A late heartbeat must not bring a removed session back
+	TouchSessions(map[string][]string{"user1": {"phone", "laptop"}})
+
+	sessions, err := FetchSessions("user1")
+	if err != nil {
+		t.Fatal("error fetching sessions", err)
+	}
+	if len(sessions) != 1 || sessions[0].ID != "phone" || sessions[0].LastActive == 0 {
+		t.Errorf("expected only the phone session, got %+v", sessions)
+	}
+	if redisClient.HExists(redisClient.Context(), sessionHKey("user1"), "tablet").Val() {
+		t.Error("expected stale session details to be dropped")
+	}
 }
 
-func TestIndexExist(t *testing.T) {
-	InitialiseRedis()
-	defer redisClient.Close()
-	res, err := redisClient.Do(context.Background(),
-		"FT._LIST",
-	).Result()
+func TestTokenRevocation(t *testing.T) {
+	setupRedis(t)
+	issued := time.Now().Add(-time.Minute)
+
+	if revoked, err := IsTokenRevoked("a", "user1", issued); err != nil || revoked {
+		t.Fatalf("expected a fresh token to be valid, got %v %v", revoked, err)
+	}
 
-	fmt.Printf("%T\n", res.([]interface{})[0])
-	t.Log(res, err)
-	fmt.Println(res.([]interface{})[0])
+	DenyToken("a", time.Now().Add(time.Hour))
+	if revoked, _ := IsTokenRevoked("a", "user1", issued); !revoked {
+		t.Error("expected denied jti to be revoked")
+	}
+	if revoked, _ := IsTokenRevoked("b", "user1", issued); revoked {
+		t.Error("expected other tokens to stay valid")
+	}
+
+	RevokeTokensBefore("user1", time.Hour)
+	if revoked, _ := IsTokenRevoked("b", "user1", issued); !revoked {
+		t.Error("expected tokens issued before the cutoff to be revoked")
+	}
+	if revoked, _ := IsTokenRevoked("c", "user1", time.Now().Add(time.Minute)); revoked {
+		t.Error("expected tokens issued after the cutoff to stay valid")
+	}
+	if revoked, _ := IsTokenRevoked("b", "user2", issued); revoked {
+		t.Error("expected other users' tokens to stay valid")
+	}
 }
 
-// func TestDropIndex(t *testing.T) {
-// 	InitialiseRedis()
-// 	defer redisClient.Close()
-// 	res, err := redisClient.Do(context.Background(),
-// 		"FT.DROP",
-// 		key.ChatIndex(),
-// 	).Result()
+func TestTokenCutoffIsPreciseToTheMillisecond(t *testing.T) {
+	mr := setupRedis(t)
 
-// 	t.Log(res, err)
+	RevokeTokensBefore("user1", time.Hour)
+	raw, _ := mr.Get(tokenCutoffKey("user1"))
+	ms, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		t.Fatal("expected the cutoff in milliseconds, got", raw)
+	}
+	cutoff := time.UnixMilli(ms)
+
+	// A login within the same second as the logout-all keeps its token
+	if revoked, _ := IsTokenRevoked("a", "user1", cutoff.Add(time.Millisecond)); revoked {
+		t.Error("expected a token issued just after the cutoff to stay valid")
+	}
+	if revoked, _ := IsTokenRevoked("b", "user1", cutoff); !revoked {
+		t.Error("expected a token issued at the cutoff to be revoked")
+	}
+}
 
-// }
+func TestUnreadCounters(t *testing.T) {
+	mr := setupRedis(t)
 
-// func TestFetchChat(t *testing.T) {
-// 	// InitialiseRedis()
-// 	// defer redisClient.Close()
+	// Counts that aren't cached are left for PostgreSQL to load
+	if err := IncrementUnread("1", "user1"); err != nil {
+		t.Fatal("error incrementing unread", err)
+	}
+	if mr.Exists(unreadHKey("user1")) {
+		t.Fatal("expected uncached counts to stay uncached")
+	}
 
-// 	c := redisearch.NewClient(fmt.Sprintf("%v:%v", os.Getenv("REDIS_HOST"), os.Getenv("REDIS_PORT")), "myIndex")
+	mr.HSet(unreadHKey("user1"), "", "0")
+	IncrementUnread("1", "user1")
+	IncrementUnread("1", "user1")
+	IncrementUnread("2", "user1")
 
-// 	docs, total, err := c.Search(redisearch.NewQuery("hello world"))
+	counts, err := FetchUnreadCounts("user1")
+	if err != nil {
+		t.Fatal("error fetching unread counts", err)
+	}
+	if counts["1"] != 2 || counts["2"] != 1 || len(counts) != 2 || TotalUnread(counts) != 3 {
+		t.Errorf("expected 2 and 1 unread, got %v", counts)
+	}
 
-// 	fmt.Println(docs, total, err)
-// }
+	SetUnread("user1", "1", 0)
+	SetUnread("user2", "1", 5)
+	if counts, _ := FetchUnreadCounts("user1"); TotalUnread(counts) != 1 {
+		t.Errorf("expected 1 unread after reading, got %v", counts)
+	}
+	if mr.Exists(unreadHKey("user2")) {
+		t.Error("expected SetUnread to leave uncached counts alone")
+	}
 
-// func TestCreateIndex(t *testing.T) {
-// 	InitialiseRedis()
-// 	defer redisClient.Close()
+	ResetUnread("user1")
+	if mr.Exists(unreadHKey("user1")) {
+		t.Error("expected reset to drop the cached counts")
+	}
+}
 
-// 	res, err := redisClient.Do(context.Background(),
-// 		"FT.CREATE",
-// 		key.ChatIndex(),
-// 		"ON", "JSON",
-// 		"PREFIX", "1", "chat#",
-// 		"SCHEMA", "$.from", "AS", "from", "TAG",
-// 		"$.to", "AS", "to", "TAG",
-// 		"$.timestamp", "AS", "timestamp", "NUMERIC",
-// 	).Result()
+func TestLinkPreviews(t *testing.T) {
+	mr := setupRedis(t)
+	cacheChats(t, "1", 1)
 
-// 	fmt.Println(res, err)
-// }
+	if _, ok, err := FetchCachedPreview("https://example.com"); ok || err != nil {
+		t.Fatalf("expected a miss, got ok=%v err=%v", ok, err)
+	}
 
-func TestCreateSortableIndex(t *testing.T) {
-	InitialiseRedis()
-	defer redisClient.Close()
+	preview := &model.LinkPreview{URL: "https://example.com", Title: "Example"}
+	CachePreview("https://example.com", preview)
+	CachePreview("https://down.example.com", nil)
 
-	res, err := redisClient.Do(context.Background(),
-		"FT.CREATE",
-		"idx#chats",
-		"ON", "JSON",
-		"PREFIX", "1", "chat#",
-		"SCHEMA", "$.from", "AS", "from", "TAG",
-		"$.to", "AS", "to", "TAG",
-		"$.timestamp", "AS", "timestamp", "NUMERIC", "SORTABLE",
-	).Result()
+	got, ok, _ := FetchCachedPreview("https://example.com")
+	if !ok || got == nil || got.Title != "Example" {
+		t.Errorf("expected the cached preview, got %+v, %v", got, ok)
+	}
+	// A URL without a preview is cached too, for less time
+	got, ok, _ = FetchCachedPreview("https://down.example.com")
+	if !ok || got != nil {
+		t.Errorf("expected a cached miss, got %+v, %v", got, ok)
+	}
+	if ttl := mr.TTL(previewKey("https://down.example.com")); ttl != missedPreviewTTL {
+		t.Errorf("expected a miss to expire in %s, got %s", missedPreviewTTL, ttl)
+	}
 
-	fmt.Println(res, err)
+	SetChatPreview("1", "message 1", preview)
+	chats, _ := FetchConversationPage("1", model.ChatRange{})
+	if len(chats) != 1 || chats[0].Preview == nil || chats[0].Preview.Title != "Example" {
+		t.Errorf("expected the cached chat to carry the preview, got %+v", chats)
+	}
 }
 
-// func TestCreateIndexFrom(t *testing.T) {
-// 	InitialiseRedis()
-// 	defer redisClient.Close()
+func TestSetChatPreviewSkipsChangedChats(t *testing.T) {
+	setupRedis(t)
+	cacheChats(t, "1", 1, 2)
+	preview := &model.LinkPreview{URL: "https://example.com", Title: "Example"}
 
-// 	res, err := redisClient.Do(context.Background(),
-// 		"FT.CREATE",
-// 		key.CreateChatIndex()+":from",
-// 		"ON", "JSON",
-// 		"PREFIX", "1", "chat#",
-// 		"SCHEMA", "$.from", "AS", "from", "TAG",
-// 	).Result()
+	// The chat was edited while the page was fetched
+	RefreshChat(&model.Chat{ID: "1", From: "user1", To: "user2", Msg: "edited", ConversationID: "1"})
+	SetChatPreview("1", "message 1", preview)
 
-// 	fmt.Println(res, err)
-// }
+	// Or deleted for everyone
+	RefreshChat(&model.Chat{ID: "2", From: "user1", To: "user2", ConversationID: "1", Deleted: true})
+	SetChatPreview("2", "message 2", preview)
+
+	chats, _ := FetchConversationPage("1", model.ChatRange{})
+	for _, c := range chats {
+		if c.Preview != nil {
+			t.Errorf("expected chat %s to be left alone, got %+v", c.ID, c.Preview)
+		}
+	}
+	if len(chats) != 2 || chats[1].Msg != "edited" {
+		t.Errorf("expected the edit to stay, got %+v", chats)
+	}
+}
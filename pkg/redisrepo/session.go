@@ -0,0 +1,143 @@
+package redisrepo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"gochatapp/model"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// AddSession records a newly connected device of a user
+func AddSession(username string, s *model.Session) error {
+	by, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	_, err = redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, sessionHKey(username), s.ID, by)
+		pipe.ZAdd(ctx, sessionZKey(username), &redis.Z{Score: float64(time.Now().Unix()), Member: s.ID})
+		return nil
+	})
+	if err != nil {
+		log.Println("Error adding session for", username, err)
+	}
+	return err
+}
+
+// RemoveSession forgets a device of a user
+func RemoveSession(username, id string) error {
+	ctx := context.Background()
+	_, err := redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HDel(ctx, sessionHKey(username), id)
+		pipe.ZRem(ctx, sessionZKey(username), id)
+		return nil
+	})
+	if err != nil {
+		log.Println("Error removing session", id, "for", username, err)
+	}
+	return err
+}
+
+// FetchSession returns one session of a user, or nil if it's gone
+func FetchSession(username, id string) (*model.Session, error) {
+	by, err := redisClient.HGet(context.Background(), sessionHKey(username), id).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		log.Println("Error fetching session", id, "for", username, err)
+		return nil, err
+	}
+
+	var s model.Session
+	if err := json.Unmarshal(by, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// TouchSessions renews the heartbeat of live sessions, keyed by username
+func TouchSessions(sessions map[string][]string) error {
+	if len(sessions) == 0 {
+		return nil
+	}
+
+	ctx := context.Background()
+	now := float64(time.Now().Unix())
+	_, err := redisClient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for username, ids := range sessions {
+			zs := make([]*redis.Z, len(ids))
+			for i, id := range ids {
+				zs[i] = &redis.Z{Score: now, Member: id}
+			}
+			// XX so a heartbeat racing a revocation doesn't bring the session back
+			pipe.ZAddXX(ctx, sessionZKey(username), zs...)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println("Error renewing session heartbeats:", err)
+	}
+	return err
+}
+
+// FetchSessions returns the connected devices of a user, most recently active
+// first. Sessions whose instance stopped beating are dropped on the way.
+func FetchSessions(username string) ([]model.Session, error) {
+	ctx := context.Background()
+	zkey, hkey := sessionZKey(username), sessionHKey(username)
+
+	cutoff := time.Now().Add(-PresenceTimeout).Unix()
+	stale, err := redisClient.ZRangeByScore(ctx, zkey, &redis.ZRangeBy{
+		Min: "-inf",
+		Max: fmt.Sprintf("(%d", cutoff),
+	}).Result()
+	if err != nil {
+		log.Println("Error fetching stale sessions for", username, err)
+		return nil, err
+	}
+	for _, id := range stale {
+		RemoveSession(username, id)
+	}
+
+	res, err := redisClient.ZRevRangeWithScores(ctx, zkey, 0, -1).Result()
+	if err != nil {
+		log.Println("Error fetching sessions for", username, err)
+		return nil, err
+	}
+	if len(res) == 0 {
+		return []model.Session{}, nil
+	}
+
+	ids := make([]string, len(res))
+	for i, z := range res {
+		ids[i] = z.Member.(string)
+	}
+	payloads, err := redisClient.HMGet(ctx, hkey, ids...).Result()
+	if err != nil {
+		log.Println("Error fetching session details for", username, err)
+		return nil, err
+	}
+
+	sessions := make([]model.Session, 0, len(payloads))
+	for i, p := range payloads {
+		str, ok := p.(string)
+		if !ok {
+			continue
+		}
+		var s model.Session
+		if err := json.Unmarshal([]byte(str), &s); err != nil {
+			continue
+		}
+		s.LastActive = res[i].Score
+		sessions = append(sessions, s)
+	}
+	return sessions, nil
+}
@@ -0,0 +1,61 @@
+package redisrepo
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// DenyToken revokes an access token by its jti until it would have expired
+func DenyToken(jti string, expiresAt time.Time) error {
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+	err := redisClient.Set(context.Background(), deniedTokenKey(jti), 1, ttl).Err()
+	if err != nil {
+		log.Println("Error denying token", jti, err)
+	}
+	return err
+}
+
+// RevokeTokensBefore revokes every access token issued to a user up to now.
+// The cutoff is kept in milliseconds, like iat, so a login right after it
+// isn't caught, and only has to outlive the longest-lived access token.
+func RevokeTokensBefore(username string, ttl time.Duration) error {
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	err := redisClient.Set(context.Background(), tokenCutoffKey(username), now, ttl).Err()
+	if err != nil {
+		log.Println("Error revoking tokens of", username, err)
+	}
+	return err
+}
+
+// IsTokenRevoked reports whether an access token was revoked, on its own or
+// along with every token its user had been issued
+func IsTokenRevoked(jti, username string, issuedAt time.Time) (bool, error) {
+	ctx := context.Background()
+
+	var denied *redis.IntCmd
+	var cutoff *redis.StringCmd
+	_, err := redisClient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		denied = pipe.Exists(ctx, deniedTokenKey(jti))
+		cutoff = pipe.Get(ctx, tokenCutoffKey(username))
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		log.Println("Error checking token revocation:", err)
+		return false, err
+	}
+
+	if denied.Val() > 0 {
+		return true, nil
+	}
+	if before, err := strconv.ParseInt(cutoff.Val(), 10, 64); err == nil && issuedAt.UnixMilli() <= before {
+		return true, nil
+	}
+	return false, nil
+}
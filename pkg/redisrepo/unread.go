@@ -0,0 +1,131 @@
+package redisrepo
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+
+	"gochatapp/pkg/db"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// unreadTTL is how long a user's unread counts stay cached without a change
+const unreadTTL = 24 * time.Hour
+
+// incrementUnread bumps a cached count, leaving users whose counts aren't
+// cached to be loaded from PostgreSQL, which already has the new message
+var incrementUnread = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 1 then
+	redis.call("HINCRBY", KEYS[1], ARGV[1], 1)
+	redis.call("EXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// IncrementUnread counts a new message in a conversation against each
+// recipient. The script is sent in full, as EVALSHA can't fall back to EVAL
+// inside a pipeline.
+func IncrementUnread(conversationID string, usernames ...string) error {
+	ctx := context.Background()
+	ttl := int(unreadTTL.Seconds())
+	_, err := redisClient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, username := range usernames {
+			incrementUnread.Eval(ctx, pipe, []string{unreadHKey(username)}, conversationID, ttl)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println("Error incrementing unread counts of", conversationID, err)
+	}
+	return err
+}
+
+// setUnread overwrites a cached count; a missing key is left alone, since
+// a hash with just this count would look like all of the user's counts
+var setUnread = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 1 then
+	redis.call("HSET", KEYS[1], ARGV[1], ARGV[2])
+end
+return 0
+`)
+
+// SetUnread records a user's unread count of a conversation after they read
+// it, if their counts are cached
+func SetUnread(username, conversationID string, n int) error {
+	err := setUnread.Run(context.Background(), redisClient, []string{unreadHKey(username)}, conversationID, n).Err()
+	if err != nil {
+		log.Println("Error setting unread count of", username, err)
+	}
+	return err
+}
+
+// ResetUnread drops the cached counts of users, so they are counted afresh
+// from PostgreSQL, e.g. after messages were deleted
+func ResetUnread(usernames ...string) error {
+	if len(usernames) == 0 {
+		return nil
+	}
+	keys := make([]string, len(usernames))
+	for i, username := range usernames {
+		keys[i] = unreadHKey(username)
+	}
+	err := redisClient.Del(context.Background(), keys...).Err()
+	if err != nil {
+		log.Println("Error resetting unread counts:", err)
+	}
+	return err
+}
+
+// FetchUnreadCounts returns a user's unread count per conversation, loading
+// them from PostgreSQL, the source of truth, when they aren't cached
+func FetchUnreadCounts(username string) (map[string]int, error) {
+	ctx := context.Background()
+	key := unreadHKey(username)
+
+	cached, err := redisClient.HGetAll(ctx, key).Result()
+	if err != nil {
+		log.Println("Error fetching unread counts of", username, err)
+	}
+	if err == nil && len(cached) > 0 {
+		counts := make(map[string]int, len(cached))
+		for conversationID, v := range cached {
+			if n, err := strconv.Atoi(v); err == nil && n > 0 {
+				counts[conversationID] = n
+			}
+		}
+		return counts, nil
+	}
+
+	counts, err := db.FetchUnreadCounts(db.DB, username)
+	if err != nil {
+		return nil, err
+	}
+
+	// An empty hash can't exist, so a placeholder field marks the counts as
+	// cached when there's nothing unread
+	fields := map[string]interface{}{"": 0}
+	for conversationID, n := range counts {
+		fields[conversationID] = n
+	}
+	_, err = redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, key)
+		pipe.HSet(ctx, key, fields)
+		pipe.Expire(ctx, key, unreadTTL)
+		return nil
+	})
+	if err != nil {
+		log.Println("Error caching unread counts of", username, err)
+	}
+	return counts, nil
+}
+
+// TotalUnread sums unread counts
+func TotalUnread(counts map[string]int) int {
+	total := 0
+	for _, n := range counts {
+		total += n
+	}
+	return total
+}
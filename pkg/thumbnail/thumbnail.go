@@ -0,0 +1,99 @@
+package thumbnail
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	_ "image/gif" // register the GIF decoder
+	"image/jpeg"
+	_ "image/png" // register the PNG decoder
+	"io"
+)
+
+// ErrTooLarge is returned for images whose dimensions would take too much
+// memory to decode
+var ErrTooLarge = errors.New("image dimensions too large")
+
+// MaxPixels bounds the images Generate decodes, since a small file can claim
+// huge dimensions
+const MaxPixels = 40 * 1000 * 1000
+
+// Generate decodes a JPEG, PNG or GIF (its first frame) and returns a JPEG
+// scaled down to fit in a maxSide square, along with the size of the
+// original. Images that already fit are re-encoded at their own size.
+func Generate(r io.Reader, maxSide int) ([]byte, image.Point, error) {
+	var buf bytes.Buffer
+	cfg, _, err := image.DecodeConfig(io.TeeReader(r, &buf))
+	if err != nil {
+		return nil, image.Point{}, err
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > MaxPixels {
+		return nil, image.Point{}, ErrTooLarge
+	}
+
+	src, _, err := image.Decode(io.MultiReader(&buf, r))
+	if err != nil {
+		return nil, image.Point{}, err
+	}
+	size := src.Bounds().Size()
+
+	w, h := fit(size.X, size.Y, maxSide)
+	thumb := scale(src, w, h)
+
+	var out bytes.Buffer
+	if err := jpeg.Encode(&out, thumb, &jpeg.Options{Quality: 80}); err != nil {
+		return nil, image.Point{}, err
+	}
+	return out.Bytes(), size, nil
+}
+
+// fit returns the size of a w x h image scaled down to fit in a maxSide
+// square, keeping its aspect ratio
+func fit(w, h, maxSide int) (int, int) {
+	if w <= maxSide && h <= maxSide {
+		return w, h
+	}
+	if w >= h {
+		return maxSide, max(1, h*maxSide/w)
+	}
+	return max(1, w*maxSide/h), maxSide
+}
+
+// scale resizes src to w x h by averaging the source pixels under each
+// destination pixel, which keeps downscaled images smooth. Transparent
+// areas are flattened onto white, since JPEG has no alpha.
+func scale(src image.Image, w, h int) *image.RGBA {
+	b := src.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+
+	for y := 0; y < h; y++ {
+		y0 := b.Min.Y + y*sh/h
+		y1 := max(y0+1, b.Min.Y+(y+1)*sh/h)
+		for x := 0; x < w; x++ {
+			x0 := b.Min.X + x*sw/w
+			x1 := max(x0+1, b.Min.X+(x+1)*sw/w)
+
+			var r, g, bl, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					// Colours are premultiplied, so add white for what's transparent
+					white := 0xffff - uint64(ca)
+					r += uint64(cr) + white
+					g += uint64(cg) + white
+					bl += uint64(cb) + white
+					n++
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8(r / n >> 8),
+				G: uint8(g / n >> 8),
+				B: uint8(bl / n >> 8),
+				A: 0xff,
+			})
+		}
+	}
+	return dst
+}
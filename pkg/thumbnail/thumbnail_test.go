@@ -0,0 +1,55 @@
+package thumbnail
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	// A 400x200 image, red on the left half and blue on the right
+	src := image.NewRGBA(image.Rect(0, 0, 400, 200))
+	for y := 0; y < 200; y++ {
+		for x := 0; x < 400; x++ {
+			c := color.RGBA{R: 255, A: 255}
+			if x >= 200 {
+				c = color.RGBA{B: 255, A: 255}
+			}
+			src.SetRGBA(x, y, c)
+		}
+	}
+	var in bytes.Buffer
+	if err := png.Encode(&in, src); err != nil {
+		t.Fatal(err)
+	}
+
+	data, size, err := Generate(&in, 100)
+	if err != nil {
+		t.Fatal("error generating thumbnail", err)
+	}
+	if size != image.Pt(400, 200) {
+		t.Errorf("expected the original size 400x200, got %v", size)
+	}
+
+	thumb, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal("thumbnail is not a JPEG", err)
+	}
+	if got := thumb.Bounds().Size(); got != image.Pt(100, 50) {
+		t.Errorf("expected a 100x50 thumbnail, got %v", got)
+	}
+
+	r, _, b, _ := thumb.At(10, 25).RGBA()
+	if r>>8 < 200 || b>>8 > 60 {
+		t.Errorf("expected the left of the thumbnail to stay red, got r=%d b=%d", r>>8, b>>8)
+	}
+}
+
+func TestGenerateRejectsNonImages(t *testing.T) {
+	if _, _, err := Generate(bytes.NewReader([]byte("not an image")), 100); err == nil {
+		t.Error("expected an error for data that isn't an image")
+	}
+}
@@ -0,0 +1,75 @@
+package ws
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"gochatapp/model"
+	"gochatapp/pkg/db"
+	"gochatapp/utils"
+)
+
+// maxChatAttachments is how many uploads one chat can carry
+const maxChatAttachments = 10
+
+var errInvalidAttachment = errors.New("attachment_ids must be unsent uploads of the sender")
+
+// attachmentURLTTL is how long the download URLs handed out for attachments
+// work, overridable with ATTACHMENT_URL_TTL
+var attachmentURLTTL = utils.DurationFromEnv("ATTACHMENT_URL_TTL", 24*time.Hour)
+
+// resolveAttachments swaps the attachment IDs a chat was sent with for the
+// attachments themselves, checking that the sender uploaded them and hasn't
+// sent them already
+func resolveAttachments(c *model.Chat) error {
+	ids := c.AttachmentIDs
+	c.AttachmentIDs, c.Attachments = nil, nil
+	if len(ids) == 0 {
+		return nil
+	}
+	if len(ids) > maxChatAttachments {
+		return errInvalidAttachment
+	}
+
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			return errInvalidAttachment
+		}
+		seen[id] = true
+	}
+
+	attachments, err := db.FetchUnsentAttachments(db.DB, c.From, ids)
+	if err == db.ErrAttachmentNotFound {
+		return errInvalidAttachment
+	}
+	if err != nil {
+		return err
+	}
+	c.Attachments = attachments
+	return nil
+}
+
+// SignAttachments fills in expiring download URLs for the attachments
+func SignAttachments(attachments []model.Attachment) {
+	for i := range attachments {
+		a := &attachments[i]
+		a.URL = utils.SignPath("/attachments/"+a.ID, attachmentURLTTL)
+		if a.ThumbnailKey != "" {
+			a.ThumbnailURL = utils.SignPath("/attachments/"+a.ID+"/thumbnail", attachmentURLTTL)
+		}
+	}
+}
+
+// loadAttachments fills in the attachments of a chat read back from
+// PostgreSQL, which stores them apart from the message
+func loadAttachments(c *model.Chat) {
+	attachments, err := db.FetchMessageAttachments([]string{c.ID})
+	if err != nil {
+		log.Printf("Error fetching attachments of %s: %v", c.ID, err)
+		return
+	}
+	c.Attachments = attachments[c.ID]
+	SignAttachments(c.Attachments)
+}
@@ -0,0 +1,63 @@
+package ws
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	auth "gochatapp/pkg/middleware"
+)
+
+// tokenSubprotocol is the Sec-WebSocket-Protocol marker browsers use to carry
+// the JWT, since they can't set an Authorization header on the upgrade:
+//
+//	new WebSocket(url, ["access_token", token])
+const tokenSubprotocol = "access_token"
+
+var errMissingToken = errors.New("missing authentication token")
+
+// tokenFromRequest extracts the JWT from either the Authorization header or
+// the Sec-WebSocket-Protocol header of the upgrade request
+func tokenFromRequest(r *http.Request) (string, error) {
+	if authHeader := r.Header.Get("Authorization"); authHeader != "" {
+		const bearerPrefix = "Bearer "
+		if !strings.HasPrefix(authHeader, bearerPrefix) {
+			return "", errors.New("invalid authorization format")
+		}
+		return strings.TrimPrefix(authHeader, bearerPrefix), nil
+	}
+
+	protocols := websocketProtocols(r)
+	for i, p := range protocols {
+		if p == tokenSubprotocol && i+1 < len(protocols) {
+			return protocols[i+1], nil
+		}
+	}
+
+	return "", errMissingToken
+}
+
+// websocketProtocols splits the comma separated Sec-WebSocket-Protocol values
+func websocketProtocols(r *http.Request) []string {
+	var protocols []string
+	for _, h := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, p := range strings.Split(h, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				protocols = append(protocols, p)
+			}
+		}
+	}
+	return protocols
+}
+
+// authenticate validates the token presented on the upgrade request and
+// returns its claims; the connection is bound to their username
+func authenticate(r *http.Request) (*auth.Claims, error) {
+	tokenString, err := tokenFromRequest(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Revoked tokens can't open a socket either
+	return auth.ValidateToken(tokenString)
+}
@@ -0,0 +1,111 @@
+package ws
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gochatapp/pkg/redisrepo"
+	"gochatapp/utils"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestTokenFromRequest(t *testing.T) {
+	cases := []struct {
+		name   string
+		header string
+		value  string
+		token  string
+		ok     bool
+	}{
+		{"bearer header", "Authorization", "Bearer abc", "abc", true},
+		{"subprotocol", "Sec-WebSocket-Protocol", "access_token, abc", "abc", true},
+		{"subprotocol without token", "Sec-WebSocket-Protocol", "access_token", "", false},
+		{"other scheme", "Authorization", "Basic abc", "", false},
+		{"no token", "", "", "", false},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest(http.MethodGet, "/ws", nil)
+		if c.header != "" {
+			r.Header.Set(c.header, c.value)
+		}
+		token, err := tokenFromRequest(r)
+		if (err == nil) != c.ok || token != c.token {
+			t.Errorf("%s: expected token %q (ok %v), got %q, %v", c.name, c.token, c.ok, token, err)
+		}
+	}
+}
+
+func TestServeWsRejectsBadTokens(t *testing.T) {
+	setup(t)
+	denied, _ := utils.CreateJWT("user1", "jti-1")
+	redisrepo.DenyToken("jti-1", time.Now().Add(time.Hour))
+	forged, _ := utils.CreateJWT("user1", "jti-2")
+	utils.SecretKey = []byte("another-secret")
+	t.Cleanup(func() { utils.SecretKey = []byte("test-secret") })
+
+	for name, header := range map[string]string{
+		"missing": "",
+		"garbage": "Bearer not-a-jwt",
+		"forged":  "Bearer " + forged,
+		"revoked": "Bearer " + denied,
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/ws", nil)
+		if header != "" {
+			r.Header.Set("Authorization", header)
+		}
+		w := httptest.NewRecorder()
+		ServeWs(w, r)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s token: expected 401, got %d", name, w.Code)
+		}
+	}
+}
+
+func TestServeWsBindsTheTokenUser(t *testing.T) {
+	setup(t)
+	srv := httptest.NewServer(http.HandlerFunc(ServeWs))
+	t.Cleanup(srv.Close)
+
+	token, _ := utils.CreateJWT("user1", "jti-1")
+	dialer := websocket.Dialer{Subprotocols: []string{tokenSubprotocol, token}}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal("error connecting", err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		// Let the server side let go of the socket before the test ends
+		for deadline := time.Now().Add(time.Second); time.Now().Before(deadline) && len(sessionsOf("user1")) > 0; {
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+
+	read := func() Message {
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		var m Message
+		if err := conn.ReadJSON(&m); err != nil {
+			t.Fatal("error reading frame", err)
+		}
+		return m
+	}
+
+	if m := read(); m.Type != "ack" || m.User != "user1" {
+		t.Fatalf("expected an ack for user1, got %+v", m)
+	}
+
+	// Nothing sent on the socket can claim another identity
+	for _, frame := range []string{
+		`{"type":"bootup","user":"user2"}`,
+		`{"type":"switch_user","switch_from":"user1","switch_to":"user2"}`,
+		`{"type":"chat","chat":{"from":"user2","to":"user3","message":"hi"}}`,
+	} {
+		conn.WriteMessage(websocket.TextMessage, []byte(frame))
+		if m := read(); m.Type != "error" {
+			t.Errorf("expected %s to be rejected, got %+v", frame, m)
+		}
+	}
+}
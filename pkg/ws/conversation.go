@@ -0,0 +1,112 @@
+package ws
+
+import (
+	"errors"
+
+	"gochatapp/model"
+	"gochatapp/pkg/db"
+)
+
+var (
+	errUnknownRecipient = errors.New("unknown recipient")
+	errInvalidReply     = errors.New("reply_to is not a message of this conversation")
+)
+
+// attachQuote checks that the message a chat replies to belongs to the same
+// conversation and embeds a preview of it. It runs once recipients has
+// resolved the conversation.
+func attachQuote(c *model.Chat) error {
+	quoted, err := db.FetchChat(c.ReplyTo)
+	if err == db.ErrChatNotFound {
+		return errInvalidReply
+	}
+	if err != nil {
+		return err
+	}
+	if quoted.ConversationID != c.ConversationID {
+		return errInvalidReply
+	}
+
+	msg := []rune(quoted.Msg)
+	if len(msg) > model.QuoteLength {
+		msg = msg[:model.QuoteLength]
+	}
+	c.Quote = &model.Quote{ID: quoted.ID, From: quoted.From, Msg: string(msg), Deleted: quoted.Deleted}
+	return nil
+}
+
+// recipients resolves the conversation a chat belongs to and returns the users
+// it is delivered to: the receiver of a direct message, or every other member
+// of the group. A chat can be addressed by receiver, group or conversation ID.
+// Direct messages the receiver doesn't accept fail with db.ErrBlocked or
+// db.ErrMessagingRestricted.
+func recipients(c *model.Chat) ([]string, error) {
+	if c.To == "" && c.GroupID == "" {
+		conv, err := db.FetchConversation(db.DB, c.ConversationID, c.From)
+		if err != nil {
+			return nil, err
+		}
+		c.To, c.GroupID = conv.Peer, conv.GroupID
+	}
+
+	if c.GroupID == "" {
+		if !db.IsUserExist(db.DB, c.To) {
+			return nil, errUnknownRecipient
+		}
+		// Blocks and the receiver's privacy setting gate direct messages
+		if err := db.CanMessage(db.DB, c.From, c.To); err != nil {
+			return nil, err
+		}
+
+		conversationID, err := db.GetOrCreateDirectConversation(db.DB, c.From, c.To)
+		if err != nil {
+			return nil, err
+		}
+		c.ConversationID = conversationID
+		return []string{c.To}, nil
+	}
+
+	// A group message has no single receiver
+	c.To = ""
+
+	if _, err := db.FetchGroupRole(db.DB, c.GroupID, c.From); err != nil {
+		return nil, err
+	}
+
+	conversationID, err := db.FetchGroupConversationID(db.DB, c.GroupID)
+	if err != nil {
+		return nil, err
+	}
+	c.ConversationID = conversationID
+
+	members, err := db.FetchGroupMembers(db.DB, c.GroupID)
+	if err != nil {
+		return nil, err
+	}
+
+	to := make([]string, 0, len(members))
+	for _, member := range members {
+		if member.Username != c.From {
+			to = append(to, member.Username)
+		}
+	}
+	return to, nil
+}
+
+// members returns everyone in the conversation of a stored chat, sender
+// included
+func members(c *model.Chat) ([]string, error) {
+	if c.GroupID == "" {
+		return []string{c.From, c.To}, nil
+	}
+
+	groupMembers, err := db.FetchGroupMembers(db.DB, c.GroupID)
+	if err != nil {
+		return nil, err
+	}
+	usernames := make([]string, len(groupMembers))
+	for i, member := range groupMembers {
+		usernames[i] = member.Username
+	}
+	return usernames, nil
+}
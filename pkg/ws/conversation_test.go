@@ -0,0 +1,153 @@
+package ws
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"gochatapp/model"
+	"gochatapp/pkg/db"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// expectGroupMembers mocks listing the members of a group
+func expectGroupMembers(mock sqlmock.Sqlmock, groupID string, usernames ...string) {
+	rows := sqlmock.NewRows([]string{"username", "role", "joined_at"})
+	for _, username := range usernames {
+		rows.AddRow(username, model.GroupRoleMember, 1700000000.0)
+	}
+	mock.ExpectQuery("FROM group_members\\s+WHERE group_id = \\$1").WithArgs(groupID).WillReturnRows(rows)
+}
+
+func TestGroupChatReachesEveryOtherMember(t *testing.T) {
+	_, mock := setup(t)
+
+	// Addressed by its conversation, the chat is resolved to the group
+	mock.ExpectQuery("FROM conversations c").
+		WithArgs("user1", "9").
+		WillReturnRows(sqlmock.NewRows(conversationColumns).
+			AddRow("9", model.ConversationGroup, "", "4", "friends", 1700000000.0, false, false, false, "0", 0))
+	mock.ExpectQuery("SELECT role FROM group_members").
+		WithArgs("4", "user1").
+		WillReturnRows(sqlmock.NewRows([]string{"role"}).AddRow(model.GroupRoleMember))
+	mock.ExpectQuery("SELECT id FROM conversations WHERE group_id").
+		WithArgs("4").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("9"))
+	expectGroupMembers(mock, "4", "user1", "user2", "user3")
+
+	chat := &model.Chat{From: "user1", ConversationID: "9", Msg: "hi all"}
+	to, err := recipients(chat)
+	if err != nil {
+		t.Fatal("error resolving recipients", err)
+	}
+	if fmt.Sprint(to) != "[user2 user3]" || chat.GroupID != "4" || chat.To != "" {
+		t.Errorf("expected the chat to reach user2 and user3 in group 4, got %v for %+v", to, chat)
+	}
+}
+
+func TestGroupChatFromOutsiderIsRejected(t *testing.T) {
+	_, mock := setup(t)
+
+	mock.ExpectQuery("SELECT role FROM group_members").
+		WithArgs("4", "user5").
+		WillReturnRows(sqlmock.NewRows([]string{"role"}))
+
+	if _, err := recipients(&model.Chat{From: "user5", GroupID: "4", Msg: "hi"}); err != db.ErrNotGroupMember {
+		t.Errorf("expected ErrNotGroupMember, got %v", err)
+	}
+}
+
+func TestDirectChatIsKeyedByConversation(t *testing.T) {
+	_, mock := setup(t)
+
+	// First contact creates the conversation, with both users as members
+	mock.ExpectQuery("SELECT EXISTS \\(SELECT 1 FROM users").
+		WithArgs("user1").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery("FROM blocks").
+		WithArgs("user2", "user1", model.MessagePrivacyEveryone).
+		WillReturnRows(sqlmock.NewRows([]string{"blocked", "forward", "backward", "privacy"}).
+			AddRow(false, false, false, model.MessagePrivacyEveryone))
+	mock.ExpectQuery("INSERT INTO conversations").
+		WithArgs("user1", "user2").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("3"))
+	mock.ExpectExec("INSERT INTO conversation_members").
+		WithArgs("3", "user1", "user2").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	chat := &model.Chat{From: "user2", To: "user1", Msg: "hi"}
+	to, err := recipients(chat)
+	if err != nil {
+		t.Fatal("error resolving recipients", err)
+	}
+	if fmt.Sprint(to) != "[user1]" || chat.ConversationID != "3" {
+		t.Errorf("expected the chat to reach user1 in conversation 3, got %v for %+v", to, chat)
+	}
+
+	// Replying by conversation ID alone finds the peer
+	mock.ExpectQuery("FROM conversations c").
+		WithArgs("user1", "3").
+		WillReturnRows(sqlmock.NewRows(conversationColumns).
+			AddRow("3", model.ConversationDirect, "user2", "", "", 1700000000.0, false, false, false, "0", 0))
+	mock.ExpectQuery("SELECT EXISTS \\(SELECT 1 FROM users").
+		WithArgs("user2").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery("FROM blocks").
+		WithArgs("user1", "user2", model.MessagePrivacyEveryone).
+		WillReturnRows(sqlmock.NewRows([]string{"blocked", "forward", "backward", "privacy"}).
+			AddRow(false, false, false, model.MessagePrivacyEveryone))
+	mock.ExpectQuery("INSERT INTO conversations").
+		WithArgs("user1", "user2").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("3"))
+	mock.ExpectExec("INSERT INTO conversation_members").
+		WithArgs("3", "user1", "user2").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	reply := &model.Chat{From: "user1", ConversationID: "3", Msg: "hello"}
+	if to, err := recipients(reply); err != nil || fmt.Sprint(to) != "[user2]" {
+		t.Errorf("expected the reply to reach user2, got %v, %v", to, err)
+	}
+}
+
+func TestChatToAnotherConversationIsRejected(t *testing.T) {
+	_, mock := setup(t)
+
+	mock.ExpectQuery("FROM conversations c").
+		WithArgs("user5", "3").
+		WillReturnRows(sqlmock.NewRows(conversationColumns))
+
+	if _, err := recipients(&model.Chat{From: "user5", ConversationID: "3", Msg: "hi"}); err != db.ErrNotConversationMember {
+		t.Errorf("expected ErrNotConversationMember, got %v", err)
+	}
+}
+
+func TestAttachQuote(t *testing.T) {
+	_, mock := setup(t)
+	long := strings.Repeat("é", model.QuoteLength+10)
+
+	mock.ExpectQuery("FROM messages WHERE id = \\$1").
+		WithArgs("5").
+		WillReturnRows(sqlmock.NewRows(chatColumns).
+			AddRow("5", "user2", "user1", long, 1700000000.0, "read", "", "3", 0.0, false, "", nil))
+	reply := &model.Chat{From: "user1", ConversationID: "3", ReplyTo: "5", Msg: "yes"}
+	if err := attachQuote(reply); err != nil {
+		t.Fatal("error attaching quote", err)
+	}
+	// The preview is cut by characters, not bytes
+	if q := reply.Quote; q == nil || q.From != "user2" || q.Msg != long[:len(long)-20] {
+		t.Errorf("expected a %d character quote of user2, got %+v", model.QuoteLength, q)
+	}
+
+	// A reply can't point into another conversation, or at nothing
+	expectChat(mock, "6", "4")
+	if err := attachQuote(&model.Chat{From: "user1", ConversationID: "3", ReplyTo: "6"}); err != errInvalidReply {
+		t.Errorf("expected errInvalidReply for another conversation, got %v", err)
+	}
+	mock.ExpectQuery("FROM messages WHERE id = \\$1").
+		WithArgs("404").
+		WillReturnRows(sqlmock.NewRows(chatColumns))
+	if err := attachQuote(&model.Chat{From: "user1", ConversationID: "3", ReplyTo: "404"}); err != errInvalidReply {
+		t.Errorf("expected errInvalidReply for a missing message, got %v", err)
+	}
+}
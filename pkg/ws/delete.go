@@ -0,0 +1,132 @@
+package ws
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"gochatapp/model"
+	"gochatapp/pkg/db"
+	"gochatapp/pkg/redisrepo"
+	"gochatapp/utils"
+)
+
+// ErrInvalidDeleteScope is returned for a scope other than "me" or "everyone"
+var ErrInvalidDeleteScope = errors.New("invalid delete scope")
+
+// defaultDeleteWindow applies unless DELETE_FOR_EVERYONE_WINDOW says otherwise
+const defaultDeleteWindow = time.Hour
+
+// deleteWindow returns how long after sending a message its sender may still
+// delete it for everyone
+func deleteWindow() time.Duration {
+	return utils.DurationFromEnv("DELETE_FOR_EVERYONE_WINDOW", defaultDeleteWindow)
+}
+
+// DeleteChat deletes a message for username alone, or replaces it with a
+// tombstone for everyone and pushes that to the other members of its
+// conversation. It backs both the "delete" frame and the REST endpoint.
+func DeleteChat(username, id, scope string) (*model.Chat, error) {
+	chat, err := db.FetchChat(id)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.FetchConversation(db.DB, chat.ConversationID, username); err != nil {
+		return nil, err
+	}
+
+	switch scope {
+	case model.DeleteScopeMe:
+		if err := db.HideChat(id, username); err != nil {
+			return nil, err
+		}
+		redisrepo.HideChat(chat.ConversationID, username, id)
+		// Don't hand it to their sockets later either
+		redisrepo.AckPending(username, id)
+
+		// Drop it from the user's other devices
+		if err := publish(Message{Type: "delete", MessageID: id, Scope: scope}, username); err != nil {
+			log.Printf("Error publishing delete of %s to %s: %v", id, username, err)
+		}
+
+		// A hidden message no longer counts as unread
+		redisrepo.ResetUnread(username)
+		pushUnread(username, chat.ConversationID)
+		return chat, nil
+
+	case model.DeleteScopeEveryone:
+		chat, err = db.DeleteChatForEveryone(id, username, deleteWindow())
+		if err != nil {
+			return nil, err
+		}
+		redisrepo.RefreshChat(chat)
+
+		// Blocks only gate new chats, the tombstone reaches every copy
+		usernames, err := members(chat)
+		if err != nil {
+			log.Printf("Error resolving members for delete of %s: %v", id, err)
+			return chat, nil
+		}
+		to := make([]string, 0, len(usernames))
+		for _, member := range usernames {
+			if member != username {
+				to = append(to, member)
+			}
+		}
+
+		for _, recipient := range to {
+			// Nobody offline gets to see it anymore
+			redisrepo.AckPending(recipient, id)
+		}
+
+		if err := publish(Message{Type: "delete", Chat: chat, MessageID: id, Scope: scope}, usernames...); err != nil {
+			log.Printf("Error publishing delete of %s: %v", id, err)
+		}
+
+		redisrepo.ResetUnread(to...)
+		for _, recipient := range to {
+			pushUnread(recipient, chat.ConversationID)
+		}
+		return chat, nil
+	}
+
+	return nil, ErrInvalidDeleteScope
+}
+
+// handleDelete processes a "delete" frame carrying message_id and scope
+func handleDelete(client *Client, m Message) {
+	if m.MessageID == "" {
+		client.writeJSON(Message{
+			Type:  "error",
+			Error: "Delete must include message_id",
+		})
+		return
+	}
+
+	chat, err := DeleteChat(client.Username, m.MessageID, m.Scope)
+	if err != nil {
+		log.Printf("Error deleting message %s for %s: %v", m.MessageID, client.Username, err)
+		errMsg := "Failed to delete message"
+		switch err {
+		case db.ErrChatNotFound, db.ErrNotConversationMember:
+			errMsg = "Message not found"
+		case db.ErrNotChatSender:
+			errMsg = "Only the sender can delete this message for everyone"
+		case db.ErrDeleteWindowPassed:
+			errMsg = "Message is too old to delete for everyone"
+		case ErrInvalidDeleteScope:
+			errMsg = "Scope must be me or everyone"
+		}
+		client.writeJSON(Message{
+			Type:  "error",
+			Error: errMsg,
+		})
+		return
+	}
+
+	client.writeJSON(Message{
+		Type:      "deleted",
+		MessageID: chat.ID,
+		Scope:     m.Scope,
+	})
+}
@@ -0,0 +1,97 @@
+package ws
+
+import (
+	"errors"
+	"log"
+	"strings"
+
+	"gochatapp/model"
+	"gochatapp/pkg/db"
+	"gochatapp/pkg/redisrepo"
+)
+
+// ErrEmptyMessage is returned when an edit would leave a message without text
+var ErrEmptyMessage = errors.New("message cannot be empty")
+
+// EditChat replaces the text of a message sent by editor and pushes the new
+// version to the other members of its conversation. It backs both the "edit"
+// frame and the REST endpoint. Someone who left the conversation can't edit
+// what they sent to it anymore.
+func EditChat(editor, id, text string) (*model.Chat, error) {
+	if strings.TrimSpace(text) == "" {
+		return nil, ErrEmptyMessage
+	}
+
+	chat, err := db.FetchChat(id)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.FetchConversation(db.DB, chat.ConversationID, editor); err != nil {
+		return nil, err
+	}
+
+	chat, err = db.EditChat(id, editor, text)
+	if err != nil {
+		return nil, err
+	}
+	loadAttachments(chat)
+
+	redisrepo.RefreshChat(chat)
+
+	// The edit may change the link the preview is of
+	go previewLinks(*chat)
+
+	// Blocks only gate new chats; whoever already holds this one gets the
+	// edit, so no stale copy stays behind
+	to, err := members(chat)
+	if err != nil {
+		log.Printf("Error resolving members for edit of %s: %v", id, err)
+		return chat, nil
+	}
+	for _, username := range to {
+		if username != editor {
+			redisrepo.RefreshPending(username, chat)
+		}
+	}
+
+	// The editor's devices get the new version too
+	if err := publish(Message{Type: "edit", Chat: chat}, to...); err != nil {
+		log.Printf("Error publishing edit of %s: %v", id, err)
+	}
+	return chat, nil
+}
+
+// handleEdit processes an "edit" frame carrying the chat ID and its new text
+func handleEdit(client *Client, m Message) {
+	if m.Chat == nil || m.Chat.ID == "" {
+		client.writeJSON(Message{
+			Type:  "error",
+			Error: "Edit must include the chat id and message",
+		})
+		return
+	}
+
+	chat, err := EditChat(client.Username, m.Chat.ID, m.Chat.Msg)
+	if err != nil {
+		log.Printf("Error editing message %s for %s: %v", m.Chat.ID, client.Username, err)
+		errMsg := "Failed to edit message"
+		switch err {
+		case db.ErrChatNotFound, db.ErrNotConversationMember:
+			errMsg = "Message not found"
+		case db.ErrNotChatSender:
+			errMsg = "Only the sender can edit this message"
+		case ErrEmptyMessage:
+			errMsg = "Message cannot be empty"
+		}
+		client.writeJSON(Message{
+			Type:  "error",
+			Error: errMsg,
+		})
+		return
+	}
+
+	client.writeJSON(Message{
+		Type: "edited",
+		Chat: chat,
+	})
+}
@@ -0,0 +1,92 @@
+package ws
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"gochatapp/model"
+	"gochatapp/pkg/db"
+	"gochatapp/pkg/redisrepo"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+var chatColumns = []string{"id", "sender", "receiver", "content", "timestamp", "status",
+	"group_id", "conversation_id", "edited_at", "deleted", "reply_to", "link_preview"}
+
+var conversationColumns = []string{"id", "kind", "peer", "group_id", "name", "last_activity",
+	"muted", "pinned", "archived", "last_read_id", "unread"}
+
+// expectChat mocks fetching a chat of user1 to user2 by its ID
+func expectChat(mock sqlmock.Sqlmock, id, conversationID string) {
+	mock.ExpectQuery("FROM messages WHERE id = \\$1").
+		WithArgs(id).
+		WillReturnRows(sqlmock.NewRows(chatColumns).
+			AddRow(id, "user1", "user2", "helo", 1700000000.0, "sent", "", conversationID, 0.0, false, "", nil))
+}
+
+// expectMember mocks looking up a direct conversation as one of its members,
+// or as an outsider if member is false
+func expectMember(mock sqlmock.Sqlmock, conversationID, username, peer string, member bool) {
+	rows := sqlmock.NewRows(conversationColumns)
+	if member {
+		rows.AddRow(conversationID, model.ConversationDirect, peer, "", "", 1700000000.0, false, false, false, "0", 0)
+	}
+	mock.ExpectQuery("FROM conversations c").WithArgs(username, conversationID).WillReturnRows(rows)
+}
+
+func TestEditReachesBlockedPeer(t *testing.T) {
+	_, mock := setup(t)
+	SetPreviewer(nil)
+	t.Cleanup(func() { SetPreviewer(newPreviewer()) })
+	rec := &recordingBus{}
+	bus = rec
+
+	// user2 blocked user1 after the chat was queued for them
+	redisrepo.EnqueuePending("user2", &model.Chat{ID: "7", From: "user1", To: "user2", Msg: "helo", Timestamp: float64(time.Now().Unix())})
+
+	expectChat(mock, "7", "3")
+	expectMember(mock, "3", "user1", "user2", true)
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT sender, content FROM messages").
+		WithArgs("7").
+		WillReturnRows(sqlmock.NewRows([]string{"sender", "content"}).AddRow("user1", "helo"))
+	mock.ExpectExec("INSERT INTO message_edits").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery("UPDATE messages SET content").
+		WithArgs("7", "hello").
+		WillReturnRows(sqlmock.NewRows(chatColumns).
+			AddRow("7", "user1", "user2", "hello", 1700000000.0, "sent", "", "3", 1700000100.0, false, "", nil))
+	mock.ExpectCommit()
+	mock.ExpectQuery("FROM attachments").WillReturnRows(sqlmock.NewRows(nil))
+
+	// No block check: the edit goes to whoever already holds the chat
+	if _, err := EditChat("user1", "7", "hello"); err != nil {
+		t.Fatal("error editing chat", err)
+	}
+
+	if to := rec.recipientsOf("edit"); fmt.Sprint(to) != "[[user1 user2]]" {
+		t.Errorf("expected the edit to reach both members, got %v", to)
+	}
+	pending, _ := redisrepo.FetchPending("user2")
+	if len(pending) != 1 || pending[0].Msg != "hello" {
+		t.Errorf("expected the queued copy to be refreshed, got %+v", pending)
+	}
+}
+
+func TestEditRequiresMembership(t *testing.T) {
+	_, mock := setup(t)
+	rec := &recordingBus{}
+	bus = rec
+
+	// user1 left the conversation the chat was sent to
+	expectChat(mock, "7", "3")
+	expectMember(mock, "3", "user1", "", false)
+
+	if _, err := EditChat("user1", "7", "hello"); err != db.ErrNotConversationMember {
+		t.Errorf("expected ErrNotConversationMember, got %v", err)
+	}
+	if to := rec.recipientsOf("edit"); len(to) != 0 {
+		t.Errorf("expected no edit to be pushed, got %v", to)
+	}
+}
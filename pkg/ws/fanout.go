@@ -0,0 +1,110 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"gochatapp/pkg/fanout"
+	"gochatapp/pkg/redisrepo"
+)
+
+// bus carries frames to whichever instance holds the recipient's socket.
+// It defaults to an in-process bus, which is enough for a single instance.
+var bus fanout.Bus = fanout.NewMemoryBus()
+
+// SetBus replaces the fan-out layer. It must be called before Broadcaster starts.
+func SetBus(b fanout.Bus) {
+	bus = b
+}
+
+// publish sends a frame to the given users through the fan-out layer
+func publish(msg Message, to ...string) error {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	e := &fanout.Event{To: to, Payload: payload}
+	if msg.Type == "chat" && msg.Chat != nil {
+		e.MessageID = msg.Chat.ID
+	}
+	return bus.Publish(context.Background(), e)
+}
+
+// subscribe delivers fan-out events to local sockets, resubscribing if the
+// underlying bus drops the subscription
+func subscribe() {
+	for {
+		if err := bus.Subscribe(context.Background(), deliver); err != nil {
+			log.Println("Fan-out subscription ended:", err)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// deliver writes an event to every socket of the recipients connected to this
+// instance. Recipients connected elsewhere are handled by their own instance.
+func deliver(e *fanout.Event) {
+	for _, username := range e.To {
+		delivered := false
+		for _, recipientClient := range sessionsOf(username) {
+			if (e.Session != "" && recipientClient.SessionID != e.Session) ||
+				(e.ExceptSession != "" && recipientClient.SessionID == e.ExceptSession) {
+				continue
+			}
+
+			if err := recipientClient.writeRaw(e.Payload); err != nil {
+				log.Printf("Error delivering to %s: %v", username, err)
+				recipientClient.Conn.Close()
+
+				// Clean up from maps
+				unregister(recipientClient)
+				continue
+			}
+			delivered = true
+
+			if e.Close {
+				recipientClient.Conn.Close()
+			}
+		}
+
+		if !delivered {
+			continue
+		}
+		log.Printf("Successfully delivered frame to recipient %s", username)
+
+		// One device receiving a chat is enough to count it as delivered
+		if e.MessageID != "" {
+			redisrepo.AckPending(username, e.MessageID)
+			markDelivered(e.MessageID)
+		}
+	}
+}
+
+// deliverPending drains the client's offline queue in order. It runs right
+// after the connection is acknowledged; a chat that arrives live while the
+// queue drains may be written twice, so clients dedupe by message ID.
+func deliverPending(client *Client) error {
+	chats, err := redisrepo.FetchPending(client.Username)
+	if err != nil {
+		return err
+	}
+
+	for i := range chats {
+		if err := client.writeJSON(Message{
+			Type: "chat",
+			Chat: &chats[i],
+		}); err != nil {
+			return err
+		}
+		redisrepo.AckPending(client.Username, chats[i].ID)
+		markDelivered(chats[i].ID)
+	}
+
+	if len(chats) > 0 {
+		log.Printf("Delivered %d pending messages to %s", len(chats), client.Username)
+	}
+	return nil
+}
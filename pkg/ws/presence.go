@@ -0,0 +1,109 @@
+package ws
+
+import (
+	"log"
+	"time"
+
+	"gochatapp/model"
+	"gochatapp/pkg/db"
+	"gochatapp/pkg/redisrepo"
+)
+
+// awayAfter is how long a connected client can stay idle before it is
+// marked away
+const awayAfter = 5 * time.Minute
+
+// setPresence records a status change of one of the user's sockets. The
+// user's presence is worked out in Redis from their sessions on every
+// instance, and pushed to their accepted contacts when it changes.
+func setPresence(client *Client, status string) {
+	client.presenceMu.Lock()
+	client.status = status
+	client.presenceMu.Unlock()
+
+	before, after, err := redisrepo.SetSessionPresence(client.Username, client.SessionID, status)
+	if err != nil || before == after {
+		return
+	}
+	notifyPresence(client.Username, after)
+}
+
+// notifyPresence pushes a user's presence to their accepted contacts
+func notifyPresence(username, status string) {
+	contacts, err := db.FetchAcceptedContacts(db.DB, username)
+	if err != nil || len(contacts) == 0 {
+		return
+	}
+	// Blocking drops the follow, but a block racing an accept shouldn't leak presence
+	blocked, err := db.FetchBlockedBetween(db.DB, username, contacts)
+	if err != nil {
+		return
+	}
+	to := contacts[:0]
+	for _, contact := range contacts {
+		if !blocked[contact] {
+			to = append(to, contact)
+		}
+	}
+	if len(to) == 0 {
+		return
+	}
+
+	p := &model.Presence{Username: username, Status: status}
+	if hidden, err := db.FetchHiddenLastSeen(db.DB, []string{username}); err == nil && !hidden[username] {
+		p.LastSeen = float64(time.Now().Unix())
+	}
+
+	if err := publish(Message{Type: "presence", User: username, Presence: p}, to...); err != nil {
+		log.Printf("Error publishing presence of %s: %v", username, err)
+	}
+}
+
+// handlePresence processes a "presence" frame, with which a client reports
+// the user as online or away
+func handlePresence(client *Client, m Message) {
+	if m.Presence == nil || (m.Presence.Status != model.PresenceOnline && m.Presence.Status != model.PresenceAway) {
+		client.writeJSON(Message{
+			Type:  "error",
+			Error: "Presence status must be online or away",
+		})
+		return
+	}
+	setPresence(client, m.Presence.Status)
+}
+
+// markActive notes activity on the client, bringing an idle user back online
+func markActive(client *Client) {
+	client.presenceMu.Lock()
+	client.lastActive = time.Now()
+	away := client.status == model.PresenceAway
+	client.presenceMu.Unlock()
+
+	if away {
+		setPresence(client, model.PresenceOnline)
+	}
+}
+
+// refreshPresence runs with the ping loop: it renews the heartbeat of the
+// clients that are still connected and marks idle ones away
+func refreshPresence(alive []*Client) {
+	sessions := make(map[string][]string)
+	for _, client := range alive {
+		sessions[client.Username] = append(sessions[client.Username], client.SessionID)
+	}
+	// Devices on an instance that went away no longer count
+	changed, _ := redisrepo.TouchPresence(sessions)
+	for username, status := range changed {
+		notifyPresence(username, status)
+	}
+
+	for _, client := range alive {
+		client.presenceMu.Lock()
+		idle := client.status == model.PresenceOnline && time.Since(client.lastActive) > awayAfter
+		client.presenceMu.Unlock()
+
+		if idle {
+			setPresence(client, model.PresenceAway)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package ws
+
+import (
+	"context"
+	"log"
+	"os"
+
+	"gochatapp/model"
+	"gochatapp/pkg/db"
+	"gochatapp/pkg/linkpreview"
+	"gochatapp/pkg/redisrepo"
+)
+
+// previewer fetches the link previews of chats; LINK_PREVIEWS=off turns
+// previews off
+var previewer = newPreviewer()
+
+func newPreviewer() *linkpreview.Fetcher {
+	if os.Getenv("LINK_PREVIEWS") == "off" {
+		return nil
+	}
+	return linkpreview.New(linkpreview.ConfigFromEnv())
+}
+
+// SetPreviewer replaces the fetcher of link previews, nil turns them off
+func SetPreviewer(f *linkpreview.Fetcher) {
+	previewer = f
+}
+
+// previewSlots bounds the previews fetched at once
+var previewSlots = make(chan struct{}, 8)
+
+// lookupPreview returns the preview of a link, from the cache when it can.
+// Links without a preview are cached as such.
+func lookupPreview(link string) *model.LinkPreview {
+	if preview, ok, _ := redisrepo.FetchCachedPreview(link); ok {
+		return preview
+	}
+
+	previewSlots <- struct{}{}
+	preview, err := previewer.Fetch(context.Background(), link)
+	<-previewSlots
+	if err != nil {
+		log.Printf("No link preview for %s: %v", link, err)
+		preview = nil
+	}
+	redisrepo.CachePreview(link, preview)
+	return preview
+}
+
+// previewLinks fetches the preview of the first link in a stored chat,
+// attaches it to the chat and pushes it to the conversation in a "preview"
+// frame. It runs after a chat is sent or edited, off the read loop; an edit
+// that drops the link clears the preview.
+func previewLinks(c model.Chat) {
+	if previewer == nil || c.Deleted {
+		return
+	}
+
+	link := linkpreview.FindURL(c.Msg)
+	if c.Preview != nil && c.Preview.URL == link {
+		return
+	}
+	if link == "" && c.Preview == nil {
+		return
+	}
+
+	var preview *model.LinkPreview
+	if link != "" {
+		if preview = lookupPreview(link); preview == nil && c.Preview == nil {
+			return
+		}
+	}
+
+	// The chat may have been edited or deleted while the page was fetched
+	ok, err := db.SetChatPreview(c.ID, c.Msg, preview)
+	if err != nil || !ok {
+		return
+	}
+	redisrepo.SetChatPreview(c.ID, c.Msg, preview)
+
+	c.Preview = preview
+	to, err := members(&c)
+	if err != nil {
+		log.Printf("Error resolving members for preview of %s: %v", c.ID, err)
+		return
+	}
+	for _, username := range to {
+		if username != c.From {
+			// Recipients who are offline get it with the chat
+			redisrepo.RefreshPending(username, &c)
+		}
+	}
+
+	m := Message{Type: "preview", MessageID: c.ID, ConversationID: c.ConversationID, Preview: preview}
+	if err := publish(m, to...); err != nil {
+		log.Printf("Error publishing preview of %s: %v", c.ID, err)
+	}
+}
@@ -0,0 +1,64 @@
+package ws
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"gochatapp/pkg/ratelimit"
+)
+
+// limiter throttles the frames clients send. It defaults to in-process
+// buckets, which is enough for a single instance.
+var limiter ratelimit.Limiter = ratelimit.NewMemoryLimiter()
+
+// SetLimiter replaces the rate limiter used for incoming frames
+func SetLimiter(l ratelimit.Limiter) {
+	limiter = l
+}
+
+// frameLimits are the per-user limits of each frame type, overridable with
+// RATE_LIMIT_WS_<TYPE>, e.g. RATE_LIMIT_WS_CHAT=20/10s. Paired frames share a
+// bucket and any other type falls under "default".
+var frameLimits = map[string]ratelimit.Limit{
+	"chat":    ratelimit.FromEnv("ws_chat", ratelimit.Limit{Burst: 20, Per: 10 * time.Second}),
+	"typing":  ratelimit.FromEnv("ws_typing", ratelimit.Limit{Burst: 30, Per: 10 * time.Second}),
+	"react":   ratelimit.FromEnv("ws_react", ratelimit.Limit{Burst: 30, Per: 10 * time.Second}),
+	"edit":    ratelimit.FromEnv("ws_edit", ratelimit.Limit{Burst: 20, Per: time.Minute}),
+	"delete":  ratelimit.FromEnv("ws_delete", ratelimit.Limit{Burst: 20, Per: time.Minute}),
+	"default": ratelimit.FromEnv("ws_default", ratelimit.Limit{Burst: 60, Per: 10 * time.Second}),
+}
+
+// frameBucket maps a frame type to the bucket it counts against
+func frameBucket(frameType string) string {
+	switch frameType {
+	case "typing_start", "typing_stop":
+		return "typing"
+	case "react", "unreact":
+		return "react"
+	}
+	if _, ok := frameLimits[frameType]; ok {
+		return frameType
+	}
+	return "default"
+}
+
+// allowFrame takes a token for the frame from the user's bucket, shared by
+// all their devices. When there is none, the client gets a rate_limited
+// error frame telling it how many seconds to wait.
+func allowFrame(client *Client, m Message) bool {
+	name := frameBucket(m.Type)
+	res := limiter.Allow(context.Background(), "ws:"+name+":"+client.Username, frameLimits[name])
+	if res.Allowed {
+		return true
+	}
+
+	log.Printf("Rate limited %s frame from %s", m.Type, client.Username)
+	client.writeJSON(Message{
+		Type:       "error",
+		Error:      "rate_limited",
+		MessageID:  m.MessageID,
+		RetryAfter: ratelimit.RetryAfterSeconds(res.RetryAfter),
+	})
+	return false
+}
@@ -0,0 +1,95 @@
+package ws
+
+import (
+	"log"
+	"unicode"
+	"unicode/utf8"
+
+	"gochatapp/pkg/db"
+)
+
+// maxEmojiRunes allows for emoji built from several code points, like flags
+// and skin tones
+const maxEmojiRunes = 8
+
+func validEmoji(emoji string) bool {
+	if emoji == "" || len(emoji) > 32 || utf8.RuneCountInString(emoji) > maxEmojiRunes {
+		return false
+	}
+	for _, r := range emoji {
+		if unicode.IsSpace(r) || unicode.IsControl(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// handleReaction processes "react" and "unreact" frames carrying message_id
+// and emoji, and broadcasts the change to the conversation
+func handleReaction(client *Client, m Message) {
+	if m.MessageID == "" || !validEmoji(m.Emoji) {
+		client.writeJSON(Message{
+			Type:  "error",
+			Error: "Reaction must include message_id and a single emoji",
+		})
+		return
+	}
+
+	errMsg := ""
+	chat, err := db.FetchChat(m.MessageID)
+	if err == nil && chat.Deleted {
+		err = db.ErrChatNotFound
+	}
+	if err == nil {
+		_, err = db.FetchConversation(db.DB, chat.ConversationID, client.Username)
+	}
+	switch err {
+	case nil:
+	case db.ErrChatNotFound, db.ErrNotConversationMember:
+		errMsg = "Message not found"
+	default:
+		errMsg = "Failed to update reaction"
+	}
+	if errMsg != "" {
+		log.Printf("Error checking message %s for reaction by %s: %v", m.MessageID, client.Username, err)
+		client.writeJSON(Message{
+			Type:  "error",
+			Error: errMsg,
+		})
+		return
+	}
+
+	var changed bool
+	if m.Type == "react" {
+		changed, err = db.AddReaction(chat.ID, client.Username, m.Emoji)
+	} else {
+		changed, err = db.RemoveReaction(chat.ID, client.Username, m.Emoji)
+	}
+	if err != nil {
+		client.writeJSON(Message{
+			Type:  "error",
+			Error: "Failed to update reaction",
+		})
+		return
+	}
+	if !changed {
+		return
+	}
+
+	usernames, err := members(chat)
+	if err != nil {
+		log.Printf("Error resolving members for reaction on %s: %v", chat.ID, err)
+		return
+	}
+
+	// Everyone in the conversation gets the frame, the reactor included, so
+	// all their views agree on the counts
+	if err := publish(Message{
+		Type:      m.Type,
+		User:      client.Username,
+		MessageID: chat.ID,
+		Emoji:     m.Emoji,
+	}, usernames...); err != nil {
+		log.Printf("Error publishing reaction on %s: %v", chat.ID, err)
+	}
+}
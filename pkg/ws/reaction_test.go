@@ -0,0 +1,99 @@
+package ws
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestValidEmoji(t *testing.T) {
+	for emoji, want := range map[string]bool{
+		"👍":            true,
+		"👍🏽":           true, // with a skin tone
+		"🇳🇱":           true, // a flag is two code points
+		"":             false,
+		"👍 👍":          false,
+		"\x00":         false,
+		"thumbsupxxxx": false,
+	} {
+		if got := validEmoji(emoji); got != want {
+			t.Errorf("validEmoji(%q) = %v, want %v", emoji, got, want)
+		}
+	}
+}
+
+func TestReactionIsBroadcastToTheConversation(t *testing.T) {
+	_, mock := setup(t)
+	rec := &recordingBus{}
+	bus = rec
+	client, _ := connect(t, "user2")
+
+	expectChat(mock, "7", "3")
+	expectMember(mock, "3", "user2", "user1", true)
+	mock.ExpectExec("INSERT INTO message_reactions").
+		WithArgs("7", "user2", "👍").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	handleReaction(client, Message{Type: "react", MessageID: "7", Emoji: "👍"})
+
+	// Reacting again changes nothing, so nothing is sent
+	expectChat(mock, "7", "3")
+	expectMember(mock, "3", "user2", "user1", true)
+	mock.ExpectExec("INSERT INTO message_reactions").
+		WithArgs("7", "user2", "👍").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	handleReaction(client, Message{Type: "react", MessageID: "7", Emoji: "👍"})
+
+	expectChat(mock, "7", "3")
+	expectMember(mock, "3", "user2", "user1", true)
+	mock.ExpectExec("DELETE FROM message_reactions").
+		WithArgs("7", "user2", "👍").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	handleReaction(client, Message{Type: "unreact", MessageID: "7", Emoji: "👍"})
+
+	reacts, unreacts := rec.frames(t, "react"), rec.frames(t, "unreact")
+	if len(reacts) != 1 || reacts[0].User != "user2" || reacts[0].MessageID != "7" || reacts[0].Emoji != "👍" {
+		t.Errorf("expected one react frame from user2, got %+v", reacts)
+	}
+	if len(unreacts) != 1 {
+		t.Errorf("expected one unreact frame, got %+v", unreacts)
+	}
+	// The reactor's own devices get the frame too
+	if to := rec.recipientsOf("react"); fmt.Sprint(to) != "[[user1 user2]]" {
+		t.Errorf("expected the reaction to reach both members, got %v", to)
+	}
+}
+
+func TestReactionNeedsAVisibleMessage(t *testing.T) {
+	_, mock := setup(t)
+	rec := &recordingBus{}
+	bus = rec
+	client, peer := connect(t, "user5")
+
+	handleReaction(client, Message{Type: "react", MessageID: "7", Emoji: "not an emoji"})
+	if m := readFrame(t, peer); m.Type != "error" {
+		t.Errorf("expected an invalid emoji to be rejected, got %+v", m)
+	}
+
+	// Someone outside the conversation can't tell the message exists
+	expectChat(mock, "7", "3")
+	expectMember(mock, "3", "user5", "", false)
+	handleReaction(client, Message{Type: "react", MessageID: "7", Emoji: "👍"})
+	if m := readFrame(t, peer); m.Type != "error" || m.Error != "Message not found" {
+		t.Errorf("expected the message to be reported missing, got %+v", m)
+	}
+
+	// Nor can anyone react to a message deleted for everyone
+	mock.ExpectQuery("FROM messages WHERE id = \\$1").
+		WithArgs("8").
+		WillReturnRows(sqlmock.NewRows(chatColumns).
+			AddRow("8", "user1", "user5", "", 1700000000.0, "sent", "", "3", 0.0, true, "", nil))
+	handleReaction(client, Message{Type: "react", MessageID: "8", Emoji: "👍"})
+	if m := readFrame(t, peer); m.Type != "error" || m.Error != "Message not found" {
+		t.Errorf("expected a deleted message to be reported missing, got %+v", m)
+	}
+
+	if frames := rec.frames(t, "react"); len(frames) != 0 {
+		t.Errorf("expected no reaction to be sent, got %+v", frames)
+	}
+}
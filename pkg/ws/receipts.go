@@ -0,0 +1,116 @@
+package ws
+
+import (
+	"log"
+	"strconv"
+
+	"gochatapp/model"
+	"gochatapp/pkg/db"
+	"gochatapp/pkg/redisrepo"
+)
+
+// markDelivered records that a chat reached one of the recipient's sockets
+// and pushes a delivered receipt to the sender
+func markDelivered(id string) {
+	chat, changed, err := db.MarkChatDelivered(id)
+	if err != nil || !changed {
+		return
+	}
+
+	redisrepo.UpdateChatStatus(id, model.ChatStatusDelivered)
+
+	if err := publish(Message{
+		Type:       "delivered",
+		User:       chat.To,
+		MessageIDs: []string{chat.ID},
+	}, chat.From); err != nil {
+		log.Printf("Error publishing delivered receipt for %s: %v", chat.ID, err)
+	}
+}
+
+// handleRead marks direct messages received by the client as read and pushes
+// a read receipt to each sender. Group messages get no receipts.
+func handleRead(client *Client, m Message) {
+	id, upTo := m.MessageID, false
+	if m.UpTo != "" {
+		id, upTo = m.UpTo, true
+	}
+
+	if id == "" {
+		client.writeJSON(Message{
+			Type:  "error",
+			Error: "Read receipt must include message_id or up_to",
+		})
+		return
+	}
+
+	chats, err := db.MarkChatsRead(client.Username, id, upTo)
+	if err != nil {
+		log.Printf("Error marking messages read for %s: %v", client.Username, err)
+		errMsg := "Failed to mark messages as read"
+		if err == db.ErrGroupReceipt {
+			errMsg = "Read receipts are only kept for direct messages, use mark_read for groups"
+		}
+		client.writeJSON(Message{
+			Type:  "error",
+			Error: errMsg,
+		})
+		return
+	}
+
+	// The reader's other devices clear the same messages
+	if read := chatIDs(chats); len(read) > 0 {
+		if err := syncSent(client, Message{Type: "read", User: client.Username, MessageIDs: read}); err != nil {
+			log.Printf("Error syncing read messages of %s: %v", client.Username, err)
+		}
+	}
+	publishReadReceipts(client.Username, chats)
+
+	// Reading a message reads everything before it, so move the read marker
+	latest := make(map[string]string)
+	for _, c := range chats {
+		if current, ok := latest[c.ConversationID]; !ok || laterID(c.ID, current) {
+			latest[c.ConversationID] = c.ID
+		}
+	}
+	for conversationID, id := range latest {
+		if _, err := MarkConversationRead(client.Username, conversationID, id); err != nil {
+			log.Printf("Error moving read marker of %s in %s: %v", client.Username, conversationID, err)
+		}
+	}
+}
+
+// publishReadReceipts records chats as read in the cache and pushes a read
+// receipt to each sender, grouped so each gets a single frame
+func publishReadReceipts(reader string, chats []model.Chat) {
+	receipts := make(map[string][]string)
+	for _, c := range chats {
+		redisrepo.UpdateChatStatus(c.ID, model.ChatStatusRead)
+		receipts[c.From] = append(receipts[c.From], c.ID)
+	}
+
+	for sender, ids := range receipts {
+		if err := publish(Message{
+			Type:       "read",
+			User:       reader,
+			MessageIDs: ids,
+		}, sender); err != nil {
+			log.Printf("Error publishing read receipt to %s: %v", sender, err)
+		}
+	}
+}
+
+func chatIDs(chats []model.Chat) []string {
+	ids := make([]string, len(chats))
+	for i, c := range chats {
+		ids[i] = c.ID
+	}
+	return ids
+}
+
+// laterID reports whether message ID a comes after b
+func laterID(a, b string) bool {
+	x, _ := strconv.ParseInt(a, 10, 64)
+	y, _ := strconv.ParseInt(b, 10, 64)
+	return x > y
+}
@@ -0,0 +1,171 @@
+package ws
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"gochatapp/model"
+	"gochatapp/pkg/db"
+	"gochatapp/pkg/fanout"
+	"gochatapp/pkg/redisrepo"
+	"gochatapp/utils"
+)
+
+// maxDeviceName caps the device label a client reports
+const maxDeviceName = 64
+
+func newSessionID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// deviceName labels a connection by the device query parameter, falling back
+// to the user agent
+func deviceName(r *http.Request) string {
+	name := strings.TrimSpace(r.URL.Query().Get("device"))
+	if name == "" {
+		name = r.UserAgent()
+	}
+	if len(name) > maxDeviceName {
+		name = name[:maxDeviceName]
+	}
+	return name
+}
+
+// register adds a socket to the set of sessions of its user
+func register(client *Client) {
+	clientsMu.Lock()
+	clients[client] = true
+	clientsMu.Unlock()
+
+	usernameMu.Lock()
+	if usernameMap[client.Username] == nil {
+		usernameMap[client.Username] = make(map[*Client]bool)
+	}
+	usernameMap[client.Username][client] = true
+	usernameMu.Unlock()
+
+	s := &model.Session{
+		ID:          client.SessionID,
+		Device:      client.Device,
+		RemoteAddr:  client.Conn.RemoteAddr().String(),
+		ConnectedAt: float64(client.ConnectedAt.Unix()),
+	}
+	if client.token != nil {
+		s.TokenID, s.TokenExpiresAt = client.token.TokenID, float64(client.token.ExpiresAt.Unix())
+	}
+	redisrepo.AddSession(client.Username, s)
+}
+
+// unregister removes a socket from the maps. It is safe to call more than once.
+func unregister(client *Client) {
+	clientsMu.Lock()
+	delete(clients, client)
+	clientsMu.Unlock()
+
+	usernameMu.Lock()
+	if sessions, found := usernameMap[client.Username]; found && sessions[client] {
+		delete(sessions, client)
+		if len(sessions) == 0 {
+			delete(usernameMap, client.Username)
+		}
+	}
+	usernameMu.Unlock()
+}
+
+// sessionsOf returns the sockets a user has on this instance
+func sessionsOf(username string) []*Client {
+	usernameMu.RLock()
+	defer usernameMu.RUnlock()
+
+	sessions := make([]*Client, 0, len(usernameMap[username]))
+	for client := range usernameMap[username] {
+		sessions = append(sessions, client)
+	}
+	return sessions
+}
+
+// syncSent copies a frame the client sent to the user's other devices
+func syncSent(client *Client, msg Message) error {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return bus.Publish(context.Background(), &fanout.Event{
+		To:            []string{client.Username},
+		Payload:       payload,
+		ExceptSession: client.SessionID,
+	})
+}
+
+// RevokeSession signs one device of a user out: the access token it
+// connected with and the refresh tokens of its login are revoked, and its
+// socket is closed wherever it's held
+func RevokeSession(username, sessionID string) error {
+	s, err := redisrepo.FetchSession(username, sessionID)
+	if err != nil {
+		return err
+	}
+	if s != nil && s.TokenID != "" {
+		if err := revokeSessionTokens(username, s); err != nil {
+			return err
+		}
+	}
+
+	if err := redisrepo.RemoveSession(username, sessionID); err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(Message{
+		Type:  "error",
+		Error: "This session has been signed out from another device",
+	})
+	if err != nil {
+		return err
+	}
+	return bus.Publish(context.Background(), &fanout.Event{
+		To:      []string{username},
+		Payload: payload,
+		Session: sessionID,
+		Close:   true,
+	})
+}
+
+// revokeSessionTokens denies the access token of a session, revokes the
+// refresh token family it was issued with and denies the access tokens that
+// family handed out since, so the device can't refresh its way back in
+func revokeSessionTokens(username string, s *model.Session) error {
+	if err := redisrepo.DenyToken(s.TokenID, time.Unix(int64(s.TokenExpiresAt), 0)); err != nil {
+		return err
+	}
+
+	ttl := utils.AccessTokenTTL()
+	jtis, err := db.RevokeAccessTokenFamily(db.DB, username, s.TokenID, time.Now().Add(-ttl))
+	if err != nil {
+		return err
+	}
+	for _, jti := range jtis {
+		if jti == s.TokenID {
+			continue
+		}
+		if err := redisrepo.DenyToken(jti, time.Now().Add(ttl)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// touchSessions renews the heartbeat of the sessions of live clients
+func touchSessions(alive []*Client) {
+	sessions := make(map[string][]string)
+	for _, client := range alive {
+		sessions[client.Username] = append(sessions[client.Username], client.SessionID)
+	}
+	redisrepo.TouchSessions(sessions)
+}
@@ -0,0 +1,52 @@
+package ws
+
+import (
+	"testing"
+
+	"gochatapp/model"
+	auth "gochatapp/pkg/middleware"
+	"gochatapp/pkg/redisrepo"
+	"gochatapp/utils"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestRevokeSessionRevokesItsTokens(t *testing.T) {
+	_, mock := setup(t)
+
+	token, _ := utils.CreateJWT("user1", "jti-1")
+	claims, err := auth.ValidateToken(token)
+	if err != nil {
+		t.Fatal("expected a fresh token to be valid", err)
+	}
+	redisrepo.AddSession("user1", &model.Session{
+		ID:             "s1",
+		TokenID:        claims.TokenID,
+		TokenExpiresAt: float64(claims.ExpiresAt.Unix()),
+	})
+
+	// The device refreshed since it connected, and the user has another device
+	refreshed, _ := utils.CreateJWT("user1", "jti-2")
+	other, _ := utils.CreateJWT("user1", "jti-3")
+
+	mock.ExpectQuery("UPDATE refresh_tokens SET revoked_at").
+		WithArgs("jti-1", "user1", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"access_jti"}).AddRow("jti-1").AddRow("jti-2"))
+
+	if err := RevokeSession("user1", "s1"); err != nil {
+		t.Fatal("error revoking session", err)
+	}
+
+	if _, err := auth.ValidateToken(token); err == nil {
+		t.Error("expected the revoked session's token to be rejected")
+	}
+	if _, err := auth.ValidateToken(refreshed); err == nil {
+		t.Error("expected tokens refreshed from the session's login to be rejected")
+	}
+	if _, err := auth.ValidateToken(other); err != nil {
+		t.Error("expected the user's other device to stay signed in", err)
+	}
+	if s, _ := redisrepo.FetchSession("user1", "s1"); s != nil {
+		t.Error("expected the session to be removed")
+	}
+}
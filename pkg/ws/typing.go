@@ -0,0 +1,148 @@
+package ws
+
+import (
+	"log"
+	"time"
+
+	"gochatapp/model"
+	"gochatapp/pkg/db"
+)
+
+const (
+	// typingTTL is how long an indicator lasts without a refresh; clients
+	// resend typing_start every few seconds while the user keeps typing
+	typingTTL = 5 * time.Second
+	// typingMinInterval throttles the typing frames of a single client
+	typingMinInterval = 250 * time.Millisecond
+)
+
+// typingState is an indicator the client has running in one conversation
+type typingState struct {
+	to    []string
+	timer *time.Timer
+}
+
+// handleTyping processes "typing_start" and "typing_stop" frames carrying a
+// conversation_id. Indicators are relayed through the fan-out layer and never
+// stored; a start only goes out when the indicator turns on, and refreshes
+// just push back its expiry.
+func handleTyping(client *Client, m Message) {
+	if m.ConversationID == "" {
+		client.writeJSON(Message{
+			Type:  "error",
+			Error: "Typing indicator must include conversation_id",
+		})
+		return
+	}
+
+	client.typingMu.Lock()
+	state, typing := client.typing[m.ConversationID]
+	// A stop that ends a running indicator always gets through
+	throttled := time.Since(client.lastTyping) < typingMinInterval && !(typing && m.Type == "typing_stop")
+	if !throttled {
+		client.lastTyping = time.Now()
+		if typing && m.Type == "typing_start" {
+			state.timer.Reset(typingTTL)
+		}
+	}
+	client.typingMu.Unlock()
+
+	if throttled || (typing && m.Type == "typing_start") {
+		return
+	}
+
+	if m.Type == "typing_stop" {
+		stopTyping(client, m.ConversationID, nil)
+		return
+	}
+
+	conv, err := db.FetchConversation(db.DB, m.ConversationID, client.Username)
+	if err != nil {
+		log.Printf("Error resolving typing conversation %s for %s: %v", m.ConversationID, client.Username, err)
+		errMsg := "Failed to send typing indicator"
+		if err == db.ErrNotConversationMember {
+			errMsg = "You are not a member of this conversation"
+		}
+		client.writeJSON(Message{
+			Type:  "error",
+			Error: errMsg,
+		})
+		return
+	}
+
+	usernames, err := members(&model.Chat{From: client.Username, To: conv.Peer, GroupID: conv.GroupID})
+	if err != nil {
+		log.Printf("Error resolving members of %s for typing: %v", m.ConversationID, err)
+		return
+	}
+	// Users blocked either way don't see each other typing
+	blocked, err := db.FetchBlockedBetween(db.DB, client.Username, usernames)
+	if err != nil {
+		log.Printf("Error fetching blocks of %s for typing: %v", client.Username, err)
+		return
+	}
+	to := make([]string, 0, len(usernames))
+	for _, username := range usernames {
+		if username != client.Username && !blocked[username] {
+			to = append(to, username)
+		}
+	}
+
+	state = &typingState{to: to}
+	state.timer = time.AfterFunc(typingTTL, func() {
+		stopTyping(client, m.ConversationID, state)
+	})
+
+	client.typingMu.Lock()
+	if client.typing == nil {
+		client.typing = make(map[string]*typingState)
+	}
+	client.typing[m.ConversationID] = state
+	client.typingMu.Unlock()
+
+	publishTyping(client, "typing_start", m.ConversationID, to)
+}
+
+// stopTyping turns off the client's indicator in a conversation. With only
+// set, it does so only if that indicator is still the running one, so an
+// expiry racing a restart doesn't end the new indicator.
+func stopTyping(client *Client, conversationID string, only *typingState) {
+	client.typingMu.Lock()
+	state, typing := client.typing[conversationID]
+	if !typing || (only != nil && state != only) {
+		client.typingMu.Unlock()
+		return
+	}
+	state.timer.Stop()
+	delete(client.typing, conversationID)
+	client.typingMu.Unlock()
+
+	publishTyping(client, "typing_stop", conversationID, state.to)
+}
+
+// stopAllTyping turns off every indicator of a client that disconnects
+func stopAllTyping(client *Client) {
+	client.typingMu.Lock()
+	conversationIDs := make([]string, 0, len(client.typing))
+	for conversationID := range client.typing {
+		conversationIDs = append(conversationIDs, conversationID)
+	}
+	client.typingMu.Unlock()
+
+	for _, conversationID := range conversationIDs {
+		stopTyping(client, conversationID, nil)
+	}
+}
+
+func publishTyping(client *Client, frame, conversationID string, to []string) {
+	if len(to) == 0 {
+		return
+	}
+	if err := publish(Message{
+		Type:           frame,
+		User:           client.Username,
+		ConversationID: conversationID,
+	}, to...); err != nil {
+		log.Printf("Error publishing %s from %s: %v", frame, client.Username, err)
+	}
+}
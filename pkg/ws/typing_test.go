@@ -0,0 +1,145 @@
+package ws
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"gochatapp/model"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// expectBlocks mocks finding which of the others username blocked or is
+// blocked by
+func expectBlocks(mock sqlmock.Sqlmock, username string, blocked ...string) {
+	rows := sqlmock.NewRows([]string{"blocked"})
+	for _, other := range blocked {
+		rows.AddRow(other)
+	}
+	mock.ExpectQuery("SELECT blocked FROM blocks").WithArgs(username, sqlmock.AnyArg()).WillReturnRows(rows)
+}
+
+// unthrottle lets the client's next typing frame through at once
+func unthrottle(client *Client) {
+	client.typingMu.Lock()
+	client.lastTyping = time.Time{}
+	client.typingMu.Unlock()
+}
+
+func TestTypingIsRelayedOnlyWhenItChanges(t *testing.T) {
+	_, mock := setup(t)
+	rec := &recordingBus{}
+	bus = rec
+	client, _ := connect(t, "user1")
+	t.Cleanup(func() { stopAllTyping(client) })
+
+	expectMember(mock, "3", "user1", "user2", true)
+	expectBlocks(mock, "user1")
+	handleTyping(client, Message{Type: "typing_start", ConversationID: "3"})
+
+	// A refresh only pushes back the expiry
+	unthrottle(client)
+	handleTyping(client, Message{Type: "typing_start", ConversationID: "3"})
+
+	unthrottle(client)
+	handleTyping(client, Message{Type: "typing_stop", ConversationID: "3"})
+	// A second stop has nothing left to end
+	unthrottle(client)
+	handleTyping(client, Message{Type: "typing_stop", ConversationID: "3"})
+
+	if to := rec.recipientsOf("typing_start"); fmt.Sprint(to) != "[[user2]]" {
+		t.Errorf("expected one typing_start to user2, got %v", to)
+	}
+	if to := rec.recipientsOf("typing_stop"); fmt.Sprint(to) != "[[user2]]" {
+		t.Errorf("expected one typing_stop to user2, got %v", to)
+	}
+	if frames := rec.frames(t, "typing_start"); len(frames) != 1 || frames[0].User != "user1" || frames[0].ConversationID != "3" {
+		t.Errorf("unexpected typing_start frames %+v", frames)
+	}
+}
+
+func TestTypingExpires(t *testing.T) {
+	_, mock := setup(t)
+	rec := &recordingBus{}
+	bus = rec
+	client, _ := connect(t, "user1")
+	t.Cleanup(func() { stopAllTyping(client) })
+
+	expectMember(mock, "3", "user1", "user2", true)
+	expectBlocks(mock, "user1")
+	handleTyping(client, Message{Type: "typing_start", ConversationID: "3"})
+
+	client.typingMu.Lock()
+	first := client.typing["3"]
+	client.typingMu.Unlock()
+
+	// The expiry of an indicator that was since restarted leaves the new one
+	stopTyping(client, "3", &typingState{})
+	if frames := rec.frames(t, "typing_stop"); len(frames) != 0 {
+		t.Fatalf("expected a stale expiry to be ignored, got %+v", frames)
+	}
+
+	// What the timer runs once typingTTL passes without a refresh
+	stopTyping(client, "3", first)
+	if to := rec.recipientsOf("typing_stop"); fmt.Sprint(to) != "[[user2]]" {
+		t.Errorf("expected the indicator to expire for user2, got %v", to)
+	}
+}
+
+func TestTypingIsThrottled(t *testing.T) {
+	_, mock := setup(t)
+	rec := &recordingBus{}
+	bus = rec
+	client, _ := connect(t, "user1")
+	t.Cleanup(func() { stopAllTyping(client) })
+
+	expectMember(mock, "3", "user1", "user2", true)
+	expectBlocks(mock, "user1")
+	handleTyping(client, Message{Type: "typing_start", ConversationID: "3"})
+	// Right after, a start elsewhere is dropped
+	handleTyping(client, Message{Type: "typing_start", ConversationID: "4"})
+	// but the stop of a running indicator always gets through
+	handleTyping(client, Message{Type: "typing_stop", ConversationID: "3"})
+
+	if to := rec.recipientsOf("typing_start"); len(to) != 1 {
+		t.Errorf("expected the second start to be throttled, got %v", to)
+	}
+	if to := rec.recipientsOf("typing_stop"); len(to) != 1 {
+		t.Errorf("expected the stop to get through, got %v", to)
+	}
+}
+
+func TestTypingSkipsBlockedMembers(t *testing.T) {
+	_, mock := setup(t)
+	rec := &recordingBus{}
+	bus = rec
+	client, peer := connect(t, "user1")
+	t.Cleanup(func() { stopAllTyping(client) })
+
+	mock.ExpectQuery("FROM conversations c").
+		WithArgs("user1", "9").
+		WillReturnRows(sqlmock.NewRows(conversationColumns).
+			AddRow("9", model.ConversationGroup, "", "4", "friends", 1700000000.0, false, false, false, "0", 0))
+	expectGroupMembers(mock, "4", "user1", "user2", "user3")
+	expectBlocks(mock, "user1", "user3")
+	handleTyping(client, Message{Type: "typing_start", ConversationID: "9"})
+
+	if to := rec.recipientsOf("typing_start"); fmt.Sprint(to) != "[[user2]]" {
+		t.Errorf("expected only user2 to see user1 typing, got %v", to)
+	}
+
+	// Disconnecting ends every running indicator
+	stopAllTyping(client)
+	if to := rec.recipientsOf("typing_stop"); fmt.Sprint(to) != "[[user2]]" {
+		t.Errorf("expected the indicator to stop for user2, got %v", to)
+	}
+
+	// Outsiders can't type into a conversation
+	unthrottle(client)
+	expectMember(mock, "3", "user1", "", false)
+	handleTyping(client, Message{Type: "typing_start", ConversationID: "3"})
+	if m := readFrame(t, peer); m.Type != "error" {
+		t.Errorf("expected an outsider's typing to be rejected, got %+v", m)
+	}
+}
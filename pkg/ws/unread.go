@@ -0,0 +1,92 @@
+package ws
+
+import (
+	"log"
+
+	"gochatapp/model"
+	"gochatapp/pkg/db"
+	"gochatapp/pkg/redisrepo"
+)
+
+// countUnread counts a new chat against its recipients and pushes them their
+// new counts
+func countUnread(chat *model.Chat, to []string) {
+	if err := redisrepo.IncrementUnread(chat.ConversationID, to...); err != nil {
+		// The cached counts are stale now; start them over from PostgreSQL
+		redisrepo.ResetUnread(to...)
+	}
+	for _, username := range to {
+		pushUnread(username, chat.ConversationID)
+	}
+}
+
+// pushUnread sends every device of a user an "unread" frame with the count of
+// a conversation and their total badge count
+func pushUnread(username, conversationID string) *model.UnreadCount {
+	counts, err := redisrepo.FetchUnreadCounts(username)
+	if err != nil {
+		log.Printf("Error fetching unread counts of %s: %v", username, err)
+		return nil
+	}
+
+	u := &model.UnreadCount{
+		ConversationID: conversationID,
+		Unread:         counts[conversationID],
+		Total:          redisrepo.TotalUnread(counts),
+	}
+	if err := publish(Message{Type: "unread", Unread: u}, username); err != nil {
+		log.Printf("Error publishing unread count to %s: %v", username, err)
+	}
+	return u
+}
+
+// MarkConversationRead moves the user's read marker in a conversation up to
+// a message, or to its latest one if upTo is empty. Senders get read receipts
+// for the direct messages it covers and the user's devices get the new
+// counts. It backs the "mark_read" frame and the REST endpoint.
+func MarkConversationRead(username, conversationID, upTo string) (*model.UnreadCount, error) {
+	chats, unread, err := db.MarkConversationRead(db.DB, conversationID, username, upTo)
+	if err != nil {
+		return nil, err
+	}
+	redisrepo.SetUnread(username, conversationID, unread)
+
+	if read := chatIDs(chats); len(read) > 0 {
+		if err := publish(Message{Type: "read", User: username, MessageIDs: read}, username); err != nil {
+			log.Printf("Error syncing read messages of %s: %v", username, err)
+		}
+	}
+	publishReadReceipts(username, chats)
+
+	if u := pushUnread(username, conversationID); u != nil {
+		return u, nil
+	}
+	return &model.UnreadCount{ConversationID: conversationID, Unread: unread}, nil
+}
+
+// handleMarkRead processes a "mark_read" frame carrying conversation_id and
+// optionally up_to
+func handleMarkRead(client *Client, m Message) {
+	if m.ConversationID == "" {
+		client.writeJSON(Message{
+			Type:  "error",
+			Error: "Mark read must include conversation_id",
+		})
+		return
+	}
+
+	if _, err := MarkConversationRead(client.Username, m.ConversationID, m.UpTo); err != nil {
+		log.Printf("Error marking %s read for %s: %v", m.ConversationID, client.Username, err)
+		errMsg := "Failed to mark conversation as read"
+		switch err {
+		case db.ErrNotConversationMember:
+			errMsg = "You are not a member of this conversation"
+		case db.ErrChatNotFound:
+			errMsg = "Invalid up_to"
+		}
+		client.writeJSON(Message{
+			Type:  "error",
+			Error: errMsg,
+		})
+	}
+}
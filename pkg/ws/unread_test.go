@@ -0,0 +1,95 @@
+package ws
+
+import (
+	"fmt"
+	"testing"
+
+	"gochatapp/model"
+	"gochatapp/pkg/redisrepo"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// expectUnreadCounts has PostgreSQL return a user's unread counts
+func expectUnreadCounts(mock sqlmock.Sqlmock, username string, counts ...string) {
+	rows := sqlmock.NewRows([]string{"conversation_id", "count"})
+	for i := 0; i < len(counts); i += 2 {
+		rows.AddRow(counts[i], counts[i+1])
+	}
+	mock.ExpectQuery("FROM conversation_members cm").WithArgs(username).WillReturnRows(rows)
+}
+
+func TestCountUnread(t *testing.T) {
+	_, mock := setup(t)
+	rec := &recordingBus{}
+	bus = rec
+
+	// user2's counts are cached, user3's aren't
+	expectUnreadCounts(mock, "user2", "3", "1", "4", "2")
+	redisrepo.FetchUnreadCounts("user2")
+
+	// user3's are loaded from PostgreSQL, which already holds the new chat
+	expectUnreadCounts(mock, "user3", "3", "1")
+	countUnread(&model.Chat{ID: "9", ConversationID: "3"}, []string{"user2", "user3"})
+
+	frames := rec.frames(t, "unread")
+	if len(frames) != 2 {
+		t.Fatalf("expected an unread frame per recipient, got %d", len(frames))
+	}
+	if u := frames[0].Unread; u.ConversationID != "3" || u.Unread != 2 || u.Total != 4 {
+		t.Errorf("expected user2 to have 2 unread of 4, got %+v", u)
+	}
+	if u := frames[1].Unread; u.Unread != 1 || u.Total != 1 {
+		t.Errorf("expected user3 to have 1 unread of 1, got %+v", u)
+	}
+	if to := rec.recipientsOf("unread"); fmt.Sprint(to) != "[[user2] [user3]]" {
+		t.Errorf("expected each recipient to get their own counts, got %v", to)
+	}
+}
+
+func TestMarkConversationRead(t *testing.T) {
+	_, mock := setup(t)
+	rec := &recordingBus{}
+	bus = rec
+
+	expectUnreadCounts(mock, "user2", "3", "2", "4", "1")
+	redisrepo.FetchUnreadCounts("user2")
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT last_read_message_id").
+		WithArgs("3", "user2").
+		WillReturnRows(sqlmock.NewRows([]string{"last_read_message_id"}).AddRow(7))
+	mock.ExpectQuery("SELECT id FROM messages").
+		WithArgs("9", "3").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(9))
+	mock.ExpectExec("UPDATE conversation_members SET last_read_message_id").
+		WithArgs("3", "user2", int64(9)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("UPDATE messages").
+		WithArgs("3", "user2", int64(9)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "sender", "receiver"}).
+			AddRow("8", "user1", "user2").
+			AddRow("9", "user1", "user2"))
+	mock.ExpectQuery("SELECT COUNT").
+		WithArgs("3", "user2").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectCommit()
+
+	u, err := MarkConversationRead("user2", "3", "9")
+	if err != nil {
+		t.Fatal("error marking conversation read", err)
+	}
+	if u.Unread != 0 || u.Total != 1 {
+		t.Errorf("expected nothing unread left but the other conversation, got %+v", u)
+	}
+
+	// The sender gets one receipt for both chats, the reader's devices sync
+	reads := rec.frames(t, "read")
+	to := rec.recipientsOf("read")
+	if len(reads) != 2 || fmt.Sprint(to) != "[[user2] [user1]]" {
+		t.Fatalf("expected a read sync and a receipt, got %v", to)
+	}
+	if fmt.Sprint(reads[1].MessageIDs) != "[8 9]" || reads[1].User != "user2" {
+		t.Errorf("expected a receipt from user2 for [8 9], got %+v", reads[1])
+	}
+}
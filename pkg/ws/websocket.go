@@ -8,6 +8,8 @@ import (
 	"time"
 
 	"gochatapp/model"
+	"gochatapp/pkg/db"
+	auth "gochatapp/pkg/middleware"
 	"gochatapp/pkg/redisrepo"
 
 	"github.com/gorilla/websocket"
@@ -15,11 +17,24 @@ import (
 
 type Client struct {
 	Conn       *websocket.Conn
-	Username   string
+	Username   string // bound to the "username" claim of the token used on upgrade
 	mu         sync.Mutex
 	Registered bool
-	// Track previous usernames for switching capability
-	PreviousUsernames []string
+
+	// A user can be connected from several devices, each its own session
+	SessionID   string
+	Device      string
+	ConnectedAt time.Time
+	token       *auth.Claims // the socket was opened with, revoked with the session
+
+	// Typing indicators the client has running, keyed by conversation ID
+	typingMu   sync.Mutex
+	typing     map[string]*typingState
+	lastTyping time.Time
+
+	presenceMu sync.Mutex
+	status     string // presence last recorded for this connection
+	lastActive time.Time
 }
 
 type Message struct {
@@ -29,6 +44,23 @@ type Message struct {
 	Error      string      `json:"error,omitempty"`
 	SwitchTo   string      `json:"switch_to,omitempty"`   // New field for identity switching
 	SwitchFrom string      `json:"switch_from,omitempty"` // Track previous identity
+	// Receipts: a client sends "read" with message_id, or up_to to mark everything
+	// from that message's sender up to it; the server sends "delivered" and
+	// "read" with message_ids back to the original sender. Receipts are only
+	// kept for direct messages; "read" on a group message is answered with an
+	// error, and groups are marked read with "mark_read".
+	MessageID  string   `json:"message_id,omitempty"`
+	UpTo       string   `json:"up_to,omitempty"`
+	MessageIDs []string `json:"message_ids,omitempty"`
+	Scope      string   `json:"scope,omitempty"` // "me" or "everyone" on delete frames
+	Emoji      string   `json:"emoji,omitempty"` // on react and unreact frames
+	// Typing indicators are addressed to a conversation
+	ConversationID string             `json:"conversation_id,omitempty"`
+	Presence       *model.Presence    `json:"presence,omitempty"`
+	SessionID      string             `json:"session_id,omitempty"`  // sent with the initial ack
+	RetryAfter     int                `json:"retry_after,omitempty"` // seconds, on rate_limited errors
+	Unread         *model.UnreadCount `json:"unread,omitempty"`      // on unread frames
+	Preview        *model.LinkPreview `json:"preview,omitempty"`     // on preview frames, nil when removed
 }
 
 var (
@@ -36,17 +68,25 @@ var (
 	clients   = make(map[*Client]bool)
 	clientsMu sync.RWMutex
 
-	// Username lookup map - maps usernames to client pointers for quick lookups
-	usernameMap   = make(map[string]*Client)
+	// Username lookup map - maps usernames to the sockets of all their devices
+	usernameMap   = make(map[string]map[*Client]bool)
 	usernameMu    sync.RWMutex
 	
-	broadcast = make(chan *model.Chat, 256)
+	broadcast = make(chan *outgoing, 256)
 )
 
+// outgoing is a saved chat waiting to be published to its recipients
+type outgoing struct {
+	chat *model.Chat
+	to   []string
+}
+
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
 	CheckOrigin:     func(r *http.Request) bool { return true },
+	// Echo the token marker back so browsers accept the handshake
+	Subprotocols: []string{tokenSubprotocol},
 }
 
 func (c *Client) writeJSON(v interface{}) error {
@@ -55,10 +95,23 @@ func (c *Client) writeJSON(v interface{}) error {
 	return c.Conn.WriteJSON(v)
 }
 
-// ServeWs handles the initial WebSocket connection
+func (c *Client) writeRaw(payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Conn.WriteMessage(websocket.TextMessage, payload)
+}
+
+// ServeWs handles the initial WebSocket connection. The upgrade requires a
+// valid JWT, and the connection is bound to the token's username for its lifetime.
 func ServeWs(w http.ResponseWriter, r *http.Request) {
-	username := r.URL.Query().Get("username")
+	claims, err := authenticate(r)
+	if err != nil {
+		log.Printf("Rejected WebSocket connection from %s: %v", r.RemoteAddr, err)
+		http.Error(w, "Invalid or expired token", http.StatusUnauthorized)
+		return
+	}
 
+	username := claims.Username
 	log.Printf("WebSocket connection request from username %s", username)
 
 	ws, err := upgrader.Upgrade(w, r, nil)
@@ -68,39 +121,19 @@ func ServeWs(w http.ResponseWriter, r *http.Request) {
 	}
 
 	client := &Client{
-		Conn:       ws,
-		Username:   username,
-		Registered: username != "",
-		PreviousUsernames: []string{},
+		Conn:        ws,
+		Username:    username,
+		Registered:  true,
+		SessionID:   newSessionID(),
+		Device:      deviceName(r),
+		ConnectedAt: time.Now(),
+		token:       claims,
 	}
-	
+
 	log.Printf("New client connected from %s", ws.RemoteAddr())
-	if username != "" {
-		log.Printf("Username from query param: %s", username)
-	}
-	
-	// Register client in global map
-	clientsMu.Lock()
-	clients[client] = true
-	clientsMu.Unlock()
-
-	// Register in username map if username provided
-	if username != "" {
-		usernameMu.Lock()
-		// If there's an existing client with this username, mark it inactive
-		if existingClient, found := usernameMap[username]; found {
-			log.Printf("Warning: Username %s is already in use, replacing connection", username)
-			// Notify the existing client they're being disconnected
-			existingClient.writeJSON(Message{
-				Type:  "error",
-				Error: "Your session has been taken over by a new connection",
-			})
-			// Close the existing connection
-			existingClient.Conn.Close()
-		}
-		usernameMap[username] = client
-		usernameMu.Unlock()
-	}
+
+	// Register client alongside the user's other devices
+	register(client)
 
 	// Set initial read deadline
 	ws.SetReadDeadline(time.Now().Add(60 * time.Second))
@@ -113,21 +146,15 @@ func ServeWs(w http.ResponseWriter, r *http.Request) {
 func handleClient(client *Client) {
 	// Ensure client cleanup on exit
 	defer func() {
-		// Clean up client from clients map
-		clientsMu.Lock()
-		delete(clients, client)
-		clientsMu.Unlock()
-		
-		// Clean up client from username map
-		if client.Username != "" {
-			usernameMu.Lock()
-			// Only delete if this client still owns this username
-			if currentClient, found := usernameMap[client.Username]; found && currentClient == client {
-				delete(usernameMap, client.Username)
-			}
-			usernameMu.Unlock()
-		}
-		
+		// Clean up client from the maps and the user's session list
+		unregister(client)
+		redisrepo.RemoveSession(client.Username, client.SessionID)
+
+		stopAllTyping(client)
+
+		// The user stays online while another of their devices is connected
+		setPresence(client, model.PresenceOffline)
+
 		client.Conn.Close()
 		log.Printf("Client disconnected: %s", client.Username)
 	}()
@@ -137,16 +164,25 @@ func handleClient(client *Client) {
 		return client.Conn.SetReadDeadline(time.Now().Add(60 * time.Second))
 	})
 
-	// If we already have a username from the query param, send an immediate acknowledgment
+	// The username comes from the token, so acknowledge the registration immediately
 	if client.Username != "" && client.Registered {
 		if err := client.writeJSON(Message{
-			Type: "ack",
-			User: client.Username,
+			Type:      "ack",
+			User:      client.Username,
+			SessionID: client.SessionID,
 		}); err != nil {
 			log.Printf("Error sending initial ack to %s: %v", client.Username, err)
 			return
 		}
 		log.Printf("Auto-registered client with username: %s", client.Username)
+
+		markActive(client)
+		setPresence(client, model.PresenceOnline)
+
+		// Hand off anything that arrived while the user was offline
+		if err := deliverPending(client); err != nil {
+			log.Printf("Error delivering pending messages to %s: %v", client.Username, err)
+		}
 	}
 
 	// Main message processing loop
@@ -174,69 +210,31 @@ func handleClient(client *Client) {
 			continue
 		}
 
+		if !allowFrame(client, m) {
+			continue
+		}
+
+		// Any frame but an explicit presence report counts as activity
+		if m.Type != "presence" {
+			markActive(client)
+		}
+
 		msgJSON, _ := json.MarshalIndent(m, "", "  ")
 		log.Printf("Received message from %s:\n%s", client.Username, msgJSON)
 		
 		// Handle message based on type
 		switch m.Type {
 		case "bootup":
-			// Handle registration/bootup message
-			if m.User == "" {
-				log.Printf("Received empty username in bootup")
+			// The identity is fixed by the token on upgrade; bootup only confirms it
+			if m.User != "" && m.User != client.Username {
+				log.Printf("Rejected bootup as %s from client authenticated as %s", m.User, client.Username)
 				client.writeJSON(Message{
-					Type: "error",
-					Error: "Username cannot be empty",
-				})
-				continue
-			}
-			
-			// Store previous username if switching
-			if client.Username != "" && client.Username != m.User {
-				oldUsername := client.Username
-				// Add to previous usernames list if not already there
-				found := false
-				for _, name := range client.PreviousUsernames {
-					if name == oldUsername {
-						found = true
-						break
-					}
-				}
-				if !found {
-					client.PreviousUsernames = append(client.PreviousUsernames, oldUsername)
-				}
-				
-				// Remove from username map
-				usernameMu.Lock()
-				if currentClient, found := usernameMap[oldUsername]; found && currentClient == client {
-					delete(usernameMap, oldUsername)
-				}
-				usernameMu.Unlock()
-				
-				log.Printf("Client switching from %s to %s", oldUsername, m.User)
-			}
-			
-			// Set new username and registration status
-			client.Username = m.User
-			client.Registered = true
-			
-			// Add to username map
-			usernameMu.Lock()
-			// Handle case where username is already in use
-			if existingClient, found := usernameMap[m.User]; found && existingClient != client {
-				log.Printf("Warning: Username %s is already in use, replacing connection", m.User)
-				// Notify the existing client they're being disconnected
-				existingClient.writeJSON(Message{
 					Type:  "error",
-					Error: "Your session has been taken over by a new connection",
+					Error: "Username does not match the authenticated user",
 				})
-				// Close the existing connection
-				existingClient.Conn.Close()
+				continue
 			}
-			usernameMap[m.User] = client
-			usernameMu.Unlock()
-			
-			log.Printf("Client registered with username: %s", client.Username)
-			
+
 			// Send acknowledgment
 			if err := client.writeJSON(Message{
 				Type: "ack",
@@ -247,61 +245,16 @@ func handleClient(client *Client) {
 			}
 
 		case "switch_user":
-			// New message type to handle switching between identities
-			if m.SwitchTo == "" {
+			// Switching identities requires a new connection with that user's token
+			if m.SwitchTo != client.Username {
+				log.Printf("Rejected switch from %s to %s", client.Username, m.SwitchTo)
 				client.writeJSON(Message{
 					Type:  "error",
-					Error: "Switch_to username cannot be empty",
+					Error: "Cannot switch to another user on this connection",
 				})
 				continue
 			}
-			
-			// Store current username in previous list if switching
-			if client.Username != "" && client.Username != m.SwitchTo {
-				oldUsername := client.Username
-				// Add to previous usernames list if not already there
-				found := false
-				for _, name := range client.PreviousUsernames {
-					if name == oldUsername {
-						found = true
-						break
-					}
-				}
-				if !found {
-					client.PreviousUsernames = append(client.PreviousUsernames, oldUsername)
-				}
-				
-				// Remove from username map
-				usernameMu.Lock()
-				if currentClient, found := usernameMap[oldUsername]; found && currentClient == client {
-					delete(usernameMap, oldUsername)
-				}
-				usernameMu.Unlock()
-				
-				log.Printf("Client switching from %s to %s", oldUsername, m.SwitchTo)
-			}
-			
-			// Set new username
-			client.Username = m.SwitchTo
-			client.Registered = true
-			
-			// Add to username map
-			usernameMu.Lock()
-			// Handle case where username is already in use
-			if existingClient, found := usernameMap[m.SwitchTo]; found && existingClient != client {
-				log.Printf("Warning: Username %s is already in use, replacing connection", m.SwitchTo)
-				// Notify the existing client they're being disconnected
-				existingClient.writeJSON(Message{
-					Type:  "error",
-					Error: "Your session has been taken over by a new connection",
-				})
-				// Close the existing connection
-				existingClient.Conn.Close()
-			}
-			usernameMap[m.SwitchTo] = client
-			usernameMu.Unlock()
-			
-			// Send acknowledgment with previous identity info
+
 			if err := client.writeJSON(Message{
 				Type:       "switch_ack",
 				User:       client.Username,
@@ -310,8 +263,27 @@ func handleClient(client *Client) {
 				log.Printf("Error sending switch ack to %s: %v", client.Username, err)
 				return
 			}
-			
-			log.Printf("Client switched identity to: %s", client.Username)
+
+		case "read":
+			handleRead(client, m)
+
+		case "mark_read":
+			handleMarkRead(client, m)
+
+		case "edit":
+			handleEdit(client, m)
+
+		case "delete":
+			handleDelete(client, m)
+
+		case "react", "unreact":
+			handleReaction(client, m)
+
+		case "typing_start", "typing_stop":
+			handleTyping(client, m)
+
+		case "presence":
+			handlePresence(client, m)
 
 		case "chat":
 			// Handle chat message
@@ -333,29 +305,86 @@ func handleClient(client *Client) {
 				continue
 			}
 
+			// The sender is always the authenticated user
+			if m.Chat.From == "" {
+				m.Chat.From = client.Username
+			}
+			if m.Chat.From != client.Username {
+				log.Printf("Rejected message from '%s' sent by authenticated user '%s'",
+					m.Chat.From, client.Username)
+				client.writeJSON(Message{
+					Type:  "error",
+					Error: "Sender does not match the authenticated user",
+				})
+				continue
+			}
+
+			// Edit, deletion, quote and preview state is set by the server only
+			m.Chat.Edited, m.Chat.EditedAt, m.Chat.Deleted, m.Chat.Quote = false, 0, false, nil
+			m.Chat.Preview = nil
+
 			// Validate message fields
-			if m.Chat.From == "" || m.Chat.To == "" || m.Chat.Msg == "" {
+			if (m.Chat.To == "" && m.Chat.GroupID == "" && m.Chat.ConversationID == "") || (m.Chat.Msg == "" && len(m.Chat.AttachmentIDs) == 0) {
 				log.Printf("Invalid chat message received from %s", client.Username)
 				client.writeJSON(Message{
 					Type: "error",
-					Error: "Message must include sender, recipient, and content",
+					Error: "Message must include recipient and content",
 				})
 				continue
 			}
 
-			// FIXED: Respect the specified 'From' field in the message
-			// We don't modify the message's From field anymore
-			// Just log who's actually sending it for debugging purposes
-			if m.Chat.From != client.Username {
-				log.Printf("Note: Message from field '%s' doesn't match current username '%s'", 
-					m.Chat.From, client.Username)
+			to, err := recipients(m.Chat)
+			if err != nil {
+				log.Printf("Error resolving recipients for %s: %v", client.Username, err)
+				errMsg := "Failed to send message"
+				switch err {
+				case db.ErrNotGroupMember, db.ErrNotConversationMember:
+					errMsg = "You are not a member of this conversation"
+				case errUnknownRecipient:
+					errMsg = "Invalid recipient"
+				case db.ErrBlocked:
+					errMsg = "You can't message this user"
+				case db.ErrMessagingRestricted:
+					errMsg = "This user only accepts messages from their contacts"
+				}
+				client.writeJSON(Message{
+					Type:  "error",
+					Error: errMsg,
+				})
+				continue
 			}
 
-			// Set timestamp if not already set
-			if m.Chat.Timestamp == 0 {
-				m.Chat.Timestamp = float64(time.Now().Unix())
+			if m.Chat.ReplyTo != "" {
+				if err := attachQuote(m.Chat); err != nil {
+					log.Printf("Error resolving reply_to %s for %s: %v", m.Chat.ReplyTo, client.Username, err)
+					errMsg := "Failed to send message"
+					if err == errInvalidReply {
+						errMsg = "Invalid reply_to"
+					}
+					client.writeJSON(Message{
+						Type:  "error",
+						Error: errMsg,
+					})
+					continue
+				}
 			}
 
+			if err := resolveAttachments(m.Chat); err != nil {
+				log.Printf("Error resolving attachments for %s: %v", client.Username, err)
+				errMsg := "Failed to send message"
+				if err == errInvalidAttachment {
+					errMsg = "Invalid attachment_ids"
+				}
+				client.writeJSON(Message{
+					Type:  "error",
+					Error: errMsg,
+				})
+				continue
+			}
+
+			// The server clock orders chats, whatever time the client claims
+			m.Chat.Timestamp = float64(time.Now().Unix())
+
 			// Save in Redis and get ID
 			id, err := redisrepo.CreateChat(m.Chat)
 			if err != nil {
@@ -368,17 +397,33 @@ func handleClient(client *Client) {
 			}
 			m.Chat.ID = id
 
+			// The stored copies keep no URLs, they expire
+			SignAttachments(m.Chat.Attachments)
+
+			// Queue for the recipients until one of their sockets receives it
+			for _, username := range to {
+				if err := redisrepo.EnqueuePending(username, m.Chat); err != nil {
+					log.Printf("Error queueing message %s for %s: %v", id, username, err)
+				}
+			}
+
+			go countUnread(m.Chat, to)
+			go previewLinks(*m.Chat)
+
 			// Broadcast message
 			select {
-			case broadcast <- m.Chat:
+			case broadcast <- &outgoing{chat: m.Chat, to: to}:
 				log.Printf("Message from %s to %s queued for broadcast (ID: %s)", 
 					m.Chat.From, m.Chat.To, m.Chat.ID)
 				
-				// Send immediate confirmation to sender
+				// Send immediate confirmation to sender, and a copy to their other devices
 				client.writeJSON(Message{
 					Type: "sent",
 					Chat: m.Chat,
 				})
+				if err := syncSent(client, Message{Type: "sent", Chat: m.Chat}); err != nil {
+					log.Printf("Error syncing message %s to other devices of %s: %v", id, client.Username, err)
+				}
 				
 			default:
 				log.Printf("Broadcast channel full, dropped message from %s to %s", 
@@ -392,62 +437,24 @@ func handleClient(client *Client) {
 	}
 }
 
-// Broadcaster distributes messages to recipients
+// Broadcaster publishes queued messages through the fan-out layer and
+// delivers events from every instance to the sockets held by this one
 func Broadcaster() {
+	go subscribe()
+
 	ticker := time.NewTicker(30 * time.Second)
 	defer ticker.Stop()
 
 	for {
 		select {
-		case message := <-broadcast:
-			delivered := false
-			
-			// Fast lookup for recipient by username
-			usernameMu.RLock()
-			recipientClient, found := usernameMap[message.To]
-			usernameMu.RUnlock()
-			
-			if found {
-				err := recipientClient.writeJSON(Message{
-					Type: "chat",
-					Chat: message,
-				})
-				
-				if err != nil {
-					log.Printf("Error delivering to %s: %v", message.To, err)
-					recipientClient.Conn.Close()
-					
-					// Clean up from maps
-					usernameMu.Lock()
-					if currentClient, stillExists := usernameMap[message.To]; stillExists && currentClient == recipientClient {
-						delete(usernameMap, message.To)
-					}
-					usernameMu.Unlock()
-					
-					clientsMu.Lock()
-					delete(clients, recipientClient)
-					clientsMu.Unlock()
-				} else {
-					delivered = true
-					log.Printf("Successfully delivered message %s to recipient %s", 
-						message.ID, message.To)
-				}
-			}
-			
-			// If same client is both sender and recipient, make sure they get the message once
-			if message.From == message.To {
-				delivered = true
-				log.Printf("Sender and recipient are the same user: %s", message.From)
-			}
-			
-			if !delivered {
-				log.Printf("Recipient %s not connected, message %s stored only", 
-					message.To, message.ID)
+		case out := <-broadcast:
+			if err := publish(Message{Type: "chat", Chat: out.chat}, out.to...); err != nil {
+				log.Printf("Error publishing message %s to %v: %v", out.chat.ID, out.to, err)
 			}
 
 		case <-ticker.C:
 			// Send ping to all clients
-			activeCount := 0
+			var alive []*Client
 			clientsMu.RLock()
 			for client := range clients {
 				if err := client.Conn.WriteControl(
@@ -457,11 +464,15 @@ func Broadcaster() {
 				); err != nil {
 					log.Printf("Error pinging %s: %v", client.Username, err)
 				} else {
-					activeCount++
+					alive = append(alive, client)
 				}
 			}
-			log.Printf("Ping sent to %d active clients", activeCount)
+			log.Printf("Ping sent to %d active clients", len(alive))
 			clientsMu.RUnlock()
+
+			// Keep the presence and sessions of live clients fresh off the broadcast loop
+			go refreshPresence(alive)
+			go touchSessions(alive)
 		}
 	}
 }
\ No newline at end of file
@@ -0,0 +1,132 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gochatapp/pkg/db"
+	"gochatapp/pkg/fanout"
+	"gochatapp/pkg/redisrepo"
+	"gochatapp/utils"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/websocket"
+)
+
+// setup points the package at an in-memory Redis, a mocked PostgreSQL and an
+// in-process bus for the duration of a test
+func setup(t *testing.T) (*miniredis.Miniredis, sqlmock.Sqlmock) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	redisrepo.UseClient(client)
+	t.Cleanup(func() { client.Close() })
+
+	conn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal("error creating sql mock", err)
+	}
+	db.DB = conn
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		conn.Close()
+	})
+
+	bus = fanout.NewMemoryBus()
+	utils.SecretKey = []byte("test-secret")
+	return mr, mock
+}
+
+// recordingBus keeps the frames published during a test
+type recordingBus struct {
+	mu     sync.Mutex
+	events []*fanout.Event
+}
+
+func (b *recordingBus) Publish(_ context.Context, e *fanout.Event) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.events = append(b.events, e)
+	return nil
+}
+
+func (b *recordingBus) Subscribe(ctx context.Context, _ func(*fanout.Event)) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// frames returns the published frames of a type, decoded
+func (b *recordingBus) frames(t *testing.T, typ string) []Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var frames []Message
+	for _, e := range b.events {
+		var m Message
+		if err := json.Unmarshal(e.Payload, &m); err != nil {
+			t.Fatal("error decoding frame", err)
+		}
+		if m.Type == typ {
+			frames = append(frames, m)
+		}
+	}
+	return frames
+}
+
+// recipientsOf returns who the published frames of a type were addressed to
+func (b *recordingBus) recipientsOf(typ string) [][]string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var to [][]string
+	for _, e := range b.events {
+		var m Message
+		if json.Unmarshal(e.Payload, &m) == nil && m.Type == typ {
+			to = append(to, e.To)
+		}
+	}
+	return to
+}
+
+// connect returns a client for username on a live socket, and the other end
+// of it, which reads the frames the server writes to the client
+func connect(t *testing.T, username string) (*Client, *websocket.Conn) {
+	conns := make(chan *websocket.Conn, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if conn, err := upgrader.Upgrade(w, r, nil); err == nil {
+			conns <- conn
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	peer, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal("error connecting", err)
+	}
+	conn := <-conns
+	t.Cleanup(func() {
+		peer.Close()
+		conn.Close()
+	})
+
+	return &Client{Conn: conn, Username: username, Registered: true, SessionID: "s-" + username}, peer
+}
+
+// readFrame reads the next frame written to the other end of a socket
+func readFrame(t *testing.T, peer *websocket.Conn) Message {
+	peer.SetReadDeadline(time.Now().Add(time.Second))
+	var m Message
+	if err := peer.ReadJSON(&m); err != nil {
+		t.Fatal("error reading frame", err)
+	}
+	return m
+}
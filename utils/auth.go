@@ -1,22 +1,28 @@
 package utils
 
 import (
-	"github.com/dgrijalva/jwt-go"
-	"golang.org/x/crypto/bcrypt"
-	"github.com/joho/godotenv"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"log"
 	"os"
+	"strconv"
 	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/joho/godotenv"
+	"golang.org/x/crypto/bcrypt"
 )
 
 // Initialize the SecretKey variable
 var SecretKey []byte
 
-// Load environment variables from .env file
+// Load environment variables from .env file, if there is one; the variables
+// may also come straight from the environment (e.g. in tests)
 func init() {
 	err := godotenv.Load()
 	if err != nil {
-		log.Fatal("Error loading .env file")
+		log.Println("No .env file loaded:", err)
 	}
 
 	// Get the SECRET_KEY from environment variables
@@ -38,17 +44,87 @@ func CheckPasswordHash(password, hash string) bool {
 	return err == nil
 }
 
-// CreateJWT generates a JWT token
-func CreateJWT(username string) (string, error) {
+// Default lifetimes of the tokens handed out on login, overridable with
+// ACCESS_TOKEN_TTL and REFRESH_TOKEN_TTL
+const (
+	defaultAccessTokenTTL  = 15 * time.Minute
+	defaultRefreshTokenTTL = 30 * 24 * time.Hour
+)
+
+// AccessTokenTTL is how long an access token is valid
+func AccessTokenTTL() time.Duration {
+	return DurationFromEnv("ACCESS_TOKEN_TTL", defaultAccessTokenTTL)
+}
+
+// RefreshTokenTTL is how long a refresh token can be exchanged
+func RefreshTokenTTL() time.Duration {
+	return DurationFromEnv("REFRESH_TOKEN_TTL", defaultRefreshTokenTTL)
+}
+
+// DurationFromEnv parses the environment variable key as a duration, e.g.
+// "15m", returning fallback if it's unset or invalid
+func DurationFromEnv(key string, fallback time.Duration) time.Duration {
+	if d, err := time.ParseDuration(os.Getenv(key)); err == nil && d > 0 {
+		return d
+	}
+	return fallback
+}
+
+// IntFromEnv parses the environment variable key as a positive integer,
+// returning fallback if it's unset or invalid
+func IntFromEnv(key string, fallback int) int {
+	if n, err := strconv.Atoi(os.Getenv(key)); err == nil && n > 0 {
+		return n
+	}
+	return fallback
+}
+
+// randomToken returns n random bytes, hex encoded
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// NewTokenID generates the jti of an access token
+func NewTokenID() (string, error) {
+	return randomToken(16)
+}
+
+// CreateJWT generates a short-lived access token. Each token carries a jti,
+// see NewTokenID, so it can be revoked on its own. iat has millisecond
+// precision so it can be told apart from a cutoff set in the same second.
+func CreateJWT(username, jti string) (string, error) {
+	now := time.Now()
 	claims := jwt.MapClaims{
 		"username": username,
-		"exp":      time.Now().Add(time.Hour * 24).Unix(),
+		"jti":      jti,
+		"iat":      float64(now.UnixMilli()) / 1000,
+		"exp":      now.Add(AccessTokenTTL()).Unix(),
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	return token.SignedString(SecretKey)
 }
 
+// CreateRefreshToken generates an opaque refresh token. Only its hash is
+// stored, so a leaked table can't be replayed.
+func CreateRefreshToken() (token, hash string, err error) {
+	token, err = randomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+	return token, HashToken(token), nil
+}
+
+// HashToken returns the SHA-256 hash refresh tokens are stored and looked up by
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
 // ValidateJWT validates the JWT token
 func ValidateJWT(tokenStr string) (jwt.MapClaims, error) {
 	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
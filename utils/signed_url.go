@@ -0,0 +1,43 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// SignPath returns path with expires and signature query parameters that
+// authorize fetching it, without a token, until ttl has passed
+func SignPath(path string, ttl time.Duration) string {
+	expires := strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)
+	q := url.Values{}
+	q.Set("expires", expires)
+	q.Set("signature", pathSignature(path, expires))
+	return path + "?" + q.Encode()
+}
+
+// VerifyPath checks the expires and signature parameters SignPath added to path
+func VerifyPath(path, expires, signature string) bool {
+	exp, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > exp {
+		return false
+	}
+	return hmac.Equal([]byte(signature), []byte(pathSignature(path, expires)))
+}
+
+// urlKey derives the key URLs are signed with from SecretKey, so a signature
+// can never pass for a token signature or the other way around
+func urlKey() []byte {
+	mac := hmac.New(sha256.New, SecretKey)
+	mac.Write([]byte("attachment-url"))
+	return mac.Sum(nil)
+}
+
+func pathSignature(path, expires string) string {
+	mac := hmac.New(sha256.New, urlKey())
+	mac.Write([]byte(path + "\n" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}